@@ -0,0 +1,28 @@
+package api
+
+import "time"
+
+type ConditionStatus string
+
+const (
+	ConditionTrue    ConditionStatus = "True"
+	ConditionFalse   ConditionStatus = "False"
+	ConditionUnknown ConditionStatus = "Unknown"
+)
+
+// Condition is an aspect of the observed state of the resource, listed in `status.conditions`.
+// Use the helpers in the `framework` package like `framework.SetCondition` to read and write conditions
+type Condition struct {
+	// Type is the name of the aspect like "Ready", that is unique within the resource
+	Type   string          `json:"type"`
+	Status ConditionStatus `json:"status"`
+	// Reason is the machine-readable reason for the last transition of the status, in CamelCase
+	Reason string `json:"reason,omitempty"`
+	// Message is the human-readable details of the last transition
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is when the status has changed last time
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// WaitCondition tells whether the resource has reached the state Store.Wait waits for
+type WaitCondition func(resource *Resource) (bool, error)
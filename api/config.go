@@ -13,4 +13,33 @@ type ConfigSpec struct {
 	CustomResourceDefinitions []CustomResourceDefinition `json:"customResourceDefinitions"`
 	// Source is the source of the remote config that is fetched and merged into this config
 	Source string `json:"source"`
+	// Backend is the uri of the datastore that persists resources and their logs, like "dynamodb://".
+	// Defaults to `source` when its scheme has a registered store, and otherwise to "dynamodb://"
+	Backend string `json:"backend"`
+	// AWS configures the AWS clients used by the dynamodb backend
+	AWS AWSConfig `json:"aws"`
+}
+
+type AWSConfig struct {
+	// Profile is the name of the profile in the AWS shared config. `div --profile` takes precedence
+	Profile string `json:"profile"`
+	// Region is the AWS region. `div --region` takes precedence
+	Region string `json:"region"`
+	// AssumeRoleARN is the ARN of the IAM role assumed by div, e.g. to access resources in another AWS account
+	AssumeRoleARN string `json:"assumeRoleARN"`
+	// Namespaces overrides settings per namespace, so that e.g. the production namespace can reside in another account
+	Namespaces map[string]AWSNamespaceConfig `json:"namespaces"`
+	// Endpoints overrides the endpoint url per AWS service, so that you can use e.g. DynamoDB Local or LocalStack.
+	// Each endpoint can also be set via the envvar like DIV_DYNAMODB_ENDPOINT, which takes precedence
+	Endpoints AWSEndpoints `json:"endpoints"`
+}
+
+type AWSNamespaceConfig struct {
+	AssumeRoleARN string `json:"assumeRoleARN"`
+}
+
+type AWSEndpoints struct {
+	DynamoDB        string `json:"dynamodb"`
+	DynamoDBStreams string `json:"dynamodbstreams"`
+	CloudWatchLogs  string `json:"cloudwatchlogs"`
 }
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ErrResourceNotFound struct {
+	msg string
+}
+
+func NewErrResourceNotFound(msg string) *ErrResourceNotFound {
+	return &ErrResourceNotFound{msg}
+}
+
+func (e *ErrResourceNotFound) Error() string {
+	return e.msg
+}
+
+type ErrLogsNotFound struct {
+	msg string
+}
+
+func NewErrLogsNotFound(msg string) *ErrLogsNotFound {
+	return &ErrLogsNotFound{msg}
+}
+
+func (e *ErrLogsNotFound) Error() string {
+	return e.msg
+}
+
+// ErrConflict is returned when the resource has been modified since it was read.
+// Get the latest resource and retry
+type ErrConflict struct {
+	msg string
+}
+
+func NewErrConflict(msg string) *ErrConflict {
+	return &ErrConflict{msg}
+}
+
+func (e *ErrConflict) Error() string {
+	return e.msg
+}
+
+// ErrInvalid is returned when the resource doesn't conform to the schema of its kind.
+// Fix the resource and retry
+type ErrInvalid struct {
+	msg string
+	// Causes are the problems of the resource, each prefixed by the path to the field like "spec.project: Required value"
+	Causes []string
+}
+
+func NewErrInvalid(kind, name string, causes []string) *ErrInvalid {
+	msg := fmt.Sprintf(`%s "%s" is invalid: `, kind, name)
+	if len(causes) == 1 {
+		msg += causes[0]
+	} else {
+		msg += "[" + strings.Join(causes, ", ") + "]"
+	}
+	return &ErrInvalid{msg, causes}
+}
+
+func (e *ErrInvalid) Error() string {
+	return e.msg
+}
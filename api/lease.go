@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// LeaseResource is the built-in resource every store has without its definition.
+// Leases are written only via AcquireLease, RenewLease and ReleaseLease, and can be read like other resources
+const (
+	LeaseResource = "lease"
+	LeaseKind     = "Lease"
+)
+
+// LeaseSpec is the spec of a lease, that is held by at most one holder at a time until it expires
+type LeaseSpec struct {
+	// HolderIdentity is empty when the lease has been released
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+	// LeaseTransitions is incremented whenever the lease changes hands
+	LeaseTransitions int `json:"leaseTransitions"`
+}
+
+// LeaseSpecOf returns the spec of the lease resource
+func LeaseSpecOf(r *Resource) (*LeaseSpec, error) {
+	raw, err := json.Marshal(r.Spec)
+	if err != nil {
+		return nil, err
+	}
+	spec := &LeaseSpec{}
+	if err := json.Unmarshal(raw, spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// Expired returns true when the lease is free to be acquired by anyone at `now`.
+// Holders' clocks are compared with each other, so they must be roughly in sync
+func (s *LeaseSpec) Expired(now time.Time) bool {
+	if s.HolderIdentity == "" {
+		return true
+	}
+	return now.After(s.RenewTime.Add(time.Duration(s.LeaseDurationSeconds) * time.Second))
+}
+
+// NewLease returns the lease resource named `name` with the spec
+func NewLease(name string, spec LeaseSpec) (*Resource, error) {
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return &Resource{
+		NameHashKey: name,
+		Kind:        LeaseKind,
+		Metadata: Metadata{
+			Name: name,
+		},
+		Spec: m,
+	}, nil
+}
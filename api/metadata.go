@@ -8,4 +8,10 @@ type Metadata struct {
 	Labels            map[string]string `dynamo:"labels" json:"labels,omitempty"`
 	CreationTimestamp time.Time         `dynamo:"creationTimestamp" json:"creationTimestamp"`
 	UpdateTimestamp   time.Time         `dynamo:"updateTimestamp" json:"updateTimestamp"`
+	// ResourceVersion is incremented on every write.
+	// Writing a resource with a non-zero resourceVersion fails with ErrConflict unless it is the latest
+	ResourceVersion int64 `dynamo:"resourceVersion" json:"resourceVersion,omitempty"`
+	// Generation is incremented only when the spec changes, so that controllers can tell whether they have processed
+	// the latest desired state by comparing it to `status.observedGeneration`
+	Generation int64 `dynamo:"generation" json:"generation,omitempty"`
 }
@@ -12,9 +12,71 @@ type Resources []Resource
 type Resource struct {
 	NameHashKey string `dynamo:"name_hash_key,hash" json:"-"`
 
-	Kind     string                 `dynamo:"kind" json:"kind"`
-	Metadata Metadata               `dynamo:"metadata" json:"metadata"`
-	Spec     map[string]interface{} `dynamo:"spec" json:"spec"`
+	// APIVersion is the version of the schema of the resource, like "v1", when its definition has `versions`
+	APIVersion string                 `dynamo:"apiVersion" json:"apiVersion,omitempty"`
+	Kind       string                 `dynamo:"kind" json:"kind"`
+	Metadata   Metadata               `dynamo:"metadata" json:"metadata"`
+	Spec       map[string]interface{} `dynamo:"spec" json:"spec"`
+	// Status is the observed state of the resource, that is updated only via UpdateStatus
+	Status map[string]interface{} `dynamo:"status" json:"status,omitempty"`
+}
+
+// DeepCopy returns a copy of the resource that shares nothing with the original, so that either can be modified independently
+func (r *Resource) DeepCopy() *Resource {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		log.Panicf("unexpected error: %v", err)
+	}
+	c := &Resource{}
+	if err := json.Unmarshal(raw, c); err != nil {
+		log.Panicf("unexpected error: %v", err)
+	}
+	c.NameHashKey = r.NameHashKey
+	return c
+}
+
+// ObservedGeneration returns `status.observedGeneration`, that is the generation of the spec the controller has processed.
+// The bool is false when the resource isn't managed by any controller, or the controller has yet to process it
+func (r *Resource) ObservedGeneration() (int64, bool) {
+	return toInt64(r.Status["observedGeneration"])
+}
+
+// SpecInt64 returns the integer field of the spec. The bool is false when the field is missing or isn't a number
+func (r *Resource) SpecInt64(key string) (int64, bool) {
+	return toInt64(r.Spec[key])
+}
+
+// toInt64 converts the number regardless of how it has been decoded, as it's a float64 when read from JSON
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// SetObservedGeneration records that the controller has processed the current spec of the resource.
+// Call this before UpdateStatus
+func (r *Resource) SetObservedGeneration() {
+	if r.Status == nil {
+		r.Status = map[string]interface{}{}
+	}
+	r.Status["observedGeneration"] = r.Metadata.Generation
+}
+
+// Observed returns false when the status is stale, that is the controller has yet to process the latest spec.
+// Resources without `status.observedGeneration` are always considered observed
+func (r *Resource) Observed() bool {
+	observed, ok := r.ObservedGeneration()
+	return !ok || observed >= r.Metadata.Generation
 }
 
 type List struct {
@@ -1,5 +1,12 @@
 package api
 
+// CustomResourceDefinitionResource is the built-in resource every store has for definitions of custom resources.
+// Definitions are cluster-scoped, so that every namespace shares them
+const (
+	CustomResourceDefinitionResource = "customresourcedefinition"
+	CustomResourceDefinitionKind     = "CustomResourceDefinition"
+)
+
 type CustomResourceDefinition struct {
 	// CustomResourceDefinition
 	Kind     string                       `dynamo:"kind" json:"kind"`
@@ -13,9 +20,107 @@ func (d CustomResourceDefinition) ResourceKind() string {
 
 type CustomResourceDefinitionSpec struct {
 	Names CustomResourceDefinitionNames `dynamo:"names" json:"names"`
+	// Scope is either "Namespaced" or "Cluster". Defaults to "Namespaced".
+	// Resources of a cluster-scoped kind are shared among namespaces, like clusters and projects shared among environments
+	Scope string `dynamo:"scope" json:"scope,omitempty"`
+	// Validation is the schema resources of the kind must conform to. Any resource is accepted when omitted
+	Validation *CustomResourceValidation `dynamo:"validation" json:"validation,omitempty"`
+	// PreserveUnknownFields is true by default. Set it to false to drop fields not listed in the schema on apply,
+	// so that misspelled fields won't silently be stored
+	PreserveUnknownFields *bool `dynamo:"preserveUnknownFields" json:"preserveUnknownFields,omitempty"`
+	// Versions are versions of the resource from the oldest to the newest. Resources are stored at the storage version,
+	// and converted from and to the other versions via `conversion`
+	Versions   []CustomResourceDefinitionVersion `dynamo:"versions" json:"versions,omitempty"`
+	Conversion *CustomResourceConversion         `dynamo:"conversion" json:"conversion,omitempty"`
+}
+
+type CustomResourceDefinitionVersion struct {
+	Name string `dynamo:"name" json:"name"`
+	// Served is false when resources can no longer be applied or read at the version
+	Served bool `dynamo:"served" json:"served"`
+	// Storage is true for exactly one version, that resources are stored at
+	Storage bool `dynamo:"storage" json:"storage"`
+	// Schema overrides `validation` of the definition for the version
+	Schema *CustomResourceValidation `dynamo:"schema" json:"schema,omitempty"`
+}
+
+const (
+	NamespaceScoped = "Namespaced"
+	ClusterScoped   = "Cluster"
+)
+
+// ClusterScoped returns true when resources of the kind are stored once for all namespaces
+func (s CustomResourceDefinitionSpec) ClusterScoped() bool {
+	return s.Scope == ClusterScoped
+}
+
+const (
+	// NoneConverter converts resources just by rewriting apiVersion, for versions sharing the same schema
+	NoneConverter = "None"
+	// CommandConverter converts resources by running `conversion.command`
+	CommandConverter = "Command"
+)
+
+type CustomResourceConversion struct {
+	// Strategy is either "None" or "Command". Defaults to "None"
+	Strategy string `dynamo:"strategy" json:"strategy,omitempty"`
+	// Command reads `{"desiredAPIVersion":"v2","object":{...}}` from stdin and writes the converted object to stdout
+	Command []string `dynamo:"command" json:"command,omitempty"`
+}
+
+// StorageVersion returns the version resources are stored at. It's empty when the definition has no versions
+func (s CustomResourceDefinitionSpec) StorageVersion() string {
+	for _, v := range s.Versions {
+		if v.Storage {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+// Version returns the version named `name`, or nil when it doesn't exist
+func (s CustomResourceDefinitionSpec) Version(name string) *CustomResourceDefinitionVersion {
+	for i := range s.Versions {
+		if s.Versions[i].Name == name {
+			return &s.Versions[i]
+		}
+	}
+	return nil
+}
+
+// VersionOf returns the version of the resource. Resources without apiVersion, like ones written before versions are added
+// to the definition, are of the oldest version
+func (s CustomResourceDefinitionSpec) VersionOf(r *Resource) string {
+	if r.APIVersion == "" && len(s.Versions) > 0 {
+		return s.Versions[0].Name
+	}
+	return r.APIVersion
+}
+
+// SchemaFor returns the schema of the version, or nil when resources of the version aren't validated
+func (s CustomResourceDefinitionSpec) SchemaFor(version string) *JSONSchemaProps {
+	if v := s.Version(version); v != nil && v.Schema != nil && v.Schema.OpenAPIV3Schema != nil {
+		return v.Schema.OpenAPIV3Schema
+	}
+	if s.Validation != nil {
+		return s.Validation.OpenAPIV3Schema
+	}
+	return nil
+}
+
+// Prunes returns true when fields not listed in the schema are dropped on apply
+func (s CustomResourceDefinitionSpec) Prunes() bool {
+	return s.PreserveUnknownFields != nil && !*s.PreserveUnknownFields
 }
 
 type CustomResourceDefinitionNames struct {
 	Singular string `dynamo:"singular" json:"singular"`
-	Kind     string `dynamo:"kind" json:"kind"`
+	// Plural is like "clusters" for `div get clusters`. Defaults to the plural form of metadata.name
+	Plural string `dynamo:"plural" json:"plural,omitempty"`
+	// ShortNames are abbreviations accepted by the command-line, like "cl" for `div get cl`
+	ShortNames []string `dynamo:"shortNames" json:"shortNames,omitempty"`
+	// Categories are groups the resource belongs to, so that e.g. `div get infra` gets resources of every kind in the "infra" category.
+	// Every custom resource belongs to the "all" category
+	Categories []string `dynamo:"categories" json:"categories,omitempty"`
+	Kind       string   `dynamo:"kind" json:"kind"`
 }
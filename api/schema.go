@@ -0,0 +1,31 @@
+package api
+
+// CustomResourceValidation describes how resources of the kind are validated when they are applied
+type CustomResourceValidation struct {
+	// OpenAPIV3Schema is the schema of the whole resource, whose `properties` usually contain `spec`
+	OpenAPIV3Schema *JSONSchemaProps `dynamo:"openAPIV3Schema" json:"openAPIV3Schema,omitempty"`
+}
+
+// JSONSchemaProps is the subset of the OpenAPI v3 schema supported by div.
+// Fields not listed in `properties` are allowed and left as they are, unless the definition has `preserveUnknownFields: false`
+type JSONSchemaProps struct {
+	// Type is one of "object", "array", "string", "integer", "number" and "boolean". Any type is allowed when empty
+	Type        string                     `dynamo:"type" json:"type,omitempty"`
+	Description string                     `dynamo:"description" json:"description,omitempty"`
+	Properties  map[string]JSONSchemaProps `dynamo:"properties" json:"properties,omitempty"`
+	Required    []string                   `dynamo:"required" json:"required,omitempty"`
+	// Items is the schema of every item of the array
+	Items *JSONSchemaProps `dynamo:"items" json:"items,omitempty"`
+	Enum  []interface{}    `dynamo:"enum" json:"enum,omitempty"`
+	// Pattern is the regular expression in the RE2 syntax that string values must match
+	Pattern   string   `dynamo:"pattern" json:"pattern,omitempty"`
+	Minimum   *float64 `dynamo:"minimum" json:"minimum,omitempty"`
+	Maximum   *float64 `dynamo:"maximum" json:"maximum,omitempty"`
+	MinLength *int64   `dynamo:"minLength" json:"minLength,omitempty"`
+	MaxLength *int64   `dynamo:"maxLength" json:"maxLength,omitempty"`
+	// Default is set to the field when it's missing in the resource being applied
+	Default interface{} `dynamo:"default" json:"default,omitempty"`
+	// XPreserveUnknownFields keeps fields not listed in `properties` of this object and its descendants,
+	// even when the definition has `preserveUnknownFields: false`. Use it for free-form objects like maps of labels
+	XPreserveUnknownFields bool `dynamo:"x-kubernetes-preserve-unknown-fields" json:"x-kubernetes-preserve-unknown-fields,omitempty"`
+}
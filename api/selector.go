@@ -1,3 +1,180 @@
 package api
 
-type Selector []string
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type Operator string
+
+const (
+	Equals       Operator = "="
+	DoubleEquals Operator = "=="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// Requirement is a condition on the label named `Key`, like `env=prod`, `env in (prod,staging)` and `!canary`
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Selector is a Kubernetes-style label selector, that matches labels satisfying all the requirements.
+// The empty selector matches everything
+type Selector []Requirement
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[^\s=!(),]+$`)
+	labelValuePattern = regexp.MustCompile(`^[^\s=!(),]*$`)
+	setBasedPattern   = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// ParseSelectors parses selectors like `-l env=prod -l 'tier in (web,api)'`.
+// Selectors are joined with commas before parsing, so that a set split by the flag parser is restored
+func ParseSelectors(selectors []string) (Selector, error) {
+	return ParseSelector(strings.Join(selectors, ","))
+}
+
+// ParseSelector parses comma-separated requirements like `env=prod,tier in (web,api),!canary`.
+// Supported operators are `=`, `==`, `!=`, `in`, `notin`, existence `k` and non-existence `!k`
+func ParseSelector(selector string) (Selector, error) {
+	s := Selector{}
+	for _, term := range splitTerms(selector) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector \"%s\": %v", selector, err)
+		}
+		s = append(s, *r)
+	}
+	return s, nil
+}
+
+// splitTerms splits the selector by commas outside of parentheses
+func splitTerms(selector string) []string {
+	terms := []string{}
+	depth := 0
+	start := 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, selector[start:])
+}
+
+func parseRequirement(term string) (*Requirement, error) {
+	var r *Requirement
+	if m := setBasedPattern.FindStringSubmatch(term); m != nil {
+		values := []string{}
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		r = &Requirement{Key: m[1], Operator: Operator(m[2]), Values: values}
+	} else if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		r = &Requirement{Key: strings.TrimSpace(term[1:]), Operator: DoesNotExist}
+	} else {
+		for _, op := range []Operator{NotEquals, DoubleEquals, Equals} {
+			if i := strings.Index(term, string(op)); i >= 0 {
+				r = &Requirement{
+					Key:      strings.TrimSpace(term[:i]),
+					Operator: op,
+					Values:   []string{strings.TrimSpace(term[i+len(op):])},
+				}
+				break
+			}
+		}
+		if r == nil {
+			r = &Requirement{Key: term, Operator: Exists}
+		}
+	}
+	if !labelKeyPattern.MatchString(r.Key) {
+		return nil, fmt.Errorf("invalid label key \"%s\" in \"%s\"", r.Key, term)
+	}
+	for _, v := range r.Values {
+		if !labelValuePattern.MatchString(v) {
+			return nil, fmt.Errorf("invalid label value \"%s\" in \"%s\"", v, term)
+		}
+	}
+	if (r.Operator == In || r.Operator == NotIn) && len(r.Values) == 0 {
+		return nil, fmt.Errorf("no values specified in \"%s\"", term)
+	}
+	return r, nil
+}
+
+// Matches returns true when the labels satisfy the requirement.
+// Like Kubernetes, `!=` and `notin` match labels without the key
+func (r Requirement) Matches(labels map[string]string) bool {
+	v, exists := labels[r.Key]
+	switch r.Operator {
+	case Equals, DoubleEquals, In:
+		return exists && r.hasValue(v)
+	case NotEquals, NotIn:
+		return !exists || !r.hasValue(v)
+	case Exists:
+		return exists
+	case DoesNotExist:
+		return !exists
+	default:
+		return false
+	}
+}
+
+func (r Requirement) hasValue(v string) bool {
+	for _, value := range r.Values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case In, NotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ","))
+	case Exists:
+		return r.Key
+	case DoesNotExist:
+		return "!" + r.Key
+	default:
+		return fmt.Sprintf("%s%s%s", r.Key, r.Operator, r.Values[0])
+	}
+}
+
+// Matches returns true when the labels satisfy all the requirements
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	terms := []string{}
+	for _, r := range s {
+		terms = append(terms, r.String())
+	}
+	return strings.Join(terms, ",")
+}
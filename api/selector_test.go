@@ -0,0 +1,148 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelectors(t *testing.T) {
+	testcases := []struct {
+		selectors []string
+		expected  Selector
+		err       bool
+	}{
+		{
+			selectors: []string{},
+			expected:  Selector{},
+		},
+		{
+			selectors: []string{"env=prod"},
+			expected:  Selector{{Key: "env", Operator: Equals, Values: []string{"prod"}}},
+		},
+		{
+			selectors: []string{"env == prod", "tier!=web"},
+			expected: Selector{
+				{Key: "env", Operator: DoubleEquals, Values: []string{"prod"}},
+				{Key: "tier", Operator: NotEquals, Values: []string{"web"}},
+			},
+		},
+		{
+			// The flag parser splits the set by commas
+			selectors: []string{"tier in (web", "api)", "env notin (dev)"},
+			expected: Selector{
+				{Key: "tier", Operator: In, Values: []string{"web", "api"}},
+				{Key: "env", Operator: NotIn, Values: []string{"dev"}},
+			},
+		},
+		{
+			selectors: []string{"canary,!legacy"},
+			expected: Selector{
+				{Key: "canary", Operator: Exists},
+				{Key: "legacy", Operator: DoesNotExist},
+			},
+		},
+		{
+			selectors: []string{"env="},
+			expected:  Selector{{Key: "env", Operator: Equals, Values: []string{""}}},
+		},
+		{
+			selectors: []string{"=prod"},
+			err:       true,
+		},
+		{
+			selectors: []string{"tier in ()"},
+			err:       true,
+		},
+		{
+			selectors: []string{"env=prod=dev"},
+			err:       true,
+		},
+		{
+			selectors: []string{"my env"},
+			err:       true,
+		},
+	}
+
+	for i, tc := range testcases {
+		actual, err := ParseSelectors(tc.selectors)
+		if tc.err {
+			if err == nil {
+				t.Errorf("case %d: expected error for %v, but got none: %v", i, tc.selectors, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error for %v: %v", i, tc.selectors, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("case %d: unexpected selector for %v: expected=%v, actual=%v", i, tc.selectors, tc.expected, actual)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{
+		"env":  "prod",
+		"tier": "web",
+	}
+
+	testcases := []struct {
+		selector string
+		expected bool
+	}{
+		{selector: "", expected: true},
+		{selector: "env=prod", expected: true},
+		{selector: "env==prod", expected: true},
+		{selector: "env=dev", expected: false},
+		{selector: "env!=dev", expected: true},
+		{selector: "env!=prod", expected: false},
+		{selector: "team!=frontend", expected: true},
+		{selector: "tier in (web,api)", expected: true},
+		{selector: "tier in (api)", expected: false},
+		{selector: "tier notin (api)", expected: true},
+		{selector: "team notin (frontend)", expected: true},
+		{selector: "tier notin (web)", expected: false},
+		{selector: "env", expected: true},
+		{selector: "team", expected: false},
+		{selector: "!team", expected: true},
+		{selector: "!env", expected: false},
+		{selector: "env=prod,tier in (web),!team", expected: true},
+		{selector: "env=prod,tier=api", expected: false},
+	}
+
+	for _, tc := range testcases {
+		s, err := ParseSelector(tc.selector)
+		if err != nil {
+			t.Errorf("unexpected error for \"%s\": %v", tc.selector, err)
+			continue
+		}
+		if actual := s.Matches(labels); actual != tc.expected {
+			t.Errorf("unexpected result for \"%s\": expected=%v, actual=%v", tc.selector, tc.expected, actual)
+		}
+	}
+}
+
+func TestSelectorString(t *testing.T) {
+	testcases := []string{
+		"env=prod",
+		"env==prod",
+		"env!=prod",
+		"tier in (web,api)",
+		"tier notin (web)",
+		"canary",
+		"!canary",
+		"env=prod,tier in (web,api),!canary",
+	}
+
+	for _, tc := range testcases {
+		s, err := ParseSelector(tc)
+		if err != nil {
+			t.Errorf("unexpected error for \"%s\": %v", tc, err)
+			continue
+		}
+		if actual := s.String(); actual != tc {
+			t.Errorf("unexpected string: expected=%s, actual=%s", tc, actual)
+		}
+	}
+}
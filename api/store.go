@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Store is the API every datastore backend of division implements.
+// Use `framework.NewStore` to obtain the implementation selected by `spec.backend` in `div.yaml`.
+// Cancel the context to stop asynchronous operations. Channels returned by them are closed once stopped
+type Store interface {
+	// GetPrint prints resources to stdout. When watching, it prints changes until the context is canceled
+	GetPrint(ctx context.Context, resource, name string, selectors []string, output string, watch bool) error
+	GetAsync(ctx context.Context, resource, name string, selectors []string, watch bool) (<-chan *Resource, <-chan error)
+	GetSync(ctx context.Context, resource, name string, selectors []string) ([]*Resource, error)
+	// Watch sends an ADDED event per existing resource, and then events for every subsequent change
+	Watch(ctx context.Context, resource, name string, selectors []string, opts WatchOptions) (<-chan *WatchEvent, <-chan error)
+	GetCRDs(ctx context.Context) ([]CustomResourceDefinition, error)
+	// Wait blocks until the resource satisfies the condition, and then prints it
+	Wait(ctx context.Context, resource, name string, cond WaitCondition, output string, timeout time.Duration, logs bool) error
+	ApplyFile(ctx context.Context, file string) error
+	Apply(ctx context.Context, resource *Resource) error
+	// UpdateStatus replaces the status of the existing resource, leaving the rest of the resource untouched
+	UpdateStatus(ctx context.Context, resource *Resource) error
+	Delete(ctx context.Context, resource, name string) error
+	// AcquireLease takes the lease named `name` for the holder when it is released or expired, or renews it when the holder already has it.
+	// It fails with ErrConflict when another holder has the lease
+	AcquireLease(ctx context.Context, name, holder string, duration time.Duration) (*Resource, error)
+	// RenewLease extends the lease the holder has. It fails with ErrConflict when the holder has lost the lease
+	RenewLease(ctx context.Context, name, holder string, duration time.Duration) (*Resource, error)
+	// ReleaseLease frees the lease the holder has, so that others can acquire it without waiting for it to expire
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+// LogStore reads and writes the log stream associated to each resource.
+type LogStore interface {
+	Read(ctx context.Context, resource, name string, since time.Duration, follow bool) (<-chan string, <-chan error)
+	// ReadPrint prints logs to stdout. When following, it prints logs until the context is canceled
+	ReadPrint(ctx context.Context, resource, name string, since time.Duration, follow bool) error
+	Writer(ctx context.Context, resource, name string) (io.WriteCloser, error)
+	WriteFile(ctx context.Context, resource, name string, file string) error
+	Delete(ctx context.Context, resource, name string) error
+}
@@ -0,0 +1,27 @@
+package api
+
+type EventType string
+
+const (
+	Added    EventType = "ADDED"
+	Modified EventType = "MODIFIED"
+	Deleted  EventType = "DELETED"
+)
+
+// WatchEvent is a change to a resource observed by Store.Watch.
+// Object is the resource after the change, or the last state of the resource for a DELETED event.
+// OldObject is the resource before the change, and is set only for a MODIFIED event when the backend is able to tell it
+type WatchEvent struct {
+	Type      EventType `json:"type"`
+	Object    *Resource `json:"object"`
+	OldObject *Resource `json:"oldObject,omitempty"`
+}
+
+type WatchOptions struct {
+	// Checkpoint is the unique name of the watcher, used to persist the position in the change stream as events are delivered.
+	// The position is a best-effort hint, as it's persisted without knowing whether the watcher has processed the delivered events.
+	// A watch restarted with the same name resumes from the position without relisting resources,
+	// or relists resources when the position is no longer available, like when the changes have been trimmed.
+	// Backends without persistent change streams ignore this and always relist resources
+	Checkpoint string
+}
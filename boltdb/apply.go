@@ -0,0 +1,99 @@
+package boltdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"time"
+)
+
+func (p *boltResourceDB) ApplyFile(ctx context.Context, file string) error {
+	return framework.ApplyFile(ctx, p, file, false)
+}
+
+// resourceDefinitionForKind looks for the definition in both the config and the database,
+// so that the file store works without `source` pointing to the database
+func (p *boltResourceDB) resourceDefinitionForKind(ctx context.Context, kind string) (*api.CustomResourceDefinition, error) {
+	if kind == api.CustomResourceDefinitionKind {
+		return &api.CustomResourceDefinition{
+			Kind: api.CustomResourceDefinitionKind,
+			Metadata: api.Metadata{
+				Name: api.CustomResourceDefinitionResource,
+			},
+			Spec: api.CustomResourceDefinitionSpec{
+				Names: api.CustomResourceDefinitionNames{
+					Kind: api.CustomResourceDefinitionKind,
+				},
+			},
+		}, nil
+	}
+	crds, err := p.GetCRDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defs := append(append([]api.CustomResourceDefinition{}, p.resourceDefs...), crds...)
+	for i := range defs {
+		if defs[i].ResourceKind() == kind {
+			return &defs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no resource definition found in %v: kind=%s", defs, kind)
+}
+
+func (p *boltResourceDB) Apply(ctx context.Context, resource *api.Resource) error {
+	resource.Metadata.CreationTimestamp = time.Now()
+	resource.Metadata.UpdateTimestamp = time.Now()
+	if resource.NameHashKey == "" {
+		resource.NameHashKey = resource.Metadata.Name
+	}
+
+	resourceDef, err := p.resourceDefinitionForKind(ctx, resource.Kind)
+	if err != nil {
+		return err
+	}
+	if err := framework.AdmitResource(ctx, resourceDef, resource); err != nil {
+		return err
+	}
+	table := p.tableNameFor(resourceDef)
+
+	var updated bool
+	err = p.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(table))
+		if err != nil {
+			return err
+		}
+		key := []byte(resource.NameHashKey)
+		var existing *api.Resource
+		if v := b.Get(key); v != nil {
+			e, err := unmarshalResource(key, v)
+			if err != nil {
+				return err
+			}
+			existing = &e
+			updated = true
+		}
+		if err := framework.PrepareWrite(resource, existing); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(resource)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, raw)
+	})
+	if _, ok := err.(*api.ErrConflict); ok {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("unexpected error: %v", err)
+	}
+	if updated {
+		fmt.Printf("%s \"%s\" updated\n", resourceDef.Metadata.Name, resource.Metadata.Name)
+	} else {
+		fmt.Printf("%s \"%s\" created\n", resourceDef.Metadata.Name, resource.Metadata.Name)
+	}
+	return nil
+}
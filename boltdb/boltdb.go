@@ -0,0 +1,115 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"time"
+)
+
+const databasePrefix = "div-"
+
+// openTimeout is how long we wait for another div process to release the database file.
+// BoltDB allows only one process to open the file at a time, so we open it per operation rather than per command.
+const openTimeout = 10 * time.Second
+
+// pollInterval is the interval between each read of the database file for watching resource changes and following logs
+const pollInterval = time.Second
+
+type boltResourceDB struct {
+	path         string
+	databaseName string
+	config       *api.Config
+	logs         *LogStore
+	namespace    string
+	resourceDefs []api.CustomResourceDefinition
+}
+
+func (p *boltResourceDB) tablePrefix() string {
+	return fmt.Sprintf("%s%s", databasePrefix, p.databaseName)
+}
+
+// tableNameForResourceNamed returns the name of the bucket for the resource.
+// Like DynamoDB tables, we have a bucket per namespace per resource, and a global bucket for resource definitions and cluster-scoped resources.
+// It reads definitions from the file, so never call it within a transaction
+func (p *boltResourceDB) tableNameForResourceNamed(resource string) string {
+	if resource == api.CustomResourceDefinitionResource {
+		return fmt.Sprintf("%s-%s", p.tablePrefix(), resource)
+	}
+	// Leases are always namespaced
+	if resource != api.LeaseResource {
+		crds, _ := p.getCRDs()
+		for _, d := range append(append([]api.CustomResourceDefinition{}, p.resourceDefs...), crds...) {
+			if d.Metadata.Name == resource {
+				return p.tableNameFor(&d)
+			}
+		}
+	}
+	return fmt.Sprintf("%s-%s-%s", p.tablePrefix(), p.namespace, resource)
+}
+
+func (p *boltResourceDB) tableNameFor(resourceDef *api.CustomResourceDefinition) string {
+	if resourceDef.Metadata.Name == api.CustomResourceDefinitionResource || resourceDef.Spec.ClusterScoped() {
+		return fmt.Sprintf("%s-%s", p.tablePrefix(), resourceDef.Metadata.Name)
+	}
+	return fmt.Sprintf("%s-%s-%s", p.tablePrefix(), p.namespace, resourceDef.Metadata.Name)
+}
+
+func withDB(path string, f func(*bolt.DB) error) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return fmt.Errorf(`failed to open "%s": %v`, path, err)
+	}
+	defer db.Close()
+	return f(db)
+}
+
+func (p *boltResourceDB) view(f func(*bolt.Tx) error) error {
+	return withDB(p.path, func(db *bolt.DB) error {
+		return db.View(f)
+	})
+}
+
+func (p *boltResourceDB) update(f func(*bolt.Tx) error) error {
+	return withDB(p.path, func(db *bolt.DB) error {
+		return db.Update(f)
+	})
+}
+
+func unmarshalResource(key, value []byte) (api.Resource, error) {
+	var r api.Resource
+	if err := json.Unmarshal(value, &r); err != nil {
+		return r, fmt.Errorf("failed to unmarshal %s: %v", string(key), err)
+	}
+	r.NameHashKey = string(key)
+	return r, nil
+}
+
+func newDB(path string, config *api.Config, namespace string) (*boltResourceDB, *LogStore, error) {
+	if path == "" {
+		return nil, nil, fmt.Errorf(`missing path to the database file: backend must be formatted "file:///path/to/div.db"`)
+	}
+	logs := &LogStore{
+		path:      path,
+		config:    config,
+		namespace: namespace,
+	}
+	return &boltResourceDB{
+		path:         path,
+		databaseName: config.Metadata.Name,
+		config:       config,
+		logs:         logs,
+		namespace:    namespace,
+		resourceDefs: config.Spec.CustomResourceDefinitions,
+	}, logs, nil
+}
+
+func newStore(path string, config *api.Config, namespace string) (api.Store, api.LogStore, error) {
+	return newDB(path, config, namespace)
+}
+
+func init() {
+	framework.StoreProviders.Register("file", newStore)
+}
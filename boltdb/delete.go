@@ -0,0 +1,28 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+func (p *boltResourceDB) Delete(ctx context.Context, resource string, name string) error {
+	table := p.tableNameForResourceNamed(resource)
+	var deleted bool
+	err := p.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(table))
+		if b == nil || b.Get([]byte(name)) == nil {
+			return nil
+		}
+		deleted = true
+		return b.Delete([]byte(name))
+	})
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf(`%s "%s" not found`, resource, name)
+	}
+	fmt.Printf("%s \"%s\" deleted\n", resource, name)
+	return nil
+}
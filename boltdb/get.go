@@ -0,0 +1,287 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"os"
+	"time"
+)
+
+func (p *boltResourceDB) GetPrint(ctx context.Context, resource, name string, selectors []string, output string, watch bool) error {
+	if watch {
+		evCh, errCh := p.Watch(ctx, resource, name, selectors, api.WatchOptions{})
+
+		return framework.PrintWatchEventsSync(ctx, evCh, errCh, output)
+	}
+
+	resCh, errCh := p.GetAsync(ctx, resource, name, selectors, false)
+
+	return framework.PrintStreamedResourcesSync(ctx, resCh, errCh, output, false)
+}
+
+func (p *boltResourceDB) GetCRDs(ctx context.Context) ([]api.CustomResourceDefinition, error) {
+	return p.getCRDs()
+}
+
+func (p *boltResourceDB) getCRDs() ([]api.CustomResourceDefinition, error) {
+	crds := []api.CustomResourceDefinition{}
+	err := p.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(p.tableNameForResourceNamed(api.CustomResourceDefinitionResource)))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var crd api.CustomResourceDefinition
+			if err := json.Unmarshal(v, &crd); err != nil {
+				return fmt.Errorf("failed to unmarshal %s: %v", string(k), err)
+			}
+			crds = append(crds, crd)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return crds, nil
+}
+
+// scan returns raw items in the table keyed by resource names. The bool is false when the table doesn't exist
+func (p *boltResourceDB) scan(table string) (map[string][]byte, bool, error) {
+	items := map[string][]byte{}
+	exists := false
+	err := p.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(table))
+		if b == nil {
+			return nil
+		}
+		exists = true
+		return b.ForEach(func(k, v []byte) error {
+			items[string(k)] = append([]byte{}, v...)
+			return nil
+		})
+	})
+	return items, exists, err
+}
+
+func (p *boltResourceDB) get(resource, name string, selectors []string) (api.Resources, error) {
+	selector, err := api.ParseSelectors(selectors)
+	if err != nil {
+		return nil, err
+	}
+	table := p.tableNameForResourceNamed(resource)
+	resources := api.Resources{}
+	var exists bool
+	err = p.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(table))
+		if b == nil {
+			return nil
+		}
+		exists = true
+		if name != "" {
+			if v := b.Get([]byte(name)); v != nil {
+				r, err := unmarshalResource([]byte(name), v)
+				if err != nil {
+					return err
+				}
+				resources = append(resources, r)
+			}
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			r, err := unmarshalResource(k, v)
+			if err != nil {
+				return err
+			}
+			resources = append(resources, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := api.Resources{}
+	for _, r := range resources {
+		if selector.Matches(r.Metadata.Labels) {
+			filtered = append(filtered, r)
+		}
+	}
+
+	if len(filtered) == 0 {
+		var msg string
+		if exists {
+			msg = fmt.Sprintf(`bucket named "%s" exists in "%s", but no item named "%s" found`, table, p.path, name)
+		} else {
+			msg = fmt.Sprintf(`no bucket named "%s" exists in "%s". create it by "div apply -f your-new-%s.%s.yaml"`, table, p.path, resource, resource)
+		}
+		if name != "" {
+			return nil, api.NewErrResourceNotFound(fmt.Sprintf(`%s "%s" not found: %s`, resource, name, msg))
+		}
+		fmt.Fprintf(os.Stderr, "no %s found: %s\n", resource, msg)
+	}
+	return filtered, nil
+}
+
+func (p *boltResourceDB) GetSync(ctx context.Context, resource, name string, selectors []string) ([]*api.Resource, error) {
+	resources, err := p.get(resource, name, selectors)
+	if err != nil {
+		return nil, err
+	}
+	rs := []*api.Resource{}
+	for i := range resources {
+		rs = append(rs, &resources[i])
+	}
+	return rs, nil
+}
+
+func (p *boltResourceDB) GetAsync(ctx context.Context, resource, name string, selectors []string, watch bool) (<-chan *api.Resource, <-chan error) {
+	if watch {
+		evCh, errCh := p.Watch(ctx, resource, name, selectors, api.WatchOptions{})
+
+		return framework.WatchedResources(ctx, evCh, errCh)
+	}
+
+	resCh := make(chan *api.Resource)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(resCh)
+		defer close(errCh)
+
+		resources, err := p.get(resource, name, selectors)
+		if err != nil {
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		for i := range resources {
+			select {
+			case resCh <- &resources[i]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resCh, errCh
+}
+
+// Watch polls the table and sends the difference between snapshots of it
+func (p *boltResourceDB) Watch(ctx context.Context, resource, name string, selectors []string, opts api.WatchOptions) (<-chan *api.WatchEvent, <-chan error) {
+	evCh := make(chan *api.WatchEvent)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(evCh)
+		defer close(errCh)
+
+		sendErr := func(err error) {
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
+		}
+		send := func(ev *api.WatchEvent) bool {
+			select {
+			case evCh <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		selector, err := api.ParseSelectors(selectors)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		table := p.tableNameForResourceNamed(resource)
+
+		// Snapshot the table before listing so that changes made in between are sent by the watch
+		last, _, err := p.scan(table)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		resources, err := p.get(resource, name, selectors)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		for i := range resources {
+			if !send(&api.WatchEvent{Type: api.Added, Object: &resources[i]}) {
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				return
+			}
+
+			current, _, err := p.scan(table)
+			if err != nil {
+				sendErr(err)
+				return
+			}
+			keys := []string{}
+			for k, v := range current {
+				if prev, ok := last[k]; !ok || !bytes.Equal(prev, v) {
+					keys = append(keys, k)
+				}
+			}
+			for k := range last {
+				if _, ok := current[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+
+			for _, k := range keys {
+				if name != "" && name != k {
+					continue
+				}
+				oldObj, err := unmarshalResourceIfExists(k, last)
+				if err != nil {
+					sendErr(err)
+					return
+				}
+				newObj, err := unmarshalResourceIfExists(k, current)
+				if err != nil {
+					sendErr(err)
+					return
+				}
+				if ev := framework.NewWatchEvent(oldObj, newObj, selector); ev != nil && !send(ev) {
+					return
+				}
+			}
+			last = current
+		}
+	}()
+
+	return evCh, errCh
+}
+
+func unmarshalResourceIfExists(key string, items map[string][]byte) (*api.Resource, error) {
+	v, ok := items[key]
+	if !ok {
+		return nil, nil
+	}
+	r, err := unmarshalResource([]byte(key), v)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,72 @@
+package boltdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"time"
+)
+
+func (p *boltResourceDB) AcquireLease(ctx context.Context, name, holder string, duration time.Duration) (*api.Resource, error) {
+	return p.updateLease(name, func(existing *api.Resource) (*api.Resource, error) {
+		return framework.PrepareLeaseAcquire(existing, name, holder, duration)
+	})
+}
+
+func (p *boltResourceDB) RenewLease(ctx context.Context, name, holder string, duration time.Duration) (*api.Resource, error) {
+	return p.updateLease(name, func(existing *api.Resource) (*api.Resource, error) {
+		return framework.PrepareLeaseRenew(existing, name, holder, duration)
+	})
+}
+
+func (p *boltResourceDB) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := p.updateLease(name, func(existing *api.Resource) (*api.Resource, error) {
+		return framework.PrepareLeaseRelease(existing, name, holder)
+	})
+	return err
+}
+
+// updateLease writes the lease computed from the existing one within a transaction, so that concurrent writers never overwrite each other
+func (p *boltResourceDB) updateLease(name string, prepare func(existing *api.Resource) (*api.Resource, error)) (*api.Resource, error) {
+	table := p.tableNameForResourceNamed(api.LeaseResource)
+
+	var lease *api.Resource
+	err := p.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(table))
+		if err != nil {
+			return err
+		}
+		key := []byte(name)
+		var existing *api.Resource
+		if v := b.Get(key); v != nil {
+			e, err := unmarshalResource(key, v)
+			if err != nil {
+				return err
+			}
+			existing = &e
+		}
+		l, err := prepare(existing)
+		if err != nil {
+			return err
+		}
+		if err := framework.PrepareWrite(l, existing); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		lease = l
+		return b.Put(key, raw)
+	})
+	if _, ok := err.(*api.ErrConflict); ok {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error: %v", err)
+	}
+	return lease, nil
+}
@@ -0,0 +1,278 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/mumoshu/division/api"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const logsBucket = "logs"
+
+type logEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message"`
+}
+
+// LogStore stores logs in a bucket per log stream, nested in a bucket per log group.
+// Like CloudWatch Logs, a log group is created per custom resource definition, and a log stream is created per custom resource
+type LogStore struct {
+	path      string
+	config    *api.Config
+	namespace string
+}
+
+func (c *LogStore) logGroup(resource string) string {
+	return fmt.Sprintf("%s%s-%s-%s", databasePrefix, c.config.Metadata.Name, c.namespace, resource)
+}
+
+func (c *LogStore) streamBucket(tx *bolt.Tx, resource, name string) *bolt.Bucket {
+	logs := tx.Bucket([]byte(logsBucket))
+	if logs == nil {
+		return nil
+	}
+	group := logs.Bucket([]byte(c.logGroup(resource)))
+	if group == nil {
+		return nil
+	}
+	return group.Bucket([]byte(name))
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// readSince returns events stored after the key, along with the key of the last event
+func (c *LogStore) readSince(resource, name string, after []byte) ([]logEvent, []byte, error) {
+	events := []logEvent{}
+	last := after
+	err := withDB(c.path, func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			b := c.streamBucket(tx, resource, name)
+			if b == nil {
+				return api.NewErrLogsNotFound(fmt.Sprintf("log stream for resource=%s name=%s does not exist (yet)", resource, name))
+			}
+			cur := b.Cursor()
+			var k, v []byte
+			if after == nil {
+				k, v = cur.First()
+			} else {
+				cur.Seek(after)
+				k, v = cur.Next()
+			}
+			for ; k != nil; k, v = cur.Next() {
+				var e logEvent
+				if err := json.Unmarshal(v, &e); err != nil {
+					return err
+				}
+				events = append(events, e)
+				last = append([]byte{}, k...)
+			}
+			return nil
+		})
+	})
+	return events, last, err
+}
+
+func (c *LogStore) Read(ctx context.Context, resource, name string, since time.Duration, follow bool) (<-chan string, <-chan error) {
+	msgs := make(chan string)
+	errs := make(chan error)
+
+	go func() {
+		defer close(msgs)
+		defer close(errs)
+
+		var startTime time.Time
+		if since.Nanoseconds() != 0 {
+			startTime = time.Now().Add(-since)
+		}
+
+		var last []byte
+		for {
+			events, l, err := c.readSince(resource, name, last)
+			if err != nil {
+				select {
+				case errs <- err:
+				case <-ctx.Done():
+				}
+				return
+			}
+			last = l
+			for _, e := range events {
+				if e.Timestamp.Before(startTime) {
+					continue
+				}
+				select {
+				case msgs <- e.Message:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !follow {
+				return
+			}
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return msgs, errs
+}
+
+func (c *LogStore) ReadPrint(ctx context.Context, resource, name string, since time.Duration, follow bool) error {
+	logsCh, errCh := c.Read(ctx, resource, name, since, follow)
+	var err error
+	for logsCh != nil || errCh != nil {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-errCh:
+			if ok {
+				err = e
+			}
+			errCh = nil
+		case msg, ok := <-logsCh:
+			if !ok {
+				logsCh = nil
+				continue
+			}
+			fmt.Printf("%s", msg)
+		}
+	}
+	return err
+}
+
+func (c *LogStore) append(resource, name string, msgs []string) error {
+	return withDB(c.path, func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			logs, err := tx.CreateBucketIfNotExists([]byte(logsBucket))
+			if err != nil {
+				return err
+			}
+			group, err := logs.CreateBucketIfNotExists([]byte(c.logGroup(resource)))
+			if err != nil {
+				return err
+			}
+			b, err := group.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for _, msg := range msgs {
+				seq, err := b.NextSequence()
+				if err != nil {
+					return err
+				}
+				raw, err := json.Marshal(logEvent{Timestamp: time.Now(), Message: msg})
+				if err != nil {
+					return err
+				}
+				if err := b.Put(seqKey(seq), raw); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// logWriter appends a log event per line written
+type logWriter struct {
+	store    *LogStore
+	resource string
+	name     string
+	buf      bytes.Buffer
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	lines := []string{}
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, string(w.buf.Next(i+1)))
+	}
+	if len(lines) > 0 {
+		if err := w.store.append(w.resource, w.name, lines); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *logWriter) Close() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	line := w.buf.String() + "\n"
+	w.buf.Reset()
+	return w.store.append(w.resource, w.name, []string{line})
+}
+
+func (c *LogStore) Writer(ctx context.Context, resource, name string) (io.WriteCloser, error) {
+	// Create the log stream beforehand so that readers can start following it
+	if err := c.append(resource, name, nil); err != nil {
+		return nil, err
+	}
+	return &logWriter{store: c, resource: resource, name: name}, nil
+}
+
+func (c *LogStore) WriteFile(ctx context.Context, resource, name string, file string) error {
+	var rawInput []byte
+	if file == "-" {
+		var buf bytes.Buffer
+
+		nr, err := io.Copy(&buf, os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %v", err)
+		}
+		rawInput = buf.Bytes()
+		fmt.Fprintf(os.Stderr, "read %d bytes from stdin\n", nr)
+	} else {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rawInput = raw
+	}
+	w, err := c.Writer(ctx, resource, name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(rawInput); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("\n")); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (c *LogStore) Delete(ctx context.Context, resource, name string) error {
+	return withDB(c.path, func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			logs := tx.Bucket([]byte(logsBucket))
+			if logs == nil {
+				return nil
+			}
+			group := logs.Bucket([]byte(c.logGroup(resource)))
+			if group == nil || group.Bucket([]byte(name)) == nil {
+				return nil
+			}
+			return group.DeleteBucket([]byte(name))
+		})
+	})
+}
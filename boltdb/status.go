@@ -0,0 +1,53 @@
+package boltdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+)
+
+func (p *boltResourceDB) UpdateStatus(ctx context.Context, resource *api.Resource) error {
+	resourceDef, err := p.resourceDefinitionForKind(ctx, resource.Kind)
+	if err != nil {
+		return err
+	}
+	table := p.tableNameFor(resourceDef)
+
+	var updated *api.Resource
+	err = p.update(func(tx *bolt.Tx) error {
+		var existing *api.Resource
+		key := []byte(resource.Metadata.Name)
+		if b := tx.Bucket([]byte(table)); b != nil {
+			if v := b.Get(key); v != nil {
+				e, err := unmarshalResource(key, v)
+				if err != nil {
+					return err
+				}
+				existing = &e
+			}
+		}
+		u, err := framework.PrepareStatusWrite(resource, existing)
+		if err != nil {
+			return err
+		}
+		raw, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		updated = u
+		return tx.Bucket([]byte(table)).Put(key, raw)
+	})
+	switch err.(type) {
+	case *api.ErrConflict, *api.ErrResourceNotFound:
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("unexpected error: %v", err)
+	}
+	*resource = *updated
+	fmt.Printf("%s \"%s\" status updated\n", resourceDef.Metadata.Name, resource.Metadata.Name)
+	return nil
+}
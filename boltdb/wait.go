@@ -0,0 +1,21 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"time"
+)
+
+func (p *boltResourceDB) Wait(ctx context.Context, resource, name string, cond api.WaitCondition, output string, timeout time.Duration, logs bool) error {
+	if name == "" {
+		return fmt.Errorf("missing resource name")
+	}
+	r, err := framework.Wait(ctx, p, p.logs, resource, name, cond, timeout, logs)
+	if err != nil {
+		return err
+	}
+	framework.WriteToStdout(r.Format(output))
+	return nil
+}
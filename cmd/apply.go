@@ -15,12 +15,15 @@
 package cmd
 
 import (
-	"github.com/mumoshu/division/dynamodb"
+	"fmt"
+	"github.com/mumoshu/division/framework"
 	"github.com/spf13/cobra"
 )
 
 type ApplyOptions struct {
-	File string
+	File        string
+	Recursive   bool
+	Subresource string
 }
 
 var applyOpts ApplyOptions
@@ -31,17 +34,36 @@ func NewCmdApply() *cobra.Command {
 		Short: "Put record(s)",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
 
-			db, err := dynamodb.NewDB(globalOpts.Config, globalOpts.Namespace)
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
 			if err != nil {
 				return err
 			}
-			return db.ApplyFile(applyOpts.File)
+			switch applyOpts.Subresource {
+			case "":
+				return framework.ApplyFile(ctx, db, applyOpts.File, applyOpts.Recursive)
+			case "status":
+				resources, err := framework.LoadResourcesFromFile(applyOpts.File, applyOpts.Recursive)
+				if err != nil {
+					return err
+				}
+				for _, r := range resources {
+					if err := db.UpdateStatus(ctx, r); err != nil {
+						return err
+					}
+				}
+				return nil
+			default:
+				return fmt.Errorf("unsupported subresource \"%s\": only \"status\" is supported", applyOpts.Subresource)
+			}
 		},
 	}
 
 	options := cmd.Flags()
-	options.StringVarP(&applyOpts.File, "file", "f", "", "Path to input File (optional; default is stdin)")
+	options.StringVarP(&applyOpts.File, "file", "f", "", "Path to input file or directory, or \"-\" for stdin. The file may contain multiple YAML documents separated by \"---\", or a list of kind \"list\"")
+	options.BoolVarP(&applyOpts.Recursive, "recursive", "R", false, "Process the directory used in -f, --file recursively")
+	options.StringVar(&applyOpts.Subresource, "subresource", "", "Update only the specified subresource of the existing resource. Only \"status\" is supported")
 	cmd.MarkFlagRequired("file")
 
 	return cmd
@@ -0,0 +1,9 @@
+package cmd
+
+import (
+	// Store backends register themselves to framework.StoreProviders on init.
+	// Import the ones selectable via `spec.backend` in div.yaml here.
+	_ "github.com/mumoshu/division/boltdb"
+	_ "github.com/mumoshu/division/dynamodb"
+	_ "github.com/mumoshu/division/memory"
+)
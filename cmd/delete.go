@@ -15,23 +15,28 @@
 package cmd
 
 import (
-	"github.com/mumoshu/division/dynamodb"
+	"github.com/mumoshu/division/framework"
 	"github.com/spf13/cobra"
 )
 
 func NewCmdDel() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "delete",
+		Use:   "delete RESOURCE NAME",
 		Short: "Delete single source by specified name",
 		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
 
-			db, err := dynamodb.NewDB(globalOpts.Config, globalOpts.Namespace)
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
 			if err != nil {
 				return err
 			}
-			return db.Delete(args[0], args[1])
+			def, _, err := resolveResource(ctx, db, args[0])
+			if err != nil {
+				return err
+			}
+			return db.Delete(ctx, def.Metadata.Name, args[1])
 		},
 	}
 	return cmd
@@ -15,9 +15,10 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/mumoshu/division/api"
-	"github.com/mumoshu/division/dynamodb"
+	"github.com/mumoshu/division/framework"
 	"github.com/spf13/cobra"
 	"os"
 	"reflect"
@@ -49,13 +50,14 @@ func NewCmdDeploy() *cobra.Command {
 		Args:  cobra.RangeArgs(0, 1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
 
-			db, err := dynamodb.NewDB(globalOpts.Config, globalOpts.Namespace)
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
 			if err != nil {
 				return err
 			}
 
-			logs, err := dynamodb.NewLogs(globalOpts.Config, globalOpts.Namespace)
+			logs, err := framework.NewLogStore(globalOpts.Config, globalOpts.Namespace)
 			if err != nil {
 				return err
 			}
@@ -63,7 +65,7 @@ func NewCmdDeploy() *cobra.Command {
 			targetedAppName := deployOpts.App
 			targetedProjectName := deployOpts.Project
 
-			knownProjects, err := db.GetSync("project", targetedProjectName, []string{})
+			knownProjects, err := db.GetSync(ctx, "project", targetedProjectName, []string{})
 			if err != nil {
 				return err
 			}
@@ -88,7 +90,7 @@ func NewCmdDeploy() *cobra.Command {
 
 `, len(targetedProjectNames), strings.Join(targetedProjectNames, "\n"))
 
-			knownApps, err := db.GetSync("application", "", []string{})
+			knownApps, err := db.GetSync(ctx, "application", "", []string{})
 			if err != nil {
 				return err
 			}
@@ -129,9 +131,9 @@ func NewCmdDeploy() *cobra.Command {
 				// e.g. "myproj_myapp1"
 				deployName := appName
 				var deploy *api.Resource
-				deploys, err := db.GetSync("deployment", deployName, []string{})
+				deploys, err := db.GetSync(ctx, "deployment", deployName, []string{})
 				switch e := err.(type) {
-				case *dynamodb.ErrResourceNotFound:
+				case *api.ErrResourceNotFound:
 					newDeploy := &api.Resource{
 						NameHashKey: deployName,
 						Kind:        "Deployment",
@@ -144,7 +146,7 @@ func NewCmdDeploy() *cobra.Command {
 							"sha1":    targetedSha1,
 						},
 					}
-					err := db.Apply(newDeploy)
+					err := db.Apply(ctx, newDeploy)
 					if err != nil {
 						return err
 					}
@@ -159,17 +161,17 @@ func NewCmdDeploy() *cobra.Command {
 
 				if deploy.Spec["sha1"].(string) == targetedSha1 {
 					// already deployed
-					break
+					continue
 				}
 
 				deploy.Spec["sha1"] = targetedSha1
-				err = db.Apply(deploy)
+				err = db.Apply(ctx, deploy)
 				if err != nil {
 					return err
 				}
 			}
 
-			targetedClusters, err := db.GetSync("cluster", "", []string{})
+			targetedClusters, err := db.GetSync(ctx, "cluster", "", []string{})
 			if err != nil {
 				return err
 			}
@@ -186,7 +188,7 @@ func NewCmdDeploy() *cobra.Command {
 					for {
 						// TODO timeout
 						deployId := appName
-						deploys, err := db.GetSync("deployment", deployId, []string{})
+						deploys, err := db.GetSync(ctx, "deployment", deployId, []string{})
 						if err != nil {
 							return err
 						}
@@ -195,16 +197,24 @@ func NewCmdDeploy() *cobra.Command {
 						}
 						deploy := deploys[0]
 
-						//sha1, err := fetchSha1ForRef(deployOpts.Ref)
-						//if err != nil {
-						//	return err
-						//}
-						sha1 := deploy.Spec["sha1"]
+						releaseName := fmt.Sprintf("%s-%s", deploy.NameHashKey, clusterName)
+						installName, err := observedInstall(ctx, db, releaseName, deploy.Metadata.Generation)
+						if err != nil {
+							return err
+						}
+						if installName == "" {
+							fmt.Fprintf(os.Stderr, "waiting for the gateway for %s to process generation %d of deployment %s\n", clusterName, deploy.Metadata.Generation, deploy.NameHashKey)
+							select {
+							case <-time.After(5 * time.Second):
+							case <-ctx.Done():
+								return ctx.Err()
+							}
+							continue
+						}
 
-						installName := fmt.Sprintf("%s-%s-%s", appName, clusterName, sha1)
-						installs, err := db.GetSync("install", installName, []string{})
+						installs, err := db.GetSync(ctx, "install", installName, []string{})
 						switch e := err.(type) {
-						case *dynamodb.ErrResourceNotFound:
+						case *api.ErrResourceNotFound:
 							fmt.Fprintf(os.Stderr, "waiting for install of %s to start\n", installName)
 							time.Sleep(5 * time.Second)
 						case nil:
@@ -216,30 +226,42 @@ func NewCmdDeploy() *cobra.Command {
 
 							go func() {
 								for {
-									time.Sleep(5 * time.Second)
-									is, err := db.GetSync("install", installName, []string{})
+									select {
+									case <-time.After(5 * time.Second):
+									case <-ctx.Done():
+										return
+									}
+									is, err := db.GetSync(ctx, "install", installName, []string{})
 									if err != nil {
 										fmt.Fprintf(os.Stderr, "error while polling install status. ignoring: %v\n", err)
 									}
 									if is != nil {
 										ins := is[0]
-										installPhase := ins.Spec["phase"]
+										installPhase := installPhaseOf(ins)
 										fmt.Fprintf(os.Stderr, "install %s: phase=%s\n", installName, installPhase)
 										if installPhase == "completed" {
-											installResults <- &installResult{}
+											select {
+											case installResults <- &installResult{}:
+											case <-ctx.Done():
+											}
 											return
 										} else if installPhase == "failed" {
-											installResults <- &installResult{err: fmt.Errorf("install %s has failed", installName)}
+											select {
+											case installResults <- &installResult{err: fmt.Errorf("install %s has failed", installName)}:
+											case <-ctx.Done():
+											}
 											return
 										}
 									}
 								}
 							}()
 
-							logMsgs, logErrs := logs.Read("install", installName, 0, true)
+							logMsgs, logErrs := logs.Read(ctx, "install", installName, 0, true)
 
 							for logMsgs != nil || logErrs != nil || installResults != nil {
 								select {
+								case <-ctx.Done():
+									return ctx.Err()
 								case r, ok := <-installResults:
 									if ok {
 										logMsgs = nil
@@ -258,7 +280,7 @@ func NewCmdDeploy() *cobra.Command {
 								case err, ok := <-logErrs:
 									logErrs = nil
 									switch err.(type) {
-									case *dynamodb.ErrLogsNotFound:
+									case *api.ErrLogsNotFound:
 										fmt.Fprintf(os.Stderr, "waiting for logs of %s to flow\n", installName)
 										time.Sleep(5 * time.Second)
 										continue L
@@ -293,3 +315,24 @@ func NewCmdDeploy() *cobra.Command {
 
 	return cmd
 }
+
+// observedInstall returns the name of the install the gateway has triggered for the generation of the deployment,
+// or an empty string when the gateway has yet to process the generation
+func observedInstall(ctx context.Context, db api.Store, releaseName string, generation int64) (string, error) {
+	rs, err := db.GetSync(ctx, "release", releaseName, []string{})
+	if _, ok := err.(*api.ErrResourceNotFound); ok {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	rel := rs[0]
+	if gen, _ := rel.SpecInt64("deploymentGeneration"); gen < generation {
+		return "", nil
+	}
+	if observed, ok := rel.ObservedGeneration(); !ok || observed < rel.Metadata.Generation {
+		return "", nil
+	}
+	install, _ := rel.Status["install"].(string)
+	return install, nil
+}
@@ -15,8 +15,10 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"github.com/mumoshu/division/dynamodb"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
 	"github.com/spf13/cobra"
 	"os"
 	"strings"
@@ -37,30 +39,39 @@ func init() {
 
 func NewCmdGet() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "get RESOURCE [NAME]",
+		Use:   "get RESOURCE|CATEGORY [NAME]",
 		Short: "Displays one or more resources",
-		Args:  cobra.RangeArgs(0, 2),
+		Long: `Displays one or more resources.
+
+RESOURCE is the name, plural, short name or kind of the resource type, like "cluster", "clusters" or "Cluster".
+Suffix it by the version like "clusters.v1" to get resources converted to the version.
+CATEGORY is like "all", that gets resources of every type in the category.`,
+		Args: cobra.RangeArgs(0, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
+
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
+			if err != nil {
+				return err
+			}
 
-			db, err := dynamodb.NewDB(globalOpts.Config, globalOpts.Namespace)
+			resolver, err := framework.LoadResourceResolver(ctx, db, globalOpts.Config)
 			if err != nil {
 				return err
 			}
 
 			if len(args) == 0 {
-				crds, err := db.GetCRDs()
-				if err != nil {
-					return err
-				}
-				names := make([]string, len(crds))
-				for i, crd := range crds {
-					names[i] = fmt.Sprintf("  * %s", crd.Metadata.Name)
+				types := resolver.Describe()
+				for i := range types {
+					types[i] = fmt.Sprintf("  * %s", types[i])
 				}
 				fmt.Fprintf(os.Stderr, `You must specify the type of resource to get. Valid resource types include:
 
 %s
-`, strings.Join(names, "\n"))
+
+Or specify a category like "all" to get resources of every type in it.
+`, strings.Join(types, "\n"))
 				os.Exit(1)
 			} else {
 				var name string
@@ -69,10 +80,26 @@ func NewCmdGet() *cobra.Command {
 				} else {
 					name = ""
 				}
-				resource := args[0]
-				err = db.GetPrint(resource, name, getOpts.Selectors, globalOpts.Output, getOpts.Watch)
-				if err != nil {
-					return err
+				if def, version, ok := resolver.Resolve(args[0]); ok {
+					if err := checkNamespace(def); err != nil {
+						return err
+					}
+					if version != "" {
+						return getPrintVersion(ctx, db, def, version, name)
+					}
+					return db.GetPrint(ctx, def.Metadata.Name, name, getOpts.Selectors, globalOpts.Output, getOpts.Watch)
+				}
+				resources := resolver.ResolveCategory(args[0])
+				if len(resources) == 0 {
+					return fmt.Errorf("unknown resource type or category \"%s\": the resource type must be one of %s", args[0], strings.Join(resolver.Describe(), ", "))
+				}
+				if name != "" || getOpts.Watch {
+					return fmt.Errorf("neither NAME nor --watch can be specified for category \"%s\"", args[0])
+				}
+				for _, resource := range resources {
+					if err := db.GetPrint(ctx, resource, "", getOpts.Selectors, globalOpts.Output, false); err != nil {
+						return err
+					}
 				}
 			}
 			return nil
@@ -80,9 +107,30 @@ func NewCmdGet() *cobra.Command {
 	}
 
 	flags := cmd.Flags()
-	flags.StringSliceVarP(&getOpts.Selectors, "selector", "l", []string{}, "Selector (label query) to filter on, supports '=', '==', and '!='.(e.g. -l key1=value1,key2=value2)")
+	flags.StringSliceVarP(&getOpts.Selectors, "selector", "l", []string{}, "Selector (label query) to filter on, supports '=', '==', '!=', 'in', 'notin', 'key' and '!key'.(e.g. -l key1=value1,key2=value2 -l 'key3 in (value3,value4)')")
 	flags.BoolVarP(&getOpts.Watch, "watch", "w", false, "After listing/getting the requested object, watch for changes. Uninitialized objects are excluded if no object name is provided.")
 
 	return cmd
 
 }
+
+// getPrintVersion prints resources converted to the version, so that e.g. a CI job written for an older version keeps working
+func getPrintVersion(ctx context.Context, db api.Store, def *api.CustomResourceDefinition, version, name string) error {
+	if getOpts.Watch {
+		evCh, errCh := db.Watch(ctx, def.Metadata.Name, name, getOpts.Selectors, api.WatchOptions{})
+		evCh, errCh = framework.ConvertWatchEvents(ctx, def, version, evCh, errCh)
+		return framework.PrintWatchEventsSync(ctx, evCh, errCh, globalOpts.Output)
+	}
+	rs, err := db.GetSync(ctx, def.Metadata.Name, name, getOpts.Selectors)
+	if err != nil {
+		return err
+	}
+	for _, r := range rs {
+		converted, err := framework.ConvertResource(ctx, def, r, version)
+		if err != nil {
+			return err
+		}
+		framework.WriteToStdout(converted.Format(globalOpts.Output))
+	}
+	return nil
+}
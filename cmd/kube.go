@@ -10,12 +10,10 @@ import (
 	"flag"
 )
 
-func init() {
-	flag.Parse()
-}
-
 // kubeClient returns a Kubernetes clientset.
+// Flags are parsed here rather than on init, so that tests of the package can define their own flags first
 func kubeClient() (*kubernetes.Clientset, error) {
+	flag.Parse()
 	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 		clientcmd.NewDefaultClientConfigLoadingRules(),
 		&clientcmd.ConfigOverrides{},
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"time"
+)
+
+type LockOptions struct {
+	TTL     time.Duration
+	Holder  string
+	Timeout time.Duration
+}
+
+// Subcommands have their own options, as their flags have different defaults
+var (
+	lockAcquireOpts LockOptions
+	lockReleaseOpts LockOptions
+	lockRunOpts     LockOptions
+)
+
+func NewCmdLock() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Acquire and release locks shared among CI jobs, backed by leases in the store",
+	}
+	cmd.AddCommand(newCmdLockAcquire())
+	cmd.AddCommand(newCmdLockRelease())
+	cmd.AddCommand(newCmdLockRun())
+	return cmd
+}
+
+func newCmdLockAcquire() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "acquire NAME",
+		Short: "Wait for the lock and acquire it. Run it again with the same holder to extend the lock",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateLockTTL(lockAcquireOpts.TTL); err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
+
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
+			if err != nil {
+				return err
+			}
+
+			name := args[0]
+			if lockAcquireOpts.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, lockAcquireOpts.Timeout)
+				defer cancel()
+			}
+			waiting := false
+			for {
+				lease, err := db.AcquireLease(ctx, name, lockAcquireOpts.Holder, lockAcquireOpts.TTL)
+				if err == nil {
+					spec, err := api.LeaseSpecOf(lease)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("lock \"%s\" acquired by \"%s\" until %s\n", name, spec.HolderIdentity, spec.RenewTime.Add(lockAcquireOpts.TTL).Format(time.RFC3339))
+					return nil
+				}
+				if _, ok := err.(*api.ErrConflict); !ok {
+					return err
+				}
+				if !waiting {
+					fmt.Fprintf(os.Stderr, "waiting for lock: %v\n", err)
+					waiting = true
+				}
+				select {
+				case <-ctx.Done():
+					if ctx.Err() == context.DeadlineExceeded {
+						return fmt.Errorf("timed out waiting for lock \"%s\": %v", name, err)
+					}
+					return newErrInterrupted(fmt.Sprintf("interrupted while waiting for lock \"%s\"", name))
+				case <-time.After(lockRetryPeriod(lockAcquireOpts.TTL)):
+				}
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&lockAcquireOpts.Holder, "holder", "", "Unique name of the holder like the CI job ID. Give the same holder to `div lock release`")
+	flags.DurationVar(&lockAcquireOpts.TTL, "ttl", 10*time.Minute, "Duration after which the lock expires unless it is acquired again by the holder")
+	flags.DurationVar(&lockAcquireOpts.Timeout, "timeout", 0, "Stop waiting for the lock after a duration like 5s, 2m, or 3h. Defaults to 0s=forever.")
+	cmd.MarkFlagRequired("holder")
+	return cmd
+}
+
+func newCmdLockRelease() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "release NAME",
+		Short: "Release the lock so that others can acquire it without waiting for it to expire",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
+
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
+			if err != nil {
+				return err
+			}
+
+			name := args[0]
+			if err := db.ReleaseLease(ctx, name, lockReleaseOpts.Holder); err != nil {
+				return err
+			}
+			fmt.Printf("lock \"%s\" released by \"%s\"\n", name, lockReleaseOpts.Holder)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&lockReleaseOpts.Holder, "holder", "", "Unique name of the holder given to `div lock acquire`")
+	cmd.MarkFlagRequired("holder")
+	return cmd
+}
+
+func newCmdLockRun() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run NAME -- COMMAND [ARGS...]",
+		Short: "Run the command while holding the lock, renewing it until the command exits",
+		Long: `Run the command while holding the lock, renewing it until the command exits.
+
+The command is interrupted when the lock is lost, like when div has been unable to reach the store for a while.
+div exits with the exit code of the command.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateLockTTL(lockRunOpts.TTL); err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
+
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
+			if err != nil {
+				return err
+			}
+
+			name := args[0]
+			holder := lockRunOpts.Holder
+			if holder == "" {
+				holder = defaultIdentity()
+			}
+			le, err := framework.NewLeaderElector(db, framework.LeaderElectionConfig{
+				Lease:         name,
+				Identity:      holder,
+				LeaseDuration: lockRunOpts.TTL,
+				RenewDeadline: lockRunOpts.TTL * 2 / 3,
+				RetryPeriod:   lockRetryPeriod(lockRunOpts.TTL),
+			})
+			if err != nil {
+				return err
+			}
+
+			// Stop waiting for the lock after the timeout, but never stop the command once it has started
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			started := make(chan struct{})
+			timedOut := make(chan struct{})
+			if lockRunOpts.Timeout > 0 {
+				go func() {
+					select {
+					case <-started:
+					case <-ctx.Done():
+					case <-time.After(lockRunOpts.Timeout):
+						close(timedOut)
+						cancel()
+					}
+				}()
+			}
+
+			var cmdErr error
+			finished := make(chan struct{})
+			err = le.Run(ctx, func(ctx context.Context) {
+				defer close(finished)
+				close(started)
+				cmdErr = runInterruptibly(ctx, args[1], args[2:]...)
+			})
+			select {
+			case <-timedOut:
+				return fmt.Errorf("timed out waiting for lock \"%s\"", name)
+			default:
+			}
+			select {
+			case <-started:
+			default:
+				if err != nil {
+					return err
+				}
+				// Never exit successfully without running the command
+				return newErrInterrupted(fmt.Sprintf("interrupted while waiting for lock \"%s\"", name))
+			}
+			if err != nil {
+				// The lock is lost and the command is being interrupted. Wait for it to stop until others can take the lock over
+				select {
+				case <-finished:
+				case <-time.After(lockRunOpts.TTL / 3):
+					fmt.Fprintf(os.Stderr, "%s is still running after the lock is lost\n", args[1])
+				}
+				return err
+			}
+			return cmdErr
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&lockRunOpts.Holder, "holder", "", "Unique name of the holder like the CI job ID. Defaults to \"<hostname>-<pid>\"")
+	flags.DurationVar(&lockRunOpts.TTL, "ttl", time.Minute, "Duration after which the lock expires when div stops renewing it, like when it has crashed")
+	flags.DurationVar(&lockRunOpts.Timeout, "timeout", 0, "Stop waiting for the lock after a duration like 5s, 2m, or 3h. Defaults to 0s=forever.")
+	return cmd
+}
+
+// validateLockTTL rejects ttls too short to renew the lock before it expires
+func validateLockTTL(ttl time.Duration) error {
+	if ttl < time.Second {
+		return fmt.Errorf("invalid --ttl %v: it must be at least 1s", ttl)
+	}
+	return nil
+}
+
+// lockRetryPeriod returns how often the lock is retried and renewed, so that a released lock is taken over quickly
+// while the lock with a short ttl is renewed well before it expires
+func lockRetryPeriod(ttl time.Duration) time.Duration {
+	if ttl/4 < framework.DefaultRetryPeriod {
+		return ttl / 4
+	}
+	return framework.DefaultRetryPeriod
+}
+
+// runInterruptibly runs the command connected to stdio, interrupting it when the context is canceled so that it can stop gracefully
+func runInterruptibly(ctx context.Context, name string, args ...string) error {
+	c := exec.Command(name, args...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		fmt.Fprintf(os.Stderr, "interrupting %s\n", name)
+		c.Process.Signal(os.Interrupt)
+		return <-done
+	}
+}
@@ -15,7 +15,7 @@
 package cmd
 
 import (
-	"github.com/mumoshu/division/dynamodb"
+	"github.com/mumoshu/division/framework"
 	"github.com/spf13/cobra"
 	"time"
 )
@@ -49,13 +49,22 @@ func NewCmdLogs() *cobra.Command {
 		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
 
-			logs, err := dynamodb.NewLogs(globalOpts.Config, globalOpts.Namespace)
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
+			if err != nil {
+				return err
+			}
+			def, _, err := resolveResource(ctx, db, args[0])
+			if err != nil {
+				return err
+			}
+			logs, err := framework.NewLogStore(globalOpts.Config, globalOpts.Namespace)
 			if err != nil {
 				return err
 			}
 
-			return logs.ReadPrint(args[0], args[1], logsReadOpts.Since, logsReadOpts.Follow)
+			return logs.ReadPrint(ctx, def.Metadata.Name, args[1], logsReadOpts.Since, logsReadOpts.Follow)
 		},
 	}
 	rflags := readCmd.Flags()
@@ -68,13 +77,22 @@ func NewCmdLogs() *cobra.Command {
 		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
 
-			logs, err := dynamodb.NewLogs(globalOpts.Config, globalOpts.Namespace)
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
+			if err != nil {
+				return err
+			}
+			def, _, err := resolveResource(ctx, db, args[0])
+			if err != nil {
+				return err
+			}
+			logs, err := framework.NewLogStore(globalOpts.Config, globalOpts.Namespace)
 			if err != nil {
 				return err
 			}
 
-			return logs.WriteFile(args[0], args[1], logsWriteOpts.File)
+			return logs.WriteFile(ctx, def.Metadata.Name, args[1], logsWriteOpts.File)
 		},
 	}
 	wflags := writeCmd.Flags()
@@ -86,13 +104,22 @@ func NewCmdLogs() *cobra.Command {
 		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
 
-			logs, err := dynamodb.NewLogs(globalOpts.Config, globalOpts.Namespace)
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
+			if err != nil {
+				return err
+			}
+			def, _, err := resolveResource(ctx, db, args[0])
+			if err != nil {
+				return err
+			}
+			logs, err := framework.NewLogStore(globalOpts.Config, globalOpts.Namespace)
 			if err != nil {
 				return err
 			}
 
-			return logs.Delete(args[0], args[1])
+			return logs.Delete(ctx, def.Metadata.Name, args[1])
 		},
 	}
 
@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/exec"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 )
 
@@ -24,6 +27,66 @@ func Execute() {
 	if err := cmd.Execute(); err != nil {
 		//cmd.SetOutput(os.Stderr)
 		//cmd.Println(err)
+		// Exit with the exit code of the command run by e.g. `div lock run`
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				// Like shells, a command killed by a signal exits with 128 + the signal number
+				if status.Signaled() {
+					os.Exit(128 + int(status.Signal()))
+				}
+				os.Exit(status.ExitStatus())
+			}
+		}
+		if intErr, ok := err.(*errInterrupted); ok {
+			os.Exit(128 + int(intErr.signal))
+		}
 		os.Exit(1)
 	}
 }
+
+// interruptibleContext returns the context that is canceled on interrupt or termination, so that the command stops watching, following or waiting gracefully.
+// The second signal terminates the process as usual
+func interruptibleContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		signal.Stop(c)
+		receivedSignal.Store(sig)
+		fmt.Fprintln(os.Stderr, "interrupted")
+		cancel()
+	}()
+	return ctx
+}
+
+// receivedSignal is the signal that has canceled the context returned by interruptibleContext
+var receivedSignal atomic.Value
+
+// errInterrupted is returned when the command has been interrupted before it finishes its work, like while waiting for a lock.
+// div exits with 128 + the signal number like shells
+type errInterrupted struct {
+	msg    string
+	signal syscall.Signal
+}
+
+func newErrInterrupted(msg string) *errInterrupted {
+	sig, ok := receivedSignal.Load().(syscall.Signal)
+	if !ok {
+		sig = syscall.SIGINT
+	}
+	return &errInterrupted{msg: msg, signal: sig}
+}
+
+func (e *errInterrupted) Error() string {
+	return e.msg
+}
+
+// defaultIdentity returns the name unique to this process, used to tell lease holders apart
+func defaultIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"os"
+	"strings"
+)
+
+// resolveResource returns the definition of the resource type given on the command-line as its name, plural, short name or kind,
+// and the version when the type is suffixed by it like "deployments.v1"
+func resolveResource(ctx context.Context, db api.Store, resource string) (*api.CustomResourceDefinition, string, error) {
+	r, err := framework.LoadResourceResolver(ctx, db, globalOpts.Config)
+	if err != nil {
+		return nil, "", err
+	}
+	def, version, ok := r.Resolve(resource)
+	if !ok {
+		return nil, "", fmt.Errorf("unknown resource type \"%s\": it must be one of %s", resource, strings.Join(r.Describe(), ", "))
+	}
+	if err := checkNamespace(def); err != nil {
+		return nil, "", err
+	}
+	return def, version, nil
+}
+
+// checkNamespace warns that --namespace is ignored for the cluster-scoped resource type, and rejects the empty namespace for the namespaced one
+func checkNamespace(def *api.CustomResourceDefinition) error {
+	if def.Spec.ClusterScoped() {
+		if namespaceFlag != nil && namespaceFlag.Changed {
+			fmt.Fprintf(os.Stderr, "--namespace is ignored for %s, as it is cluster-scoped\n", def.Metadata.Name)
+		}
+		return nil
+	}
+	if globalOpts.Namespace == "" {
+		return fmt.Errorf("--namespace must not be empty for %s, as it is namespaced", def.Metadata.Name)
+	}
+	return nil
+}
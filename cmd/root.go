@@ -23,6 +23,7 @@ package cmd
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -37,6 +38,9 @@ type GlobalOptions struct {
 
 var globalOpts GlobalOptions
 
+// namespaceFlag tells whether --namespace is given explicitly
+var namespaceFlag *pflag.Flag
+
 // RootCmd represents the base command when called without any subcommands
 func NewCmdRoot() *cobra.Command {
 	cmd := &cobra.Command{
@@ -46,14 +50,15 @@ func NewCmdRoot() *cobra.Command {
 	}
 	cobra.OnInitialize(initConfig)
 
-	cmd.PersistentFlags().StringVar(&profile, "profile", "default", "AWS profile")
-	cmd.PersistentFlags().StringVar(&region, "region", "ap-northeast-1", "AWS region")
+	cmd.PersistentFlags().StringVar(&profile, "profile", "", "AWS profile. Defaults to spec.aws.profile in the config file, AWS_PROFILE, or \"default\"")
+	cmd.PersistentFlags().StringVar(&region, "region", "", "AWS region. Defaults to spec.aws.region in the config file, AWS_REGION, or the region of the AWS profile")
 
 	viper.BindPFlag("profile", cmd.PersistentFlags().Lookup("profile"))
 	viper.BindPFlag("region", cmd.PersistentFlags().Lookup("region"))
 
 	flags := cmd.PersistentFlags()
-	flags.StringVarP(&globalOpts.Namespace, "namespace", "n", "default", "Namespace to restrict fetched resources")
+	flags.StringVarP(&globalOpts.Namespace, "namespace", "n", "default", "Namespace to restrict fetched resources. Ignored for cluster-scoped resources")
+	namespaceFlag = flags.Lookup("namespace")
 	flags.StringVarP(&globalOpts.Config, "config", "c", "div.yaml", "Config file containing custom resource definitions")
 	flags.StringVarP(&globalOpts.Output, "output", "o", "json", "Output format. One of: text|json|yaml")
 
@@ -65,6 +70,7 @@ func NewCmdRoot() *cobra.Command {
 	cmd.AddCommand(NewCmdDel())
 	cmd.AddCommand(NewCmdGateway())
 	cmd.AddCommand(NewCmdDeploy())
+	cmd.AddCommand(NewCmdLock())
 
 	return cmd
 }
@@ -15,20 +15,34 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/Azure/brigade/pkg/script"
 	"github.com/mumoshu/division/api"
-	"github.com/mumoshu/division/dynamodb"
+	"github.com/mumoshu/division/framework"
 	"github.com/spf13/cobra"
 	"io"
 	"k8s.io/client-go/kubernetes"
 	"os"
 	"strings"
+	"time"
 )
 
 type GatewayOptions struct {
-	Cluster string
-	Project string
+	Cluster        string
+	Project        string
+	Selectors      []string
+	Checkpoint     string
+	Workers        int
+	ResyncPeriod   time.Duration
+	InstallTimeout time.Duration
+
+	LeaderElect              bool
+	LeaderElectLease         string
+	LeaderElectIdentity      string
+	LeaderElectLeaseDuration time.Duration
+	LeaderElectRenewDeadline time.Duration
+	LeaderElectRetryPeriod   time.Duration
 }
 
 var gatewayOpts GatewayOptions
@@ -40,6 +54,7 @@ func NewCmdGateway() *cobra.Command {
 		Args:  cobra.RangeArgs(0, 0),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
 
 			// Namespace corresponds to environment, that differentiates e.g. production vs staging vs development
 			c, err := kubeClient()
@@ -48,12 +63,12 @@ func NewCmdGateway() *cobra.Command {
 			}
 
 			env := globalOpts.Namespace
-			db, err := dynamodb.NewDB(globalOpts.Config, env)
+			db, err := framework.NewStore(globalOpts.Config, env)
 			if err != nil {
 				return err
 			}
 
-			logs, err := dynamodb.NewLogs(globalOpts.Config, env)
+			logs, err := framework.NewLogStore(globalOpts.Config, env)
 			if err != nil {
 				return err
 			}
@@ -61,7 +76,7 @@ func NewCmdGateway() *cobra.Command {
 			clusterName := gatewayOpts.Cluster
 			targetedProjectName := gatewayOpts.Project
 
-			knownProjects, err := db.GetSync("project", targetedProjectName, []string{})
+			knownProjects, err := db.GetSync(ctx, "project", targetedProjectName, []string{})
 			if err != nil {
 				return err
 			}
@@ -96,7 +111,7 @@ func NewCmdGateway() *cobra.Command {
 				}
 			}
 
-			knownApps, err := db.GetSync("application", "", []string{})
+			knownApps, err := db.GetSync(ctx, "application", "", []string{})
 			if err != nil {
 				return err
 			}
@@ -107,7 +122,7 @@ func NewCmdGateway() *cobra.Command {
 					continue
 				}
 				if app.Spec["project"] == nil {
-					panic(fmt.Sprintf("application \"%s\" has no \"project\" field", appName))
+					return fmt.Errorf("application \"%s\" has no \"project\" field", appName)
 				}
 				appProject := app.Spec["project"].(string)
 				if _, ok := targetedProjects[appProject]; !ok {
@@ -124,125 +139,61 @@ func NewCmdGateway() *cobra.Command {
 				c,
 				logs,
 				db,
+				nil,
+				gatewayOpts.InstallTimeout,
 			}
 
-			newInstalls := make(chan *api.Resource, 1)
-			deploys, deployErrs := db.GetAsync("deployment", "", []string{}, true)
-			releases, releaseErrs := db.GetAsync("release", "", []string{}, true)
-			installs, installErrs := db.GetAsync("install", "", []string{}, true)
-			for {
-				select {
-				case d := <-deploys:
-					if d.Spec["project"] == nil {
-						panic(fmt.Sprintf("deployment \"%s\" has no \"project\" field", d.NameHashKey))
-					}
-					if d.Spec["app"] == nil {
-						panic(fmt.Sprintf("deployment \"%s\" has no \"app\" field", d.NameHashKey))
-					}
-					deployProj := d.Spec["project"].(string)
-					deployApp := d.Spec["app"].(string)
-					_, hasTargetedProj := targetedProjects[deployProj]
-					_, hasTargetedApp := targetedApps[deployApp]
-					if hasTargetedProj && hasTargetedApp {
-						releaseName := fmt.Sprintf("%s-%s", d.NameHashKey, clusterName)
-						sha1 := d.Spec["sha1"]
-
-						rs, e := db.GetSync("release", releaseName, []string{})
-						if e != nil {
-							switch e.(type) {
-							case *dynamodb.ErrResourceNotFound:
-
-							default:
-								panic(e)
-							}
-						}
-						if len(rs) == 0 || rs[0].Spec["sha1"] != sha1 {
-							newRelease := &api.Resource{
-								NameHashKey: releaseName,
-								Metadata: api.Metadata{
-									Name: releaseName,
-								},
-								Kind: "Release",
-								Spec: map[string]interface{}{
-									"project": deployProj,
-									"app":     deployApp,
-									"sha1":    sha1,
-									"cluster": clusterName,
-								},
-							}
-							err := db.Apply(newRelease)
-							if err != nil {
-								panic(err)
-							}
-						}
-					}
-				case r := <-releases:
-					relProj := r.Spec["project"].(string)
-					relApp := r.Spec["app"].(string)
-					relCluster := r.Spec["cluster"].(string)
-					_, hasTargetedProj := targetedProjects[relProj]
-					_, hasTargetedApp := targetedApps[relApp]
-					hasTargetedCluster := relCluster == clusterName
-					if hasTargetedProj && hasTargetedApp && hasTargetedCluster {
-						sha1 := r.Spec["sha1"]
-						installName := fmt.Sprintf("%s-%s", r.NameHashKey, sha1)
-
-						is, e := db.GetSync("install", installName, []string{})
-						if e != nil {
-							switch e.(type) {
-							case *dynamodb.ErrResourceNotFound:
-
-							default:
-								panic(e)
-							}
-						}
-						if len(is) == 0 {
-							newInstall := &api.Resource{
-								NameHashKey: installName,
-								Metadata: api.Metadata{
-									Name: installName,
-								},
-								Kind: "Install",
-								Spec: map[string]interface{}{
-									"project": relProj,
-									"app":     relApp,
-									"sha1":    sha1,
-									"cluster": clusterName,
-									"phase":   "pending",
-								},
-							}
-							err := db.Apply(newInstall)
-							if err != nil {
-								panic(err)
-							}
-							// newInstall is propagated to
-							newInstalls <- newInstall
-						} else {
-							ins := is[0]
-							fmt.Fprintf(os.Stderr, "install \"%s\" is already %s\n. no need to trigger another install. skipping...", ins.NameHashKey, ins.Spec["phase"])
-						}
-					}
-				case i, ok := <-installs:
-					if !ok {
-						installs = nil
-						panic("TODO: install stream stopped unexpectedly. implement automatic retry")
-					}
-					g.handleInstall(i)
-				case i, ok := <-newInstalls:
-					if !ok {
-						installs = nil
-						panic("TODO: install stream stopped unexpectedly. implement automatic retry")
-					}
-					g.handleInstall(i)
-				case e := <-installErrs:
-					panic(e)
-				case e := <-deployErrs:
-					panic(e)
-				case e := <-releaseErrs:
-					panic(e)
+			// Resume watches from where the previous gateway has stopped, so that we won't miss changes made while it was down
+			checkpoint := gatewayOpts.Checkpoint
+			if checkpoint == "" {
+				checkpoint = fmt.Sprintf("gateway-%s", clusterName)
+				if targetedProjectName != "" {
+					checkpoint = fmt.Sprintf("%s-%s", checkpoint, targetedProjectName)
 				}
 			}
 
+			// Releases and installs inherit labels from deployments, so that the selector applies to all of them
+			mgr := framework.NewManager(db, framework.InformerOptions{
+				Selectors:    gatewayOpts.Selectors,
+				ResyncPeriod: gatewayOpts.ResyncPeriod,
+				Checkpoint:   checkpoint,
+			})
+			g.mgr = mgr
+			ctrlOpts := framework.ControllerOptions{Workers: gatewayOpts.Workers}
+			if _, err := mgr.Register("deployment", framework.ReconcilerFunc(g.reconcileDeployment), ctrlOpts); err != nil {
+				return err
+			}
+			if _, err := mgr.Register("release", framework.ReconcilerFunc(g.reconcileRelease), ctrlOpts); err != nil {
+				return err
+			}
+			if _, err := mgr.Register("install", framework.ReconcilerFunc(g.reconcileInstall), ctrlOpts); err != nil {
+				return err
+			}
+
+			if gatewayOpts.LeaderElect {
+				// Replicas of the gateway share the checkpoint, so that the new leader resumes from where the previous one has stopped
+				lease := gatewayOpts.LeaderElectLease
+				if lease == "" {
+					lease = checkpoint
+				}
+				identity := gatewayOpts.LeaderElectIdentity
+				if identity == "" {
+					identity = defaultIdentity()
+				}
+				le, err := framework.NewLeaderElector(db, framework.LeaderElectionConfig{
+					Lease:         lease,
+					Identity:      identity,
+					LeaseDuration: gatewayOpts.LeaderElectLeaseDuration,
+					RenewDeadline: gatewayOpts.LeaderElectRenewDeadline,
+					RetryPeriod:   gatewayOpts.LeaderElectRetryPeriod,
+				})
+				if err != nil {
+					return err
+				}
+				return le.Run(ctx, mgr.Run)
+			}
+			mgr.Run(ctx)
+
 			// <namespace=production>
 			// + (state) myproj-app1 (application w/ id=myproj-app1 name=app1 project=myproj, app1 in project myproj)
 			// | + upsert + version : deploy myproj/app1 --ref v1.0.0
@@ -266,45 +217,236 @@ func NewCmdGateway() *cobra.Command {
 	options := cmd.Flags()
 	options.StringVar(&gatewayOpts.Cluster, "cluster", "", "Unique name of the cluster on which this gateway is running")
 	options.StringVar(&gatewayOpts.Project, "project", "", "Unique name of the project which this gateway watches")
+	options.StringVar(&gatewayOpts.Checkpoint, "checkpoint", "", "Unique name of this gateway, used to persist positions in change streams so that a restarted gateway resumes from them. Defaults to \"gateway-<cluster>[-<project>]\"")
+	options.StringSliceVarP(&gatewayOpts.Selectors, "selector", "l", []string{}, "Selector (label query) to filter deployments, releases and installs this gateway watches. Releases and installs inherit labels from deployments")
+	options.IntVar(&gatewayOpts.Workers, "workers", 1, "Number of deployments, releases and installs reconciled concurrently per kind")
+	options.DurationVar(&gatewayOpts.ResyncPeriod, "resync-period", 5*time.Minute, "How often all the deployments, releases and installs are reconciled again even without changes. 0 disables resyncs")
+	options.DurationVar(&gatewayOpts.InstallTimeout, "install-timeout", time.Hour, "How long an install can be running before it's marked failed, so that installs claimed by a gateway that has died are finished")
+	options.BoolVar(&gatewayOpts.LeaderElect, "leader-elect", false, "Elect the leader among replicas of this gateway, so that only the leader creates releases and runs installs")
+	options.StringVar(&gatewayOpts.LeaderElectLease, "leader-elect-lease", "", "Name of the lease replicas compete for. Defaults to the checkpoint name")
+	options.StringVar(&gatewayOpts.LeaderElectIdentity, "leader-elect-identity", "", "Unique name of this replica. Defaults to \"<hostname>-<pid>\"")
+	options.DurationVar(&gatewayOpts.LeaderElectLeaseDuration, "leader-elect-lease-duration", framework.DefaultLeaseDuration, "How long replicas wait before taking over the lease the leader has stopped renewing")
+	options.DurationVar(&gatewayOpts.LeaderElectRenewDeadline, "leader-elect-renew-deadline", framework.DefaultRenewDeadline, "How long the leader retries renewing the lease before exiting")
+	options.DurationVar(&gatewayOpts.LeaderElectRetryPeriod, "leader-elect-retry-period", framework.DefaultRetryPeriod, "How often replicas try to acquire or renew the lease")
 	cmd.MarkFlagRequired("cluster")
 
 	return cmd
 
 }
 
+// Conditions of installs set by the gateway, that tell the progress of installs without knowing values of `status.phase`
+const (
+	// installScheduled is true once the install is created for the release
+	installScheduled = "Scheduled"
+	// installRunning is true while the brigade script for the install is running
+	installRunning = "Running"
+	// installSucceeded is true when the brigade script has completed, or false when it has failed
+	installSucceeded = "Succeeded"
+)
+
+const (
+	// installStatusTimeout bounds writing the terminal phase of an install
+	installStatusTimeout = 30 * time.Second
+	// installStatusRetries is how many times the terminal phase of an install is written before giving up
+	installStatusRetries = 5
+)
+
 type gateway struct {
 	targetedProjects map[string]*api.Resource
 	targetedApps     map[string]*api.Resource
 	clusterName      string
 	env              string
 	c                *kubernetes.Clientset
-	logs             *dynamodb.LogStore
-	db               dynamodb.Store
+	logs             api.LogStore
+	db               api.Store
+	mgr              *framework.Manager
+	// installTimeout is how long an install can be running before it's marked failed
+	installTimeout time.Duration
+}
+
+// cached returns the copy of the resource from the informer's cache, or nil when it doesn't exist
+func (g *gateway) cached(resource, name string) (*api.Resource, error) {
+	informer, err := g.mgr.Informer(resource)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := informer.Cache().Get(name)
+	if !ok {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// latest returns the resource from the store, or nil when it doesn't exist.
+// Unlike cached, it never misses the resource we've just created
+func (g *gateway) latest(ctx context.Context, resource, name string) (*api.Resource, error) {
+	rs, err := g.db.GetSync(ctx, resource, name, []string{})
+	if err != nil {
+		if _, ok := err.(*api.ErrResourceNotFound); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(rs) == 0 {
+		return nil, nil
+	}
+	return rs[0], nil
+}
+
+// reconcileDeployment creates or updates the release of the deployment for the cluster
+func (g *gateway) reconcileDeployment(ctx context.Context, name string) (framework.Result, error) {
+	d, err := g.cached("deployment", name)
+	if err != nil || d == nil {
+		return framework.Result{}, err
+	}
+	deployProj, ok := d.Spec["project"].(string)
+	if !ok {
+		return framework.Result{}, fmt.Errorf("deployment \"%s\" has no \"project\" field", d.NameHashKey)
+	}
+	deployApp, ok := d.Spec["app"].(string)
+	if !ok {
+		return framework.Result{}, fmt.Errorf("deployment \"%s\" has no \"app\" field", d.NameHashKey)
+	}
+	_, hasTargetedProj := g.targetedProjects[deployProj]
+	_, hasTargetedApp := g.targetedApps[deployApp]
+	if !hasTargetedProj || !hasTargetedApp {
+		return framework.Result{}, nil
+	}
+
+	releaseName := fmt.Sprintf("%s-%s", d.NameHashKey, g.clusterName)
+	sha1 := d.Spec["sha1"]
+
+	rel, err := g.latest(ctx, "release", releaseName)
+	if err != nil {
+		return framework.Result{}, err
+	}
+	// The release records the generation of the deployment, so that `div deploy` can tell whether the gateway has processed it
+	if rel != nil && rel.Spec["sha1"] == sha1 {
+		if gen, _ := rel.SpecInt64("deploymentGeneration"); gen >= d.Metadata.Generation {
+			return framework.Result{}, nil
+		}
+	}
+	newRelease := &api.Resource{
+		NameHashKey: releaseName,
+		Metadata: api.Metadata{
+			Name:   releaseName,
+			Labels: d.Metadata.Labels,
+		},
+		Kind: "Release",
+		Spec: map[string]interface{}{
+			"project": deployProj,
+			"app":     deployApp,
+			"sha1":    sha1,
+			"cluster": g.clusterName,
+
+			"deploymentGeneration": d.Metadata.Generation,
+		},
+	}
+	return framework.Result{}, g.db.Apply(ctx, newRelease)
+}
+
+// reconcileRelease creates the pending install of the release, that is then run by reconcileInstall
+func (g *gateway) reconcileRelease(ctx context.Context, name string) (framework.Result, error) {
+	r, err := g.cached("release", name)
+	if err != nil || r == nil {
+		return framework.Result{}, err
+	}
+	relProj, _ := r.Spec["project"].(string)
+	relApp, _ := r.Spec["app"].(string)
+	relCluster, _ := r.Spec["cluster"].(string)
+	_, hasTargetedProj := g.targetedProjects[relProj]
+	_, hasTargetedApp := g.targetedApps[relApp]
+	hasTargetedCluster := relCluster == g.clusterName
+	if !hasTargetedProj || !hasTargetedApp || !hasTargetedCluster {
+		return framework.Result{}, nil
+	}
+
+	sha1 := r.Spec["sha1"]
+	installName := fmt.Sprintf("%s-%s", r.NameHashKey, sha1)
+
+	ins, err := g.latest(ctx, "install", installName)
+	if err != nil {
+		return framework.Result{}, err
+	}
+	if ins != nil {
+		fmt.Fprintf(os.Stderr, "install \"%s\" is already %s. no need to trigger another install. skipping...\n", ins.NameHashKey, installPhaseOf(ins))
+		return framework.Result{}, g.observeRelease(ctx, r, installName)
+	}
+	newInstall := &api.Resource{
+		NameHashKey: installName,
+		Metadata: api.Metadata{
+			Name:   installName,
+			Labels: r.Metadata.Labels,
+		},
+		Kind: "Install",
+		Spec: map[string]interface{}{
+			"project": relProj,
+			"app":     relApp,
+			"sha1":    sha1,
+			"cluster": g.clusterName,
+		},
+		Status: map[string]interface{}{
+			"phase": "pending",
+		},
+	}
+	err = framework.SetCondition(newInstall, api.Condition{
+		Type:    installScheduled,
+		Status:  api.ConditionTrue,
+		Reason:  "Released",
+		Message: fmt.Sprintf("release \"%s\" is waiting for the gateway for %s to run the install", r.NameHashKey, g.clusterName),
+	})
+	if err != nil {
+		return framework.Result{}, err
+	}
+	if err := g.db.Apply(ctx, newInstall); err != nil {
+		return framework.Result{}, err
+	}
+	return framework.Result{}, g.observeRelease(ctx, r, installName)
 }
 
-func (g *gateway) handleInstall(i *api.Resource) error {
+// observeRelease records the install for the current spec of the release into its status
+func (g *gateway) observeRelease(ctx context.Context, r *api.Resource, installName string) error {
+	if r.Observed() && r.Status["install"] == installName {
+		return nil
+	}
+	r.SetObservedGeneration()
+	r.Status["install"] = installName
+	return g.db.UpdateStatus(ctx, r)
+}
+
+// reconcileInstall runs the pending install via brigade
+func (g *gateway) reconcileInstall(ctx context.Context, name string) (framework.Result, error) {
+	i, err := g.cached("install", name)
+	if err != nil || i == nil {
+		return framework.Result{}, err
+	}
+	return g.handleInstall(ctx, i)
+}
+
+func (g *gateway) handleInstall(ctx context.Context, i *api.Resource) (framework.Result, error) {
 	targetedProjects := g.targetedProjects
 	targetedApps := g.targetedApps
 	clusterName := g.clusterName
 	env := g.env
-	c := g.c
-	logs := g.logs
 	db := g.db
 
 	fmt.Fprintf(os.Stderr, "detected install: %v\n", i.Spec)
-	insProj := i.Spec["project"].(string)
-	insApp := i.Spec["app"].(string)
-	insCluster := i.Spec["cluster"].(string)
+	insProj, _ := i.Spec["project"].(string)
+	insApp, _ := i.Spec["app"].(string)
+	insCluster, _ := i.Spec["cluster"].(string)
 	_, hasTargetedProj := targetedProjects[insProj]
 	_, hasTargetedApp := targetedApps[insApp]
 	hasTargetedCluster := insCluster == clusterName
 	if hasTargetedProj && hasTargetedApp && hasTargetedCluster {
-		insPhase := i.Spec["phase"]
+		insPhase := installPhaseOf(i)
 		switch insPhase {
 		case "pending":
 			//set, _ := i.Spec["set"].(string)
 			set := ""
-			sha1 := i.Spec["sha1"].(string)
+			sha1, ok := i.Spec["sha1"].(string)
+			if !ok {
+				return framework.Result{}, fmt.Errorf("install \"%s\" has no \"sha1\" field", i.NameHashKey)
+			}
 			// dedup deployment to deployment_status by `app` and `cluster`
 			//statusKey := fmt.Sprintf("%s-%s-%s", env, cluster, app)
 
@@ -335,49 +477,176 @@ events.on("div:install", (e, p) => {
   job.run()
 })
 `)
-			persistentLogsWriter, err := logs.Writer("install", i.NameHashKey)
-			if err != nil {
-				panic(err)
+			// Claim the install by updating the phase with the resourceVersion we've seen,
+			// so that only one of gateways or users modifying the install concurrently wins
+			if i.Status == nil {
+				i.Status = map[string]interface{}{}
 			}
-
-			mul := io.MultiWriter(persistentLogsWriter, os.Stderr)
-
-			r, err := script.NewDelegatedRunner(c, "default")
+			i.Status["phase"] = "running"
+			i.SetObservedGeneration()
+			err := framework.SetCondition(i, api.Condition{
+				Type:    installRunning,
+				Status:  api.ConditionTrue,
+				Reason:  "ScriptSent",
+				Message: fmt.Sprintf("the gateway for %s is running the brigade script", clusterName),
+			})
 			if err != nil {
-				panic(err)
+				return framework.Result{}, err
 			}
-			r.ScriptLogDestination = mul
-			r.RunnerLogDestination = mul
-
-			i.Spec["phase"] = "running"
-			if err := db.Apply(i); err != nil {
-				panic(err)
-			}
-
-			var postPhase string
-			err = r.SendScript(insProj, s, "div:install", "", sha1, payload, "")
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "brigade failed: %v\n", err)
-				postPhase = "failed"
-			} else {
-				postPhase = "completed"
+			if err := db.UpdateStatus(ctx, i); err != nil {
+				if _, ok := err.(*api.ErrConflict); ok {
+					fmt.Fprintf(os.Stderr, "install \"%s\" has been modified concurrently. skipping: %v\n", i.NameHashKey, err)
+					return framework.Result{}, nil
+				}
+				return framework.Result{}, err
 			}
 
-			i.Spec["phase"] = postPhase
-			if err := db.Apply(i); err != nil {
-				panic(err)
-			}
+			postPhase, succeeded := g.runInstall(ctx, i, insProj, sha1, s, payload)
+			return framework.Result{}, g.finishInstall(i, postPhase, succeeded)
 		case "failed":
 			// TODO retry
 			fmt.Fprintf(os.Stderr, "TODO: retrying failed install: %s\n", i.NameHashKey)
 		case "running":
-			// TODO Mark it failed on timeout
-			fmt.Fprintf(os.Stderr, "TODO: install \"%s\" is already running. Remove it and redeploy in order to rerun\n", i.NameHashKey)
+			running, err := framework.GetCondition(i, installRunning)
+			if err != nil {
+				return framework.Result{}, err
+			}
+			if running == nil {
+				// Claimed by an older gateway or written before conditions. Start the timeout now, rather than failing the install right away
+				return g.observeRunningInstall(ctx, i)
+			}
+			since := running.LastTransitionTime
+			// The gateway that has claimed the install may still be running the script. Check it again once it times out
+			if elapsed := time.Since(since); elapsed < g.installTimeout {
+				fmt.Fprintf(os.Stderr, "install \"%s\" is already running. checking it again in %v\n", i.NameHashKey, g.installTimeout-elapsed)
+				return framework.Result{RequeueAfter: g.installTimeout - elapsed}, nil
+			}
+			fmt.Fprintf(os.Stderr, "install \"%s\" has been running for more than %v. marking it failed\n", i.NameHashKey, g.installTimeout)
+			return framework.Result{}, g.finishInstall(i, "failed", api.Condition{
+				Type:    installSucceeded,
+				Status:  api.ConditionFalse,
+				Reason:  "Timeout",
+				Message: fmt.Sprintf("the install has been running for more than %v", g.installTimeout),
+			})
 		case "completed":
 			fmt.Fprintf(os.Stderr, "install \"%s\" is already completed. skipping\n", i.NameHashKey)
 		default:
-			panic(fmt.Errorf("unexpected phase for \"%s\": %s", i.NameHashKey, insPhase))
+			return framework.Result{}, fmt.Errorf("unexpected phase for \"%s\": %s", i.NameHashKey, insPhase)
+		}
+	}
+	return framework.Result{}, nil
+}
+
+// observeRunningInstall records when the gateway has observed the running install without the Running condition,
+// and checks it again once it times out
+func (g *gateway) observeRunningInstall(ctx context.Context, i *api.Resource) (framework.Result, error) {
+	if i.Status == nil {
+		i.Status = map[string]interface{}{}
+	}
+	i.Status["phase"] = "running"
+	err := framework.SetCondition(i, api.Condition{
+		Type:    installRunning,
+		Status:  api.ConditionTrue,
+		Reason:  "Observed",
+		Message: fmt.Sprintf("the gateway for %s has observed the install running without the condition", g.clusterName),
+	})
+	if err != nil {
+		return framework.Result{}, err
+	}
+	if err := g.db.UpdateStatus(ctx, i); err != nil {
+		if _, ok := err.(*api.ErrConflict); !ok {
+			return framework.Result{}, err
+		}
+		// The install is reconciled again on the concurrent update
+		fmt.Fprintf(os.Stderr, "install \"%s\" has been modified concurrently: %v\n", i.NameHashKey, err)
+	}
+	fmt.Fprintf(os.Stderr, "install \"%s\" is already running. checking it again in %v\n", i.NameHashKey, g.installTimeout)
+	return framework.Result{RequeueAfter: g.installTimeout}, nil
+}
+
+// installPhaseOf returns the phase of the install.
+// Installs written before the status subresource keep it in `spec.phase`, until the gateway writes the status of them
+func installPhaseOf(i *api.Resource) interface{} {
+	if phase, ok := i.Status["phase"]; ok {
+		return phase
+	}
+	return i.Spec["phase"]
+}
+
+// runInstall runs the brigade script for the install the gateway has claimed, and returns the phase and the Succeeded condition to be written.
+// Failures are reported as the condition rather than errors, as retrying the reconciliation never reruns the claimed install
+func (g *gateway) runInstall(ctx context.Context, i *api.Resource, project, sha1 string, s, payload []byte) (string, api.Condition) {
+	fail := func(reason string, err error) (string, api.Condition) {
+		fmt.Fprintf(os.Stderr, "install \"%s\" failed: %v\n", i.NameHashKey, err)
+		return "failed", api.Condition{Type: installSucceeded, Status: api.ConditionFalse, Reason: reason, Message: err.Error()}
+	}
+
+	persistentLogsWriter, err := g.logs.Writer(ctx, "install", i.NameHashKey)
+	if err != nil {
+		return fail("LogsUnavailable", err)
+	}
+	// Flush the logs before the install is finished, so that readers following them see the last lines
+	defer func() {
+		if err := persistentLogsWriter.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close logs of install \"%s\": %v\n", i.NameHashKey, err)
+		}
+	}()
+
+	mul := io.MultiWriter(persistentLogsWriter, os.Stderr)
+
+	r, err := script.NewDelegatedRunner(g.c, "default")
+	if err != nil {
+		return fail("RunnerUnavailable", err)
+	}
+	r.ScriptLogDestination = mul
+	r.RunnerLogDestination = mul
+
+	if err := r.SendScript(project, s, "div:install", "", sha1, payload, ""); err != nil {
+		return fail("ScriptFailed", fmt.Errorf("brigade failed: %v", err))
+	}
+	return "completed", api.Condition{Type: installSucceeded, Status: api.ConditionTrue, Reason: "ScriptCompleted"}
+}
+
+// finishInstall writes the terminal phase and conditions of the running install.
+// It never uses the context of the reconciliation, that is canceled on shutdown or loss of leadership even after the script has finished.
+// Conflicting writes are retried against the latest install, unless it's no longer running as it has been finished by someone else
+func (g *gateway) finishInstall(i *api.Resource, phase string, succeeded api.Condition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), installStatusTimeout)
+	defer cancel()
+
+	var err error
+	for attempt := 0; attempt < installStatusRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return fmt.Errorf("failed to update install \"%s\" to %s: %v", i.NameHashKey, phase, err)
+			}
+			latest, getErr := g.latest(ctx, "install", i.NameHashKey)
+			if getErr != nil {
+				err = getErr
+				continue
+			}
+			if latest == nil || installPhaseOf(latest) != "running" {
+				fmt.Fprintf(os.Stderr, "install \"%s\" is no longer running. skipping update to %s\n", i.NameHashKey, phase)
+				return nil
+			}
+			i = latest
+		}
+		if i.Status == nil {
+			i.Status = map[string]interface{}{}
+		}
+		i.Status["phase"] = phase
+		if err = framework.SetCondition(i, api.Condition{Type: installRunning, Status: api.ConditionFalse, Reason: succeeded.Reason}); err != nil {
+			return err
+		}
+		if err = framework.SetCondition(i, succeeded); err != nil {
+			return err
+		}
+		if err = g.db.UpdateStatus(ctx, i); err == nil {
+			return nil
 		}
+		fmt.Fprintf(os.Stderr, "failed to update install \"%s\" to %s. retrying: %v\n", i.NameHashKey, phase, err)
 	}
-	return nil
+	return fmt.Errorf("failed to update install \"%s\" to %s: %v", i.NameHashKey, phase, err)
 }
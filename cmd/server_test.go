@@ -0,0 +1,342 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestGateway returns the gateway for the cluster "prod1" backed by the new memory store prefixed with `name`.
+// It has no kubernetes client, so installs must never reach brigade
+func newTestGateway(t *testing.T, name string) *gateway {
+	name = fmt.Sprintf("%s-%d", name, time.Now().UnixNano())
+	config := &api.Config{
+		Kind:     "Config",
+		Metadata: api.Metadata{Name: name},
+		Spec: api.ConfigSpec{
+			Backend: "memory://" + name,
+		},
+	}
+	for _, kind := range []string{"Deployment", "Release", "Install"} {
+		config.Spec.CustomResourceDefinitions = append(config.Spec.CustomResourceDefinitions, api.CustomResourceDefinition{
+			Kind:     "CustomResourceDefinition",
+			Metadata: api.Metadata{Name: strings.ToLower(kind)},
+			Spec: api.CustomResourceDefinitionSpec{
+				Names: api.CustomResourceDefinitionNames{Kind: kind},
+			},
+		})
+	}
+	db, logs, err := framework.NewStoreFromConfig(config, "production")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := &gateway{
+		targetedProjects: map[string]*api.Resource{"proj1": {}},
+		targetedApps:     map[string]*api.Resource{"app1": {}},
+		clusterName:      "prod1",
+		env:              "production",
+		logs:             logs,
+		db:               db,
+		installTimeout:   time.Hour,
+	}
+	g.mgr = framework.NewManager(db, framework.InformerOptions{})
+	return g
+}
+
+func newTestInstall(t *testing.T, g *gateway, name string, runningSince time.Time) *api.Resource {
+	i := &api.Resource{
+		NameHashKey: name,
+		Kind:        "Install",
+		Metadata:    api.Metadata{Name: name},
+		Spec: map[string]interface{}{
+			"project": "proj1",
+			"app":     "app1",
+			"cluster": "prod1",
+			"sha1":    "abc123",
+		},
+		Status: map[string]interface{}{
+			"phase": "running",
+		},
+	}
+	err := framework.SetCondition(i, api.Condition{Type: installRunning, Status: api.ConditionTrue, Reason: "ScriptSent", LastTransitionTime: runningSince})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := g.db.Apply(context.Background(), i); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return i
+}
+
+func TestGatewayReconcilesDeploymentIntoInstall(t *testing.T) {
+	g := newTestGateway(t, "gateway-test-reconcile")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := framework.ControllerOptions{Workers: 1}
+	if _, err := g.mgr.Register("deployment", framework.ReconcilerFunc(g.reconcileDeployment), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := g.mgr.Register("release", framework.ReconcilerFunc(g.reconcileRelease), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go g.mgr.Run(ctx)
+
+	deployments := []struct {
+		name    string
+		project string
+		app     string
+		release string
+		install string
+	}{
+		{name: "proj1-app1", project: "proj1", app: "app1", release: "proj1-app1-prod1", install: "proj1-app1-prod1-abc123"},
+		// Deployments of apps the gateway doesn't target are ignored
+		{name: "proj1-app2", project: "proj1", app: "app2"},
+	}
+
+	for _, tc := range deployments {
+		d := &api.Resource{
+			NameHashKey: tc.name,
+			Kind:        "Deployment",
+			Metadata: api.Metadata{
+				Name:   tc.name,
+				Labels: map[string]string{"team": "frontend"},
+			},
+			Spec: map[string]interface{}{
+				"project": tc.project,
+				"app":     tc.app,
+				"sha1":    "abc123",
+			},
+		}
+		if err := g.db.Apply(ctx, d); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for _, tc := range deployments {
+		if tc.install == "" {
+			continue
+		}
+		var rel *api.Resource
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			r, err := g.latest(ctx, "release", tc.release)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if r != nil && r.Status["install"] == tc.install {
+				rel = r
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: release \"%s\" hasn't recorded install \"%s\": %+v", tc.name, tc.release, tc.install, r)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if !rel.Observed() {
+			t.Errorf("%s: the release isn't observed: %+v", tc.name, rel.Metadata)
+		}
+
+		i, err := g.latest(ctx, "install", tc.install)
+		if err != nil || i == nil {
+			t.Fatalf("%s: install \"%s\" not found: %v", tc.name, tc.install, err)
+		}
+		if i.Status["phase"] != "pending" {
+			t.Errorf("%s: unexpected phase: %v", tc.name, i.Status["phase"])
+		}
+		if !framework.IsConditionTrue(i, installScheduled) {
+			t.Errorf("%s: the install isn't scheduled: %v", tc.name, i.Status)
+		}
+		// Labels are inherited from the deployment, so that selectors of gateways apply to releases and installs
+		if rel.Metadata.Labels["team"] != "frontend" || i.Metadata.Labels["team"] != "frontend" {
+			t.Errorf("%s: labels aren't inherited: release=%v, install=%v", tc.name, rel.Metadata.Labels, i.Metadata.Labels)
+		}
+	}
+
+	if r, err := g.latest(ctx, "release", "proj1-app2-prod1"); err != nil || r != nil {
+		t.Errorf("unexpected release for the app the gateway doesn't target: %+v: %v", r, err)
+	}
+}
+
+func TestGatewayHandlesRunningInstall(t *testing.T) {
+	g := newTestGateway(t, "gateway-test-running")
+	ctx := context.Background()
+
+	testcases := []struct {
+		name         string
+		runningSince time.Time
+		// withoutCondition is true for the install claimed by an older gateway
+		withoutCondition bool
+		requeued         bool
+		phase            string
+		reason           string
+	}{
+		{
+			name:         "running",
+			runningSince: time.Now().Add(-time.Minute),
+			requeued:     true,
+			phase:        "running",
+		},
+		{
+			name:             "without-condition",
+			withoutCondition: true,
+			requeued:         true,
+			phase:            "running",
+		},
+		{
+			name:         "timed-out",
+			runningSince: time.Now().Add(-2 * time.Hour),
+			phase:        "failed",
+			reason:       "Timeout",
+		},
+	}
+
+	for _, tc := range testcases {
+		i := newTestInstall(t, g, tc.name, tc.runningSince)
+		if tc.withoutCondition {
+			delete(i.Status, "conditions")
+			if err := g.db.UpdateStatus(ctx, i); err != nil {
+				t.Fatalf("%s: unexpected error: %v", tc.name, err)
+			}
+		}
+		result, err := g.handleInstall(ctx, i)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if requeued := result.RequeueAfter > 0 && result.RequeueAfter <= g.installTimeout; requeued != tc.requeued {
+			t.Errorf("%s: unexpected requeue: %v", tc.name, result.RequeueAfter)
+		}
+		latest, err := g.latest(ctx, "install", tc.name)
+		if err != nil || latest == nil {
+			t.Fatalf("%s: install not found: %v", tc.name, err)
+		}
+		if latest.Status["phase"] != tc.phase {
+			t.Errorf("%s: unexpected phase: expected=%s, actual=%v", tc.name, tc.phase, latest.Status["phase"])
+		}
+		// The install without the condition times out after it is observed
+		if tc.withoutCondition && !framework.IsConditionTrue(latest, installRunning) {
+			t.Errorf("%s: the install isn't observed running: %v", tc.name, latest.Status)
+		}
+		if tc.reason == "" {
+			continue
+		}
+		succeeded, err := framework.GetCondition(latest, installSucceeded)
+		if err != nil || succeeded == nil {
+			t.Fatalf("%s: no %s condition: %v", tc.name, installSucceeded, err)
+		}
+		if succeeded.Status != api.ConditionFalse || succeeded.Reason != tc.reason {
+			t.Errorf("%s: unexpected %s condition: %+v", tc.name, installSucceeded, succeeded)
+		}
+		if framework.IsConditionTrue(latest, installRunning) {
+			t.Errorf("%s: the install is still running: %v", tc.name, latest.Status)
+		}
+	}
+}
+
+func TestGatewayFinishesInstall(t *testing.T) {
+	g := newTestGateway(t, "gateway-test-finish")
+	ctx := context.Background()
+
+	testcases := []struct {
+		name string
+		// modify writes the install concurrently after the gateway has read it
+		modify   func(i *api.Resource)
+		expected string
+	}{
+		{
+			name:     "latest",
+			expected: "completed",
+		},
+		{
+			name: "conflicting",
+			modify: func(i *api.Resource) {
+				i.Status["note"] = "modified"
+			},
+			expected: "completed",
+		},
+		{
+			name: "finished by another",
+			modify: func(i *api.Resource) {
+				i.Status["phase"] = "failed"
+			},
+			expected: "failed",
+		},
+	}
+
+	for _, tc := range testcases {
+		i := newTestInstall(t, g, tc.name, time.Now())
+		if tc.modify != nil {
+			concurrent := i.DeepCopy()
+			tc.modify(concurrent)
+			if err := g.db.UpdateStatus(ctx, concurrent); err != nil {
+				t.Fatalf("%s: unexpected error: %v", tc.name, err)
+			}
+		}
+		succeeded := api.Condition{Type: installSucceeded, Status: api.ConditionTrue, Reason: "ScriptCompleted"}
+		if err := g.finishInstall(i, "completed", succeeded); err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		latest, err := g.latest(ctx, "install", tc.name)
+		if err != nil || latest == nil {
+			t.Fatalf("%s: install not found: %v", tc.name, err)
+		}
+		if latest.Status["phase"] != tc.expected {
+			t.Errorf("%s: unexpected phase: expected=%s, actual=%v", tc.name, tc.expected, latest.Status["phase"])
+		}
+		// The concurrent write is never lost
+		if tc.name == "conflicting" && latest.Status["note"] != "modified" {
+			t.Errorf("%s: the concurrent write is lost: %v", tc.name, latest.Status)
+		}
+	}
+}
+
+func TestGatewayHandlesLegacyInstall(t *testing.T) {
+	g := newTestGateway(t, "gateway-test-legacy")
+	ctx := context.Background()
+
+	// Installs written before the status subresource keep their phases in the spec, and have no status
+	testcases := []struct {
+		name     string
+		phase    string
+		requeued bool
+	}{
+		{name: "legacy-completed", phase: "completed"},
+		{name: "legacy-failed", phase: "failed"},
+		{name: "legacy-running", phase: "running", requeued: true},
+	}
+
+	for _, tc := range testcases {
+		i := &api.Resource{
+			NameHashKey: tc.name,
+			Kind:        "Install",
+			Metadata:    api.Metadata{Name: tc.name},
+			Spec: map[string]interface{}{
+				"project": "proj1",
+				"app":     "app1",
+				"cluster": "prod1",
+				"sha1":    "abc123",
+				"phase":   tc.phase,
+			},
+		}
+		if err := g.db.Apply(ctx, i); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if phase := installPhaseOf(i); phase != tc.phase {
+			t.Errorf("%s: unexpected phase: expected=%s, actual=%v", tc.name, tc.phase, phase)
+		}
+		result, err := g.handleInstall(ctx, i)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if requeued := result.RequeueAfter == g.installTimeout; requeued != tc.requeued {
+			t.Errorf("%s: unexpected requeue: %v", tc.name, result.RequeueAfter)
+		}
+	}
+}
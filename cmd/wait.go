@@ -15,7 +15,9 @@
 package cmd
 
 import (
-	"github.com/mumoshu/division/dynamodb"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
 	"github.com/spf13/cobra"
 	"time"
 )
@@ -23,6 +25,7 @@ import (
 type WaitOptions struct {
 	Logs    bool
 	Timeout time.Duration
+	For     string
 }
 
 var waitOpts WaitOptions
@@ -33,24 +36,67 @@ func init() {
 
 func NewCmdWait() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "wait RESOURCE NAME QUERY",
+		Use:   "wait RESOURCE NAME [QUERY]",
 		Short: "wait until the resource meets the criteria",
-		Args:  cobra.ExactArgs(3),
+		Long: `wait until the resource meets the criteria, given by either the jsonql QUERY or --for.
+
+Examples:
+  # Wait for the install to complete
+  div wait install foo "status.phase = 'completed'"
+
+  # Wait for the install to have the Succeeded condition with the status "True"
+  div wait install foo --for=condition=Succeeded
+
+  # Wait for the install to have the Succeeded condition with the status "False"
+  div wait install foo --for=condition=Succeeded=False`,
+		Args: cobra.RangeArgs(2, 3),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
+			ctx := interruptibleContext()
 
-			db, err := dynamodb.NewDB(globalOpts.Config, globalOpts.Namespace)
+			db, err := framework.NewStore(globalOpts.Config, globalOpts.Namespace)
 			if err != nil {
 				return err
 			}
 
-			return db.Wait(args[0], args[1], args[2], globalOpts.Output, waitOpts.Timeout, waitOpts.Logs)
+			var cond api.WaitCondition
+			switch {
+			case len(args) == 3 && waitOpts.For != "":
+				return fmt.Errorf("either QUERY or --for can be specified, but not both")
+			case len(args) == 3:
+				cond = framework.QueryCondition(args[2])
+			case waitOpts.For != "":
+				cond, err = framework.ParseWaitFor(waitOpts.For)
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("either QUERY or --for must be specified")
+			}
+
+			def, version, err := resolveResource(ctx, db, args[0])
+			if err != nil {
+				return err
+			}
+			if version != "" {
+				// Evaluate the condition against the resource at the version the query is written for
+				inner := cond
+				cond = func(r *api.Resource) (bool, error) {
+					converted, err := framework.ConvertResource(ctx, def, r, version)
+					if err != nil {
+						return false, err
+					}
+					return inner(converted)
+				}
+			}
+			return db.Wait(ctx, def.Metadata.Name, args[1], cond, globalOpts.Output, waitOpts.Timeout, waitOpts.Logs)
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.DurationVar(&waitOpts.Timeout, "timeout", 0, "Stop after a duration like 5s, 2m, or 3h. Defaults to 0s=forever.")
 	flags.BoolVar(&waitOpts.Logs, "logs", false, "Specify if the logs should be streamed.")
+	flags.StringVar(&waitOpts.For, "for", "", "Wait for the condition in status.conditions instead of QUERY, like condition=Ready or condition=Ready=False")
 
 	return cmd
 
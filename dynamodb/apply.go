@@ -1,6 +1,7 @@
 package dynamodb
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -8,6 +9,7 @@ import (
 	"github.com/mumoshu/division/api"
 	"github.com/mumoshu/division/framework"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -17,91 +19,136 @@ type PutItemOption struct {
 	File      string
 }
 
-func (p *dynamoResourceDB) ApplyFile(file string) error {
-	return p.applyFile(file)
+func (p *dynamoResourceDB) ApplyFile(ctx context.Context, file string) error {
+	return framework.ApplyFile(ctx, p, file, false)
 }
 
-func (p *dynamoResourceDB) applyFile(file string) error {
-	resource, err := framework.LoadResourceFromYamlFile(file)
+// resourceDefinitionForKind reloads resource definitions stored in the database when the kind is unknown,
+// so that a custom resource can be applied right after its definition is applied
+func (p *dynamoResourceDB) resourceDefinitionForKind(ctx context.Context, kind string) (*api.CustomResourceDefinition, error) {
+	reload := strings.HasPrefix(p.config.Spec.Source, "dynamodb://")
+	d, err := p.findResourceDefinition(ctx, func(d *api.CustomResourceDefinition) bool { return d.ResourceKind() == kind }, reload)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if d == nil {
+		return nil, fmt.Errorf("no resource definition found: kind=%s", kind)
 	}
-	return p.Apply(resource)
+	return d, nil
 }
 
-func (p *dynamoResourceDB) Apply(resource *api.Resource) error {
+func (p *dynamoResourceDB) Apply(ctx context.Context, resource *api.Resource) error {
 	resource.Metadata.CreationTimestamp = time.Now()
 	resource.Metadata.UpdateTimestamp = time.Now()
 
-	var resourceDef *api.CustomResourceDefinition
-	kind := resource.Kind
-	for _, r := range p.resourceDefs {
-		if r.ResourceKind() == kind {
-			resourceDef = &r
-			break
-		}
+	resourceDef, err := p.resourceDefinitionForKind(ctx, resource.Kind)
+	if err != nil {
+		return err
 	}
-	if resourceDef == nil {
-		return fmt.Errorf("no resource definition found in %v: name=%s kind=%s", p.resourceDefs, resource.NameHashKey, kind)
+	if err := framework.AdmitResource(ctx, resourceDef, resource); err != nil {
+		return err
 	}
-	var err error
 	existing := api.Resource{}
 	var getErr error
 	{
 		for {
-			getErr = p.namespacedTable(resourceDef).Get(HashKeyName, resource.Metadata.Name).One(&existing)
-			if getErr != nil {
-				if getErr, ok := err.(awserr.Error); ok {
-					switch getErr.Code() {
-					case dynamodb.ErrCodeResourceNotFoundException:
-						break
-					case dynamodb.ErrCodeProvisionedThroughputExceededException, dynamodb.ErrCodeInternalServerError, dynamodb.ErrCodeLimitExceededException:
-						fmt.Fprintf(os.Stderr, "retrying on error: %v\n", getErr)
-						time.Sleep(3 * time.Second)
-						continue
-					default:
-						return fmt.Errorf("[bug] get: unexpected error: %v", getErr)
+			getErr = p.tableFor(resourceDef).Get(HashKeyName, resource.Metadata.Name).OneWithContext(ctx, &existing)
+			if aerr, ok := getErr.(awserr.Error); ok {
+				switch aerr.Code() {
+				case dynamodb.ErrCodeResourceNotFoundException:
+				case dynamodb.ErrCodeProvisionedThroughputExceededException, dynamodb.ErrCodeInternalServerError, dynamodb.ErrCodeLimitExceededException:
+					fmt.Fprintf(os.Stderr, "retrying on error: %v\n", getErr)
+					if err := sleep(ctx, 3*time.Second); err != nil {
+						return err
 					}
-
+					continue
+				default:
+					return fmt.Errorf("[bug] get: unexpected error: %v", getErr)
 				}
+			} else if getErr != nil && getErr != dynamo.ErrNotFound {
+				return fmt.Errorf("[bug] get: unexpected error: %v", getErr)
 			}
 			break
 		}
 	}
+
+	// Conditionally put the resource, so that we won't overwrite a resource written by someone else after the above get.
+	// The resource is prepared on a copy, so that the caller's resource is left as is on conflicts and can be retried as is
+	prepared := resource.DeepCopy()
+	var put func() *dynamo.Put
 	if getErr == nil {
-		resource.Metadata.CreationTimestamp = existing.Metadata.CreationTimestamp
+		err = framework.PrepareWrite(prepared, &existing)
+		put = p.conditionalPut(resourceDef, prepared, &existing)
+	} else {
+		err = framework.PrepareWrite(prepared, nil)
+		put = p.conditionalPut(resourceDef, prepared, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := p.putCreatingTable(ctx, resourceDef, prepared, put); err != nil {
+		return err
 	}
-	err = p.namespacedTable(resourceDef).Put(resource).Run()
+	*resource = *prepared
+	if getErr == nil {
+		fmt.Printf("%s \"%s\" updated\n", resourceDef.Metadata.Name, resource.Metadata.Name)
+	} else {
+		fmt.Printf("%s \"%s\" created\n", resourceDef.Metadata.Name, resource.Metadata.Name)
+	}
+	return nil
+}
+
+// putCreatingTable runs the put, creating the table for the resource when it doesn't exist yet
+func (p *dynamoResourceDB) putCreatingTable(ctx context.Context, resourceDef *api.CustomResourceDefinition, resource *api.Resource, put func() *dynamo.Put) error {
+	err := put().RunWithContext(ctx)
 	if aerr, ok := err.(awserr.Error); ok {
 		switch aerr.Code() {
 		case dynamodb.ErrCodeResourceNotFoundException:
-			if err := p.db.CreateTable(p.tableNameForResourceNamed(resourceDef.Metadata.Name), resource).Stream(dynamo.NewImageView).Run(); err != nil {
+			if err := p.db.CreateTable(p.tableNameFor(resourceDef), resource).Stream(dynamo.NewAndOldImagesView).RunWithContext(ctx); err != nil {
 				return err
 			}
 			for {
-				err = p.namespacedTable(resourceDef).Put(resource).Run()
-				if err != nil {
-					if aerr, ok := err.(awserr.Error); ok {
-						switch aerr.Code() {
-						case dynamodb.ErrCodeResourceNotFoundException, dynamodb.ErrCodeResourceInUseException:
-							fmt.Fprintf(os.Stderr, "retrying on error: %v: table may be creating...\n", aerr.Error())
-							time.Sleep(5 * time.Second)
-							continue
+				err = put().RunWithContext(ctx)
+				if aerr, ok := err.(awserr.Error); ok {
+					switch aerr.Code() {
+					case dynamodb.ErrCodeResourceNotFoundException, dynamodb.ErrCodeResourceInUseException:
+						fmt.Fprintf(os.Stderr, "retrying on error: %v: table may be creating...\n", aerr.Error())
+						if err := sleep(ctx, 5*time.Second); err != nil {
+							return err
 						}
+						continue
 					}
-					return fmt.Errorf("unexpected error while waiting for table creation: %v", err)
 				}
 				break
 			}
 		}
 	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return api.NewErrConflict(fmt.Sprintf(`%s "%s" has been modified concurrently. get the latest and retry`, resourceDef.Metadata.Name, resource.Metadata.Name))
+	}
 	if err != nil {
 		return fmt.Errorf("unexpected error: %v", err)
 	}
-	if getErr == nil {
-		fmt.Printf("%s \"%s\" updated\n", resourceDef.Metadata.Name, resource.Metadata.Name)
-	} else {
-		fmt.Printf("%s \"%s\" created\n", resourceDef.Metadata.Name, resource.Metadata.Name)
-	}
 	return nil
 }
+
+// conditionalPut returns a function to build the put that succeeds only when the item is still the `existing` one.
+// `existing` is nil when the item is expected not to exist
+func (p *dynamoResourceDB) conditionalPut(resourceDef *api.CustomResourceDefinition, resource *api.Resource, existing *api.Resource) func() *dynamo.Put {
+	if existing == nil {
+		return func() *dynamo.Put {
+			return p.tableFor(resourceDef).Put(resource).If("attribute_not_exists($)", HashKeyName)
+		}
+	}
+	if existing.Metadata.ResourceVersion == 0 {
+		// The resource is written before resourceVersion is introduced
+		return func() *dynamo.Put {
+			return p.tableFor(resourceDef).Put(resource).If("attribute_not_exists('metadata'.'resourceVersion')")
+		}
+	}
+	version := existing.Metadata.ResourceVersion
+	return func() *dynamo.Put {
+		return p.tableFor(resourceDef).Put(resource).If("'metadata'.'resourceVersion' = ?", version)
+	}
+}
@@ -7,24 +7,44 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
-func New(debug bool) (*session.Session, error) {
+type Options struct {
+	Debug bool
+	// Profile is the name of the profile in the AWS shared config. Defaults to AWS_PROFILE or "default"
+	Profile string
+	// Region defaults to AWS_REGION or the region in the AWS shared config
+	Region string
+	// AssumeRoleARN is the ARN of the IAM role assumed with the credentials of the profile, if specified
+	AssumeRoleARN string
+}
+
+func New(opts Options) (*session.Session, error) {
 	awsConfig := aws.NewConfig().
 		WithCredentialsChainVerboseErrors(true)
 
-	if debug {
+	if opts.Region != "" {
+		awsConfig = awsConfig.WithRegion(opts.Region)
+	}
+
+	if opts.Debug {
 		awsConfig = awsConfig.WithLogLevel(aws.LogDebug)
 	}
 
-	session, err := newAwsSessionFromConfig(awsConfig)
+	session, err := newAwsSessionFromConfig(awsConfig, opts.Profile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to establish aws session: %v", err)
 	}
+
+	if opts.AssumeRoleARN != "" {
+		creds := stscreds.NewCredentials(session, opts.AssumeRoleARN)
+		session = session.Copy(aws.NewConfig().WithCredentials(creds))
+	}
 	return session, nil
 }
 
-func newAwsSessionFromConfig(config *aws.Config) (*session.Session, error) {
+func newAwsSessionFromConfig(config *aws.Config, profile string) (*session.Session, error) {
 	return session.NewSessionWithOptions(session.Options{
-		Config: *config,
+		Config:  *config,
+		Profile: profile,
 		// This seems to be required for AWS_SDK_LOAD_CONFIG
 		SharedConfigState: session.SharedConfigEnable,
 		// This seems to be required by MFA
@@ -0,0 +1,87 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	"github.com/mumoshu/division/dynamodb/stream"
+	"os"
+	"time"
+)
+
+// checkpointName is the name of the global table storing checkpoints of resumable watches
+const checkpointName = "checkpoint"
+
+type checkpointItem struct {
+	NameHashKey     string            `dynamo:"name_hash_key,hash"`
+	StreamArn       string            `dynamo:"streamArn"`
+	StartedAt       time.Time         `dynamo:"startedAt"`
+	SequenceNumbers map[string]string `dynamo:"sequenceNumbers"`
+}
+
+// checkpointKey is unique per watcher and resource table, so that a watcher is able to watch multiple resources
+func checkpointKey(name, table string) string {
+	return fmt.Sprintf("%s/%s", name, table)
+}
+
+func (p *dynamoResourceDB) checkpointTable() dynamo.Table {
+	return p.db.Table(p.globalTableName(checkpointName))
+}
+
+// loadCheckpoint returns nil when the watcher has never checkpointed the table
+func (p *dynamoResourceDB) loadCheckpoint(ctx context.Context, name, table string) (*stream.Checkpoint, error) {
+	item := checkpointItem{}
+	err := p.checkpointTable().Get(HashKeyName, checkpointKey(name, table)).OneWithContext(ctx, &item)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		return nil, nil
+	}
+	if err == dynamo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint \"%s\": %v", name, err)
+	}
+	if item.SequenceNumbers == nil {
+		item.SequenceNumbers = map[string]string{}
+	}
+	return &stream.Checkpoint{
+		StreamArn:       item.StreamArn,
+		StartedAt:       item.StartedAt,
+		SequenceNumbers: item.SequenceNumbers,
+	}, nil
+}
+
+func (p *dynamoResourceDB) saveCheckpoint(ctx context.Context, name, table string, checkpoint *stream.Checkpoint) error {
+	item := checkpointItem{
+		NameHashKey:     checkpointKey(name, table),
+		StreamArn:       checkpoint.StreamArn,
+		StartedAt:       checkpoint.StartedAt,
+		SequenceNumbers: checkpoint.SequenceNumbers,
+	}
+	err := p.checkpointTable().Put(item).RunWithContext(ctx)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		if err := p.db.CreateTable(p.globalTableName(checkpointName), checkpointItem{}).RunWithContext(ctx); err != nil {
+			return err
+		}
+		for {
+			err = p.checkpointTable().Put(item).RunWithContext(ctx)
+			if aerr, ok := err.(awserr.Error); ok {
+				switch aerr.Code() {
+				case dynamodb.ErrCodeResourceNotFoundException, dynamodb.ErrCodeResourceInUseException:
+					fmt.Fprintf(os.Stderr, "retrying on error: %v: table may be creating...\n", aerr.Error())
+					if err := sleep(ctx, 5*time.Second); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint \"%s\": %v", name, err)
+	}
+	return nil
+}
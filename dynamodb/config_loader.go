@@ -1,6 +1,7 @@
 package dynamodb
 
 import (
+	"context"
 	"fmt"
 	"github.com/mumoshu/division/api"
 	"github.com/mumoshu/division/framework"
@@ -8,13 +9,8 @@ import (
 
 const databasePrefix = "div-"
 
-const (
-	crdName = "customresourcedefinition"
-	crdKind = "CustomResourceDefinition"
-)
-
-func LoadConfigFromDynamoDB(table string, context *api.Config) (*api.Config, error) {
-	db, err := newDefaultDynamoDBClient()
+func LoadConfigFromDynamoDB(table string, config *api.Config) (*api.Config, error) {
+	db, err := newDefaultDynamoDBClient(config)
 	if err != nil {
 		return nil, err
 	}
@@ -22,16 +18,16 @@ func LoadConfigFromDynamoDB(table string, context *api.Config) (*api.Config, err
 		return nil, fmt.Errorf(`not implemented error: table "%s" is specified, but it is unsupported`, table)
 	}
 
-	dynamicRDs, err := getCRDs(db, context)
+	dynamicRDs, err := getCRDs(context.Background(), db, config)
 
 	rdOfDynamicRDs := api.CustomResourceDefinition{
-		Kind: crdKind,
+		Kind: api.CustomResourceDefinitionKind,
 		Metadata: api.Metadata{
-			Name: crdName,
+			Name: api.CustomResourceDefinitionResource,
 		},
 		Spec: api.CustomResourceDefinitionSpec{
 			Names: api.CustomResourceDefinitionNames{
-				Kind: crdKind,
+				Kind: api.CustomResourceDefinitionKind,
 			},
 		},
 	}
@@ -41,7 +37,7 @@ func LoadConfigFromDynamoDB(table string, context *api.Config) (*api.Config, err
 	rds = append(rds, dynamicRDs...)
 	return &api.Config{
 		Metadata: api.Metadata{
-			Name: context.Metadata.Name,
+			Name: config.Metadata.Name,
 		},
 		Spec: api.ConfigSpec{
 			CustomResourceDefinitions: rds,
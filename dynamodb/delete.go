@@ -1,13 +1,18 @@
 package dynamodb
 
 import (
+	"context"
 	"fmt"
 	"github.com/guregu/dynamo"
 	"github.com/mumoshu/division/api"
 )
 
-func (p *dynamoResourceDB) Delete(resource string, name string) error {
-	err := p.tableForResourceNamed(resource).Delete(HashKeyName, partitionKey(name)).OldValue(&api.Resource{})
+func (p *dynamoResourceDB) Delete(ctx context.Context, resource string, name string) error {
+	table, err := p.tableForResourceNamed(ctx, resource)
+	if err != nil {
+		return err
+	}
+	err = table.Delete(HashKeyName, partitionKey(name)).OldValueWithContext(ctx, &api.Resource{})
 	if err != nil {
 		// Small trick to make the error message a bit nicer
 		//
@@ -1,33 +1,21 @@
 package dynamodb
 
 import (
+	"context"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
 	"github.com/guregu/dynamo"
 	"github.com/mumoshu/division/api"
-	"github.com/mumoshu/division/dynamodb/awssession"
 	"github.com/mumoshu/division/dynamodb/stream"
 	"github.com/mumoshu/division/framework"
-	"os"
+	"sync"
 	"time"
 )
 
 const HashKeyName = "name_hash_key"
 
-type Store interface {
-	GetPrint(resource, name string, selectors []string, output string, watch bool) error
-	GetAsync(resource, name string, selectors []string, watch bool) (<-chan *api.Resource, <-chan error)
-	GetSync(resource, name string, selectors []string) ([]*api.Resource, error)
-	GetCRDs() ([]api.CustomResourceDefinition, error)
-	Wait(resource, name, query, output string, timeout time.Duration, logs bool) error
-	ApplyFile(file string) error
-	Apply(resource *api.Resource) error
-	Delete(resource, name string) error
-}
-
 type dynamoResourceDB struct {
 	databaseName string
 	db           *dynamo.DB
@@ -36,29 +24,83 @@ type dynamoResourceDB struct {
 	session      *session.Session
 	namespace    string
 	resourceDefs []api.CustomResourceDefinition
+	// resourceDefsMutex guards resourceDefs, that grows as definitions are read from the database
+	resourceDefsMutex sync.Mutex
 }
 
 func (p *dynamoResourceDB) tablePrefix() string {
 	return fmt.Sprintf("%s%s", databasePrefix, p.databaseName)
 }
 
-func (p *dynamoResourceDB) tableNameForResourceNamed(resource string) string {
-	if resource == crdName {
-		return p.globalTableName(resource)
+// tableNameForResourceNamed returns the name of the table for the resource, that is shared among namespaces
+// for resource definitions and cluster-scoped resources.
+// Definitions are read from the config and the table of definitions, like the ones of other backends
+func (p *dynamoResourceDB) tableNameForResourceNamed(ctx context.Context, resource string) (string, error) {
+	if resource == api.CustomResourceDefinitionResource {
+		return p.globalTableName(resource), nil
+	}
+	// Leases are always namespaced
+	if resource != api.LeaseResource {
+		d, err := p.findResourceDefinition(ctx, func(d *api.CustomResourceDefinition) bool { return d.Metadata.Name == resource }, true)
+		if err != nil {
+			return "", err
+		}
+		if d != nil {
+			return p.tableNameFor(d), nil
+		}
+	}
+	return p.namespacedTableName(resource), nil
+}
+
+// findResourceDefinition returns the definition matching the predicate, or nil if none matches.
+// Definitions found in the database are cached like the ones in the config, so that the table of definitions
+// is scanned only when the definition is unknown to this process
+func (p *dynamoResourceDB) findResourceDefinition(ctx context.Context, match func(d *api.CustomResourceDefinition) bool, reload bool) (*api.CustomResourceDefinition, error) {
+	p.resourceDefsMutex.Lock()
+	defer p.resourceDefsMutex.Unlock()
+	for i := range p.resourceDefs {
+		if match(&p.resourceDefs[i]) {
+			d := p.resourceDefs[i]
+			return &d, nil
+		}
+	}
+	if !reload {
+		return nil, nil
 	}
-	return p.namespacedTableName(resource)
+	crds, err := p.GetCRDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range crds {
+		if match(&crds[i]) {
+			p.resourceDefs = append(p.resourceDefs, crds[i])
+			return &crds[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *dynamoResourceDB) tableNameFor(resourceDef *api.CustomResourceDefinition) string {
+	if resourceDef.Metadata.Name == api.CustomResourceDefinitionResource || resourceDef.Spec.ClusterScoped() {
+		return p.globalTableName(resourceDef.Metadata.Name)
+	}
+	return p.namespacedTableName(resourceDef.Metadata.Name)
 }
 
 func (p *dynamoResourceDB) namespacedTableName(resource string) string {
 	return fmt.Sprintf("%s-%s-%s", p.tablePrefix(), p.namespace, resource)
 }
 
-func (p *dynamoResourceDB) tableForResourceNamed(resourceName string) dynamo.Table {
-	return p.db.Table(p.tableNameForResourceNamed(resourceName))
+func (p *dynamoResourceDB) tableForResourceNamed(ctx context.Context, resourceName string) (dynamo.Table, error) {
+	table, err := p.tableNameForResourceNamed(ctx, resourceName)
+	if err != nil {
+		return dynamo.Table{}, err
+	}
+	return p.db.Table(table), nil
 }
 
-func (p *dynamoResourceDB) namespacedTable(resource *api.CustomResourceDefinition) dynamo.Table {
-	return p.tableForResourceNamed(resource.Metadata.Name)
+func (p *dynamoResourceDB) tableFor(resourceDef *api.CustomResourceDefinition) dynamo.Table {
+	return p.db.Table(p.tableNameFor(resourceDef))
 }
 
 func (p *dynamoResourceDB) globalTableName(resource string) string {
@@ -77,56 +119,72 @@ func partitionKey(name string) string {
 	return name
 }
 
-func newDefaultDynamoDBClient() (*dynamo.DB, error) {
-	sess, err := awssession.New(os.Getenv("AWSDEBUG") != "")
-	if err != nil {
-		return nil, err
-	}
-	return dynamo.New(sess), nil
-}
-
-func newDynamoDBClient() (*dynamo.DB, error) {
-	sess, err := awssession.New(os.Getenv("AWSDEBUG") != "")
+func newDefaultDynamoDBClient(config *api.Config) (*dynamo.DB, error) {
+	sess, err := newSession(config, "")
 	if err != nil {
 		return nil, err
 	}
-	return dynamo.New(sess), nil
+	return dynamo.New(sess, dynamoDBConfig(config)), nil
 }
 
 func (p *dynamoResourceDB) streamSubscriberForTable(table string) (*stream.StreamSubscriber, error) {
-	cfg := aws.NewConfig()
-	streamSvc := dynamodbstreams.New(p.session, cfg)
-	dynamoSvc := dynamodb.New(p.session, cfg)
+	streamSvc := dynamodbstreams.New(p.session, dynamoDBStreamsConfig(p.config))
+	dynamoSvc := dynamodb.New(p.session, dynamoDBConfig(p.config))
 	return stream.NewStreamSubscriber(dynamoSvc, streamSvc, table), nil
 }
 
-func (p *dynamoResourceDB) streamForTable(table string) (<-chan *dynamodbstreams.Record, <-chan error, error) {
+// streamForTable starts reading the stream of the table, right after the checkpoint if any.
+// The ARN of the stream is returned so that the caller is able to checkpoint records
+func (p *dynamoResourceDB) streamForTable(ctx context.Context, table string, checkpoint *stream.Checkpoint) (<-chan *stream.ShardRecord, <-chan error, string, error) {
 	subscriber, err := p.streamSubscriberForTable(table)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
+	}
+	subscriber.SetCheckpoint(checkpoint)
+	ch, errch, err := subscriber.GetStreamDataAsync(ctx)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return ch, errch, subscriber.StreamArn(), nil
+}
+
+func (p *dynamoResourceDB) streamForResourceNamed(ctx context.Context, resourceName string, checkpoint *stream.Checkpoint) (<-chan *stream.ShardRecord, <-chan error, string, error) {
+	table, err := p.tableNameForResourceNamed(ctx, resourceName)
+	if err != nil {
+		return nil, nil, "", err
 	}
-	ch, errch := subscriber.GetStreamDataAsync()
-	return ch, errch, nil
+	return p.streamForTable(ctx, table, checkpoint)
 }
 
-func (p *dynamoResourceDB) streamForResourceNamed(resourceName string) (<-chan *dynamodbstreams.Record, <-chan error, error) {
-	return p.streamForTable(p.tableNameForResourceNamed(resourceName))
+// sleep waits for the duration before retrying, unless the context is canceled in the meantime
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func NewDB(configFile string, namespace string) (Store, error) {
+func NewDB(configFile string, namespace string) (api.Store, error) {
 	config, err := framework.LoadConfigFromYamlFile(configFile)
 	if err != nil {
 		return nil, err
 	}
-	sess, err := awssession.New(os.Getenv("AWSDEBUG") != "")
+	db, _, err := newDB(config, namespace)
+	return db, err
+}
+
+func newDB(config *api.Config, namespace string) (*dynamoResourceDB, *LogStore, error) {
+	sess, err := newSession(config, namespace)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	db := dynamo.New(sess)
+	db := dynamo.New(sess, dynamoDBConfig(config))
 
 	logs, err := newLogs(config, namespace, sess)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	//fmt.Fprintf(os.Stderr, "%+v\n", config)
 	return &dynamoResourceDB{
@@ -137,5 +195,16 @@ func NewDB(configFile string, namespace string) (Store, error) {
 		session:      sess,
 		namespace:    namespace,
 		resourceDefs: config.Spec.CustomResourceDefinitions,
-	}, nil
+	}, logs, nil
+}
+
+func newStore(table string, config *api.Config, namespace string) (api.Store, api.LogStore, error) {
+	if table != "" {
+		return nil, nil, fmt.Errorf(`not implemented error: table "%s" is specified, but it is unsupported`, table)
+	}
+	return newDB(config, namespace)
+}
+
+func init() {
+	framework.StoreProviders.Register("dynamodb", newStore)
 }
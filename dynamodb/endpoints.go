@@ -0,0 +1,41 @@
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/mumoshu/division/api"
+	"os"
+)
+
+const (
+	dynamoDBEndpointEnv        = "DIV_DYNAMODB_ENDPOINT"
+	dynamoDBStreamsEndpointEnv = "DIV_DYNAMODBSTREAMS_ENDPOINT"
+	cloudWatchLogsEndpointEnv  = "DIV_CLOUDWATCHLOGS_ENDPOINT"
+)
+
+func endpointFor(env, configured string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return configured
+}
+
+// serviceConfig returns the config for a client of an AWS service, that points to the endpoint if specified
+func serviceConfig(endpoint string) *aws.Config {
+	cfg := aws.NewConfig()
+	if endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint)
+	}
+	return cfg
+}
+
+func dynamoDBConfig(config *api.Config) *aws.Config {
+	return serviceConfig(endpointFor(dynamoDBEndpointEnv, config.Spec.AWS.Endpoints.DynamoDB))
+}
+
+func dynamoDBStreamsConfig(config *api.Config) *aws.Config {
+	return serviceConfig(endpointFor(dynamoDBStreamsEndpointEnv, config.Spec.AWS.Endpoints.DynamoDBStreams))
+}
+
+func cloudWatchLogsConfig(config *api.Config) *aws.Config {
+	return serviceConfig(endpointFor(cloudWatchLogsEndpointEnv, config.Spec.AWS.Endpoints.CloudWatchLogs))
+}
@@ -1,37 +1,46 @@
 package dynamodb
 
 import (
+	"context"
 	"strings"
 
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
 	"github.com/guregu/dynamo"
 	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/dynamodb/stream"
 	"github.com/mumoshu/division/framework"
 	"os"
-	"os/signal"
+	"sync"
 	"time"
 )
 
-func (p *dynamoResourceDB) GetPrint(resource, name string, selectors []string, output string, watch bool) error {
+func (p *dynamoResourceDB) GetPrint(ctx context.Context, resource, name string, selectors []string, output string, watch bool) error {
+	if watch {
+		evCh, errCh := p.Watch(ctx, resource, name, selectors, api.WatchOptions{})
+
+		return framework.PrintWatchEventsSync(ctx, evCh, errCh, output)
+	}
+
 	var resCh <-chan *api.Resource
 	var errCh <-chan error
 
-	resCh, errCh = p.GetAsync(resource, name, selectors, watch)
+	resCh, errCh = p.GetAsync(ctx, resource, name, selectors, false)
 
-	return p.printStreamedResourcesSync(resCh, errCh, output, watch)
+	return framework.PrintStreamedResourcesSync(ctx, resCh, errCh, output, false)
 }
 
-func (p *dynamoResourceDB) GetCRDs() ([]api.CustomResourceDefinition, error) {
-	return getCRDs(p.db, p.config)
+func (p *dynamoResourceDB) GetCRDs(ctx context.Context) ([]api.CustomResourceDefinition, error) {
+	return getCRDs(ctx, p.db, p.config)
 }
 
-func getCRDs(db *dynamo.DB, context *api.Config) ([]api.CustomResourceDefinition, error) {
+func getCRDs(ctx context.Context, db *dynamo.DB, config *api.Config) ([]api.CustomResourceDefinition, error) {
 	crds := []api.CustomResourceDefinition{}
 	for {
-		err := db.Table(globalTableName(context.Metadata.Name, crdName)).Scan().All(&crds)
+		err := db.Table(globalTableName(config.Metadata.Name, api.CustomResourceDefinitionResource)).Scan().AllWithContext(ctx, &crds)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "err: %v\n", err.Error())
 			if aerr, ok := err.(awserr.Error); ok {
@@ -40,8 +49,12 @@ func getCRDs(db *dynamo.DB, context *api.Config) ([]api.CustomResourceDefinition
 				case dynamodb.ErrCodeResourceNotFoundException:
 				case dynamodb.ErrCodeProvisionedThroughputExceededException, dynamodb.ErrCodeLimitExceededException:
 					fmt.Fprintf(os.Stderr, "retrying in 3 secounds: %v\n", err)
-					time.Sleep(3 * time.Second)
+					if err := sleep(ctx, 3*time.Second); err != nil {
+						return nil, err
+					}
 					continue
+				default:
+					return nil, err
 				}
 			} else {
 				return nil, fmt.Errorf("unexpected error: %v", err)
@@ -52,60 +65,59 @@ func getCRDs(db *dynamo.DB, context *api.Config) ([]api.CustomResourceDefinition
 	return crds, nil
 }
 
-type ErrResourceNotFound struct {
-	msg string
-}
-
-func (e *ErrResourceNotFound) Error() string {
-	return e.msg
-}
-
-func (p *dynamoResourceDB) get(resource, name string, selectors []string) (api.Resources, error) {
-	var err error
+func (p *dynamoResourceDB) get(ctx context.Context, resource, name string, selectors []string) (api.Resources, error) {
+	selector, err := api.ParseSelectors(selectors)
+	if err != nil {
+		return nil, err
+	}
+	table, err := p.tableNameForResourceNamed(ctx, resource)
+	if err != nil {
+		return nil, err
+	}
 	resources := api.Resources{}
 	if name != "" {
-		if len(selectors) > 0 {
-			expr, args := exprAndArgs(selectors)
-			err = p.tableForResourceNamed(resource).Get(HashKeyName, name).Filter(expr, args...).All(&resources)
+		if len(selector) > 0 {
+			expr, args := exprAndArgs(selector)
+			err = p.db.Table(table).Get(HashKeyName, name).Filter(expr, args...).AllWithContext(ctx, &resources)
 		} else {
 			// Otherwise we getWatch this:
 			//   Error: ValidationException: Invalid FilterExpression: The expression can not be empty;
 			//   status code: 400, request id: VMUUJ9O65UABHUM12TQNFVH2SBVV4KQNSO5AEMVJF66Q9ASUAAJG
-			err = p.tableForResourceNamed(resource).Get(HashKeyName, name).All(&resources)
+			err = p.db.Table(table).Get(HashKeyName, name).AllWithContext(ctx, &resources)
 		}
 	} else {
-		if len(selectors) > 0 {
-			expr, args := exprAndArgs(selectors)
-			err = p.tableForResourceNamed(resource).Scan().Filter(expr, args...).All(&resources)
+		if len(selector) > 0 {
+			expr, args := exprAndArgs(selector)
+			err = p.db.Table(table).Scan().Filter(expr, args...).AllWithContext(ctx, &resources)
 		} else {
-			err = p.tableForResourceNamed(resource).Scan().All(&resources)
+			err = p.db.Table(table).Scan().AllWithContext(ctx, &resources)
 		}
 	}
 	if err == nil && len(resources) == 0 {
 		var msg string
 		if name != "" {
-			msg = fmt.Sprintf(`%s "%s" not found: dynamodb table named "%s" exists, but no item named "%s" found`, resource, name, p.tableNameForResourceNamed(resource), name)
-			return nil, &ErrResourceNotFound{msg}
+			msg = fmt.Sprintf(`%s "%s" not found: dynamodb table named "%s" exists, but no item named "%s" found`, resource, name, table, name)
+			return nil, api.NewErrResourceNotFound(msg)
 		} else {
-			msg = fmt.Sprintf(`no %s found: dynamodb table named "%s" exists, but no item named "%s" found`, resource, p.tableNameForResourceNamed(resource), name)
+			msg = fmt.Sprintf(`no %s found: dynamodb table named "%s" exists, but no item named "%s" found`, resource, table, name)
 			fmt.Fprintf(os.Stderr, msg)
 		}
 	} else if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
 		var msg string
 		if name != "" {
-			msg = fmt.Sprintf(`%s "%s" not found: no dynamodb table named "%s" exists. create it by "div apply -f your-new-%s.%s.yaml"`, resource, name, p.tableNameForResourceNamed(resource), resource, resource)
-			return nil, &ErrResourceNotFound{msg}
+			msg = fmt.Sprintf(`%s "%s" not found: no dynamodb table named "%s" exists. create it by "div apply -f your-new-%s.%s.yaml"`, resource, name, table, resource, resource)
+			return nil, api.NewErrResourceNotFound(msg)
 		} else {
-			msg = fmt.Sprintf(`no %s found: no dynamodb table named "%s" exists. create it by "div apply -f your-new-%s.%s.yaml"`, resource, p.tableNameForResourceNamed(resource), resource, resource)
+			msg = fmt.Sprintf(`no %s found: no dynamodb table named "%s" exists. create it by "div apply -f your-new-%s.%s.yaml"`, resource, table, resource, resource)
 			fmt.Fprintf(os.Stderr, msg)
 		}
 	}
 	return resources, nil
 }
 
-func (p *dynamoResourceDB) GetSync(resource, name string, selectors []string) ([]*api.Resource, error) {
+func (p *dynamoResourceDB) GetSync(ctx context.Context, resource, name string, selectors []string) ([]*api.Resource, error) {
 	rs := []*api.Resource{}
-	resources, errs := p.GetAsync(resource, name, selectors, false)
+	resources, errs := p.GetAsync(ctx, resource, name, selectors, false)
 	var err error
 	for resources != nil || errs != nil {
 		select {
@@ -126,7 +138,13 @@ func (p *dynamoResourceDB) GetSync(resource, name string, selectors []string) ([
 	return rs, err
 }
 
-func (p *dynamoResourceDB) GetAsync(resource, name string, selectors []string, watch bool) (<-chan *api.Resource, <-chan error) {
+func (p *dynamoResourceDB) GetAsync(ctx context.Context, resource, name string, selectors []string, watch bool) (<-chan *api.Resource, <-chan error) {
+	if watch {
+		evCh, errCh := p.Watch(ctx, resource, name, selectors, api.WatchOptions{})
+
+		return framework.WatchedResources(ctx, evCh, errCh)
+	}
+
 	resCh := make(chan *api.Resource)
 	aggErrCh := make(chan error)
 
@@ -134,132 +152,300 @@ func (p *dynamoResourceDB) GetAsync(resource, name string, selectors []string, w
 		defer close(resCh)
 		defer close(aggErrCh)
 
-		resources, err := p.get(resource, name, selectors)
+		resources, err := p.get(ctx, resource, name, selectors)
 		if err != nil {
-			aggErrCh <- err
+			select {
+			case aggErrCh <- err:
+			case <-ctx.Done():
+			}
 			return
 		}
 
-		if resources != nil {
+		for _, r := range resources {
+			var r2 api.Resource
+			r2 = r
+			select {
+			case resCh <- &r2:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resCh, aggErrCh
+}
+
+func (p *dynamoResourceDB) Watch(ctx context.Context, resource, name string, selectors []string, opts api.WatchOptions) (<-chan *api.WatchEvent, <-chan error) {
+	evCh := make(chan *api.WatchEvent)
+	aggErrCh := make(chan error)
+
+	go func() {
+		defer close(evCh)
+		defer close(aggErrCh)
+
+		sendErr := func(err error) {
+			select {
+			case aggErrCh <- err:
+			case <-ctx.Done():
+			}
+		}
+		send := func(ev *api.WatchEvent) bool {
+			select {
+			case evCh <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		table, err := p.tableNameForResourceNamed(ctx, resource)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		var checkpoint *stream.Checkpoint
+		if opts.Checkpoint != "" {
+			c, err := p.loadCheckpoint(ctx, opts.Checkpoint, table)
+			if err != nil {
+				sendErr(err)
+				return
+			}
+			checkpoint = c
+		}
+
+		// Start streaming before listing so that we won't miss changes made in between
+		startedAt := time.Now()
+		ch, errCh, streamArn, err := p.streamedEvents(ctx, resource, name, selectors, checkpoint)
+		if err == stream.ErrCheckpointExpired {
+			fmt.Fprintf(os.Stderr, "checkpoint \"%s\" for %s has expired. relisting...\n", opts.Checkpoint, resource)
+			checkpoint = nil
+			ch, errCh, streamArn, err = p.streamedEvents(ctx, resource, name, selectors, nil)
+		}
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		if checkpoint == nil {
+			resources, err := p.get(ctx, resource, name, selectors)
+			if err != nil {
+				sendErr(err)
+				return
+			}
+
 			for _, r := range resources {
 				var r2 api.Resource
 				r2 = r
-				resCh <- &r2
+				if !send(&api.WatchEvent{Type: api.Added, Object: &r2}) {
+					return
+				}
 			}
+
+			if opts.Checkpoint != "" {
+				checkpoint = &stream.Checkpoint{
+					StreamArn:       streamArn,
+					StartedAt:       startedAt,
+					SequenceNumbers: map[string]string{},
+				}
+				if err := p.saveCheckpoint(ctx, opts.Checkpoint, table, checkpoint); err != nil {
+					sendErr(err)
+					return
+				}
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "resuming %s changes from checkpoint \"%s\"\n", resource, opts.Checkpoint)
 		}
 
-		if watch {
-			ch, errCh := p.streamedResources(resource, name, selectors)
-			for ch != nil || errCh != nil {
-				select {
-				case resource, ok := <-ch:
-					if !ok {
-						ch = nil
-					}
-					if resource != nil {
-						resCh <- resource
+		modified := false
+		for ch != nil || errCh != nil {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					ch = nil
+					continue
+				}
+				if e.event != nil {
+					// The checkpoint is a best-effort hint: we checkpoint events delivered before this one, without knowing whether the consumer has processed them.
+					// Events in flight when the watcher stops may be lost, and watchers like informers catch up on them by relisting
+					if modified {
+						if err := p.saveCheckpoint(ctx, opts.Checkpoint, table, checkpoint); err != nil {
+							sendErr(err)
+							return
+						}
+						modified = false
 					}
-				case err := <-errCh:
-					if err != nil {
-						aggErrCh <- err
+					if !send(e.event) {
+						return
 					}
-					errCh = nil
 				}
+				if checkpoint != nil {
+					checkpoint.SequenceNumbers[e.shardId] = e.sequenceNumber
+					modified = true
+				}
+			case err := <-errCh:
+				if err == stream.ErrCheckpointExpired {
+					// Changes have been trimmed from the stream before being read. The watcher must relist, like informers do on errors
+					err = fmt.Errorf("%s changes have been trimmed from the stream before being read. relist and watch again: %v", resource, err)
+				}
+				if err != nil {
+					sendErr(err)
+				}
+				errCh = nil
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
-	return resCh, aggErrCh
+	return evCh, aggErrCh
 }
 
-func (p *dynamoResourceDB) printStreamedResourcesSync(ch <-chan *api.Resource, errCh <-chan error, output string, wait bool) error {
-	go func(ch <-chan *api.Resource) {
-		rs := []api.Resource{}
-		if wait {
-			for resource := range ch {
-				rs = append(rs, *resource)
-			}
-			framework.WriteToStdout(api.Resources(rs).Format(output))
-		} else {
-			for resource := range ch {
-				framework.WriteToStdout(resource.Format(output))
-			}
-		}
-	}(ch)
+// unmarshalImage returns nil when the image isn't included in the record
+func unmarshalImage(image map[string]*dynamodb.AttributeValue) (*api.Resource, error) {
+	if image == nil {
+		return nil, nil
+	}
+	resource := &api.Resource{}
+	if err := dynamo.UnmarshalItem(image, resource); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
 
-	return waitForInterruptionOrError(errCh)
+// streamedEvent is the event for a record, that is nil when the record is invisible to the watcher.
+// The shard ID and the sequence number of the record are used to checkpoint it
+type streamedEvent struct {
+	event          *api.WatchEvent
+	shardId        string
+	sequenceNumber string
 }
 
-func (p *dynamoResourceDB) streamedResources(resource, name string, selectors []string) (<-chan *api.Resource, <-chan error) {
-	resCh := make(chan *api.Resource, 1)
+func (p *dynamoResourceDB) streamedEvents(ctx context.Context, resource, name string, selectors []string, checkpoint *stream.Checkpoint) (<-chan *streamedEvent, <-chan error, string, error) {
+	evCh := make(chan *streamedEvent, 1)
 	aggErrCh := make(chan error, 1)
 
+	selector, err := api.ParseSelectors(selectors)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
 	fmt.Fprintf(os.Stderr, "starting to stream %s changes\n", resource)
-	ch, errCh, err := p.streamForResourceNamed(resource)
+	ch, errCh, streamArn, err := p.streamForResourceNamed(ctx, resource, checkpoint)
 	if err != nil {
-		aggErrCh <- err
-		return resCh, aggErrCh
+		return nil, nil, "", err
 	}
 	fmt.Fprintf(os.Stderr, "started streaming %s changes\n", resource)
 
-	go func(ch <-chan *dynamodbstreams.Record) {
+	sendErr := func(err error) {
+		select {
+		case aggErrCh <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func(ch <-chan *stream.ShardRecord) {
+		defer wg.Done()
+		defer close(evCh)
 		for record := range ch {
-			resource := &api.Resource{}
-			if err := dynamo.UnmarshalItem(record.Dynamodb.NewImage, &resource); err != nil {
-				aggErrCh <- err
+			e := &streamedEvent{
+				shardId:        record.ShardId,
+				sequenceNumber: aws.StringValue(record.Dynamodb.SequenceNumber),
+			}
+			oldObj, err := unmarshalImage(record.Dynamodb.OldImage)
+			if err != nil {
+				sendErr(err)
+				continue
+			}
+			newObj, err := unmarshalImage(record.Dynamodb.NewImage)
+			if err != nil {
+				sendErr(err)
+				continue
+			}
+			eventName := aws.StringValue(record.EventName)
+			if eventName == dynamodbstreams.OperationTypeRemove && oldObj == nil {
+				// The stream of the table created before NEW_AND_OLD_IMAGES is introduced doesn't tell the deleted item
+				oldObj = &api.Resource{}
+				if err := dynamo.UnmarshalItem(record.Dynamodb.Keys, oldObj); err != nil {
+					sendErr(err)
+					continue
+				}
+				oldObj.Metadata.Name = oldObj.NameHashKey
+			}
+			var key string
+			if newObj != nil {
+				key = newObj.NameHashKey
+			} else if oldObj != nil {
+				key = oldObj.NameHashKey
+			}
+			if name == "" || name == key {
+				e.event = framework.NewWatchEvent(oldObj, newObj, selector)
+			}
+			if e.event != nil && e.event.Type == api.Added && eventName == dynamodbstreams.OperationTypeModify {
+				// Same as above. The old image is missing, but the item is known to have been modified
+				e.event.Type = api.Modified
 			}
-			if name == "" || name == resource.NameHashKey {
-				resCh <- resource
+			select {
+			case evCh <- e:
+			case <-ctx.Done():
 			}
 		}
 	}(ch)
 
 	go func(errCh <-chan error) {
+		defer wg.Done()
 		for err := range errCh {
-			aggErrCh <- err
+			sendErr(err)
 		}
 	}(errCh)
 
-	return resCh, aggErrCh
-}
+	go func() {
+		wg.Wait()
+		close(aggErrCh)
+	}()
 
-func waitForInterruptionOrError(errCh <-chan error) error {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	for {
-		select {
-		case <-c:
-			fmt.Fprintln(os.Stderr, "interrupted")
-			return nil
-		case err, ok := <-errCh:
-			if ok {
-				return fmt.Errorf("stream error: %v", err)
-			}
-			return nil
-		default:
-			time.Sleep(100 * time.Millisecond)
-		}
-	}
-	return nil
+	return evCh, aggErrCh, streamArn, nil
 }
 
-func exprAndArgs(selectors []string) (string, []interface{}) {
+// exprAndArgs translates the selector to a filter expression.
+// Like Kubernetes, `!=` and `notin` match items without the label
+func exprAndArgs(selector api.Selector) (string, []interface{}) {
 	conds := []string{}
 	args := []interface{}{}
-	for _, selector := range selectors {
-		kv := strings.Split(selector, "=")
-		var op string
-		k := kv[0]
-		v := kv[1]
-		if strings.HasSuffix(k, "!") {
-			k = k[:len(k)-1]
-			op = "!="
-		} else {
-			op = "="
+	label := "'metadata'.'labels'.$"
+	for _, r := range selector {
+		placeholders := make([]string, len(r.Values))
+		for i := range r.Values {
+			placeholders[i] = "?"
+		}
+		values := []interface{}{}
+		for _, v := range r.Values {
+			values = append(values, v)
+		}
+		switch r.Operator {
+		case api.Equals, api.DoubleEquals:
+			conds = append(conds, fmt.Sprintf("%s = ?", label))
+			args = append(append(args, r.Key), values...)
+		case api.NotEquals:
+			conds = append(conds, fmt.Sprintf("(attribute_not_exists(%s) OR %s <> ?)", label, label))
+			args = append(append(args, r.Key, r.Key), values...)
+		case api.In:
+			conds = append(conds, fmt.Sprintf("%s IN (%s)", label, strings.Join(placeholders, ", ")))
+			args = append(append(args, r.Key), values...)
+		case api.NotIn:
+			conds = append(conds, fmt.Sprintf("(attribute_not_exists(%s) OR NOT (%s IN (%s)))", label, label, strings.Join(placeholders, ", ")))
+			args = append(append(args, r.Key, r.Key), values...)
+		case api.Exists:
+			conds = append(conds, fmt.Sprintf("attribute_exists(%s)", label))
+			args = append(args, r.Key)
+		case api.DoesNotExist:
+			conds = append(conds, fmt.Sprintf("attribute_not_exists(%s)", label))
+			args = append(args, r.Key)
 		}
-		args = append(args, k, v)
-		conds = append(conds, fmt.Sprintf("'metadata'.'labels'.$ %s ?", op))
 	}
-	expr := strings.Join(conds, "AND")
+	expr := strings.Join(conds, " AND ")
 	return expr, args
 }
@@ -0,0 +1,74 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"time"
+)
+
+// leaseDefinition is the built-in definition of leases, that are stored in a table per namespace like custom resources
+var leaseDefinition = api.CustomResourceDefinition{
+	Kind: api.CustomResourceDefinitionKind,
+	Metadata: api.Metadata{
+		Name: api.LeaseResource,
+	},
+	Spec: api.CustomResourceDefinitionSpec{
+		Names: api.CustomResourceDefinitionNames{
+			Kind: api.LeaseKind,
+		},
+	},
+}
+
+func (p *dynamoResourceDB) AcquireLease(ctx context.Context, name, holder string, duration time.Duration) (*api.Resource, error) {
+	return p.updateLease(ctx, name, func(existing *api.Resource) (*api.Resource, error) {
+		return framework.PrepareLeaseAcquire(existing, name, holder, duration)
+	})
+}
+
+func (p *dynamoResourceDB) RenewLease(ctx context.Context, name, holder string, duration time.Duration) (*api.Resource, error) {
+	return p.updateLease(ctx, name, func(existing *api.Resource) (*api.Resource, error) {
+		return framework.PrepareLeaseRenew(existing, name, holder, duration)
+	})
+}
+
+func (p *dynamoResourceDB) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := p.updateLease(ctx, name, func(existing *api.Resource) (*api.Resource, error) {
+		return framework.PrepareLeaseRelease(existing, name, holder)
+	})
+	return err
+}
+
+// updateLease conditionally puts the lease computed from the existing one, so that the put fails with ErrConflict
+// when someone else has written the lease after we've read it
+func (p *dynamoResourceDB) updateLease(ctx context.Context, name string, prepare func(existing *api.Resource) (*api.Resource, error)) (*api.Resource, error) {
+	var existing *api.Resource
+	e := api.Resource{}
+	err := p.tableFor(&leaseDefinition).Get(HashKeyName, partitionKey(name)).OneWithContext(ctx, &e)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		err = dynamo.ErrNotFound
+	}
+	switch err {
+	case nil:
+		existing = &e
+	case dynamo.ErrNotFound:
+	default:
+		return nil, fmt.Errorf("unexpected error: %v", err)
+	}
+
+	lease, err := prepare(existing)
+	if err != nil {
+		return nil, err
+	}
+	if err := framework.PrepareWrite(lease, existing); err != nil {
+		return nil, err
+	}
+	if err := p.putCreatingTable(ctx, &leaseDefinition, lease, p.conditionalPut(&leaseDefinition, lease, existing)); err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
@@ -6,19 +6,17 @@ package dynamodb
 import (
 	"bufio"
 	"bytes"
-	"errors"
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/mumoshu/division/api"
-	"github.com/mumoshu/division/dynamodb/awssession"
 	"github.com/mumoshu/division/framework"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/signal"
 	"sync"
 	"time"
 )
@@ -33,11 +31,11 @@ type LogStore struct {
 }
 
 func NewLogs(configFile string, namespace string) (*LogStore, error) {
-	sess, err := awssession.New(os.Getenv("AWSDEBUG") != "")
+	config, err := framework.LoadConfigFromYamlFile(configFile)
 	if err != nil {
 		return nil, err
 	}
-	config, err := framework.LoadConfigFromYamlFile(configFile)
+	sess, err := newSession(config, namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +44,7 @@ func NewLogs(configFile string, namespace string) (*LogStore, error) {
 
 func newLogs(config *api.Config, namespace string, sess *session.Session) (*LogStore, error) {
 	return &LogStore{
-		client:    cloudwatchlogs.New(sess),
+		client:    cloudwatchlogs.New(sess, cloudWatchLogsConfig(config)),
 		config:    config,
 		namespace: namespace,
 	}, nil
@@ -115,15 +113,7 @@ func (s *logStreams) get() []*string {
 	return s.groupStreams
 }
 
-type ErrLogsNotFound struct {
-	msg string
-}
-
-func (e *ErrLogsNotFound) Error() string {
-	return e.msg
-}
-
-func (c *LogStore) Read(resource, name string, since time.Duration, follow bool) (<-chan string, <-chan error) {
+func (c *LogStore) Read(ctx context.Context, resource, name string, since time.Duration, follow bool) (<-chan string, <-chan error) {
 	msgs := make(chan string)
 	errs := make(chan error)
 
@@ -131,16 +121,11 @@ func (c *LogStore) Read(resource, name string, since time.Duration, follow bool)
 		defer close(msgs)
 		defer close(errs)
 
-		streamMsgs, streamErrs := c.read(resource, name, since, follow)
-
-		interrupts := make(chan os.Signal, 1)
-		defer close(interrupts)
-		signal.Notify(interrupts, os.Interrupt)
+		streamMsgs, streamErrs := c.read(ctx, resource, name, since, follow)
 
 		for streamMsgs != nil || streamErrs != nil {
 			select {
-			case <-interrupts:
-				errs <- errors.New("read interrupted")
+			case <-ctx.Done():
 				return
 			case e, ok := <-streamErrs:
 				if !ok {
@@ -150,12 +135,13 @@ func (c *LogStore) Read(resource, name string, since time.Duration, follow bool)
 					switch typed := e.(type) {
 					case awserr.Error:
 						if typed.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
-							errs <- &ErrLogsNotFound{fmt.Sprintf("log stream for resource=%s name=%s does not exist (yet)", resource, name)}
-						} else {
-							errs <- e
+							e = api.NewErrLogsNotFound(fmt.Sprintf("log stream for resource=%s name=%s does not exist (yet)", resource, name))
 						}
-					default:
-						errs <- e
+					}
+					select {
+					case errs <- e:
+					case <-ctx.Done():
+						return
 					}
 				}
 			case log, ok := <-streamMsgs:
@@ -163,7 +149,11 @@ func (c *LogStore) Read(resource, name string, since time.Duration, follow bool)
 					streamMsgs = nil
 				}
 				if log != nil {
-					msgs <- *log.Message
+					select {
+					case msgs <- *log.Message:
+					case <-ctx.Done():
+						return
+					}
 				}
 			}
 		}
@@ -172,23 +162,19 @@ func (c *LogStore) Read(resource, name string, since time.Duration, follow bool)
 	return msgs, errs
 }
 
-func (c *LogStore) ReadPrint(resource, name string, since time.Duration, follow bool) error {
-	logsCh, errCh := c.read(resource, name, since, follow)
-	interrupts := make(chan os.Signal, 1)
-	defer close(interrupts)
-	signal.Notify(interrupts, os.Interrupt)
+func (c *LogStore) ReadPrint(ctx context.Context, resource, name string, since time.Duration, follow bool) error {
+	logsCh, errCh := c.read(ctx, resource, name, since, follow)
 	var err error
 	for logsCh != nil || errCh != nil {
 		select {
-		case <-interrupts:
-			fmt.Fprintln(os.Stderr, "interrupted")
+		case <-ctx.Done():
 			return nil
 		case e := <-errCh:
 			if e != nil {
 				switch typed := e.(type) {
 				case awserr.Error:
 					if typed.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
-						err = &ErrLogsNotFound{fmt.Sprintf("log stream for resource=%s name=%s does not exist (yet)", resource, name)}
+						err = api.NewErrLogsNotFound(fmt.Sprintf("log stream for resource=%s name=%s does not exist (yet)", resource, name))
 					} else {
 						err = e
 					}
@@ -204,14 +190,12 @@ func (c *LogStore) ReadPrint(resource, name string, since time.Duration, follow
 			if log != nil {
 				fmt.Printf("%s", *log.Message)
 			}
-		default:
-			time.Sleep(1000 * time.Millisecond)
 		}
 	}
 	return err
 }
 
-func (c *LogStore) read(resource, name string, since time.Duration, follow bool) (<-chan *cloudwatchlogs.FilteredLogEvent, <-chan error) {
+func (c *LogStore) read(ctx context.Context, resource, name string, since time.Duration, follow bool) (<-chan *cloudwatchlogs.FilteredLogEvent, <-chan error) {
 	logGroup := fmt.Sprintf("%s%s-%s-%s", databasePrefix, c.config.Metadata.Name, c.namespace, resource)
 	var startTime *time.Time
 	if since.Nanoseconds() == 0 {
@@ -220,43 +204,43 @@ func (c *LogStore) read(resource, name string, since time.Duration, follow bool)
 		t := time.Now().Add(-since)
 		startTime = &t
 	}
-	return c.readLogEvents(logGroup, name, follow, startTime)
+	return c.readLogEvents(ctx, logGroup, name, follow, startTime)
 }
 
-func (c *LogStore) Writer(resource, name string) (io.WriteCloser, error) {
+func (c *LogStore) Writer(ctx context.Context, resource, name string) (io.WriteCloser, error) {
 	logStream := name
 	logGroup := fmt.Sprintf("%s%s-%s-%s", databasePrefix, c.config.Metadata.Name, c.namespace, resource)
 	var seqToken *string
 
 	{
-		out, err := c.client.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{
+		out, err := c.client.DescribeLogGroupsWithContext(ctx, &cloudwatchlogs.DescribeLogGroupsInput{
 			LogGroupNamePrefix: aws.String(logGroup),
 		})
 		if err != nil {
 			return nil, err
 		}
 		if len(out.LogGroups) == 0 {
-			_, err := c.client.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
+			_, err := c.client.CreateLogGroupWithContext(ctx, &cloudwatchlogs.CreateLogGroupInput{
 				LogGroupName: aws.String(logGroup),
 			})
 			if err != nil {
 				return nil, err
 			}
 		}
-		describeStreamOut, err := c.client.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
+		describeStreamOut, err := c.client.DescribeLogStreamsWithContext(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
 			LogGroupName:        aws.String(logGroup),
 			LogStreamNamePrefix: aws.String(logStream),
 		})
 		outLogStreams := describeStreamOut.LogStreams
 		if len(outLogStreams) == 0 {
-			if _, err := c.client.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+			if _, err := c.client.CreateLogStreamWithContext(ctx, &cloudwatchlogs.CreateLogStreamInput{
 				LogGroupName:  aws.String(logGroup),
 				LogStreamName: aws.String(logStream),
 			}); err != nil {
 				return nil, err
 			}
 
-			describeStreamOut, err = c.client.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
+			describeStreamOut, err = c.client.DescribeLogStreamsWithContext(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
 				LogGroupName:        aws.String(logGroup),
 				LogStreamNamePrefix: aws.String(logStream),
 			})
@@ -278,6 +262,10 @@ func (c *LogStore) Writer(resource, name string) (io.WriteCloser, error) {
 				readBytes, isPrefix, err := br.ReadLine()
 				if err != nil {
 					if err == io.EOF {
+						if lineToBeWritten.Len() == 0 {
+							// The writer has been closed
+							return
+						}
 						break
 					}
 					panic(err)
@@ -315,9 +303,11 @@ func (c *LogStore) Writer(resource, name string) (io.WriteCloser, error) {
 				if seqToken != nil {
 					putInput.SequenceToken = seqToken
 				}
-				putOut, putErr := c.client.PutLogEvents(putInput)
+				putOut, putErr := c.client.PutLogEventsWithContext(ctx, putInput)
 				if putErr != nil {
-					panic(putErr)
+					// Fail writes instead of panicking, as the context may have been canceled
+					r.CloseWithError(putErr)
+					return
 				}
 
 				seqToken = putOut.NextSequenceToken
@@ -328,7 +318,7 @@ func (c *LogStore) Writer(resource, name string) (io.WriteCloser, error) {
 	return w, nil
 }
 
-func (c *LogStore) WriteFile(resource, name string, file string) error {
+func (c *LogStore) WriteFile(ctx context.Context, resource, name string, file string) error {
 	var rawInput []byte
 	if file == "-" {
 		var buf bytes.Buffer
@@ -346,7 +336,7 @@ func (c *LogStore) WriteFile(resource, name string, file string) error {
 		}
 		rawInput = raw
 	}
-	w, err := c.Writer(resource, name)
+	w, err := c.Writer(ctx, resource, name)
 	if err != nil {
 		return err
 	}
@@ -358,9 +348,9 @@ func (c *LogStore) WriteFile(resource, name string, file string) error {
 	return nil
 }
 
-func (c *LogStore) Delete(resource, name string) error {
+func (c *LogStore) Delete(ctx context.Context, resource, name string) error {
 	logGroup := fmt.Sprintf("%s%s-%s-%s", databasePrefix, c.config.Metadata.Name, c.namespace, resource)
-	_, err := c.client.DeleteLogGroup(&cloudwatchlogs.DeleteLogGroupInput{
+	_, err := c.client.DeleteLogGroupWithContext(ctx, &cloudwatchlogs.DeleteLogGroupInput{
 		LogGroupName: aws.String(logGroup),
 	})
 	return err
@@ -372,7 +362,7 @@ func (c *LogStore) Delete(resource, name string) error {
 //Unless the follow flag is true the channel is closed once there are no more events available
 //
 // The design is that a log group is created per custom resource definition, and a log stream is created custom resource.
-func (c LogStore) readLogEvents(logGroupName string, logStreamNamePrefix string, follow bool, startTime *time.Time) (<-chan *cloudwatchlogs.FilteredLogEvent, <-chan error) {
+func (c LogStore) readLogEvents(ctx context.Context, logGroupName string, logStreamNamePrefix string, follow bool, startTime *time.Time) (<-chan *cloudwatchlogs.FilteredLogEvent, <-chan error) {
 	cwl := c.client
 
 	var lastSeenTimestamp *int64
@@ -390,15 +380,15 @@ func (c LogStore) readLogEvents(logGroupName string, logStreamNamePrefix string,
 	logStreams := &logStreams{}
 
 	listUnseenLogStreams := func(logGroupName string, logStreamName string) ([]*string, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
 		var streamNames []*string
-		streamNamesCh, listErrCh := c.listLogStreams(logGroupName, logStreamName, lastSeenTimestamp)
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
+		streamNamesCh, listErrCh := c.listLogStreams(ctx, logGroupName, logStreamName, lastSeenTimestamp)
 		exiting := false
 		for !exiting {
 			select {
-			case <-c:
-				return nil, fmt.Errorf("interrupted")
+			case <-ctx.Done():
+				return nil, ctx.Err()
 			case err, ok := <-listErrCh:
 				if ok {
 					return nil, err
@@ -410,12 +400,10 @@ func (c LogStore) readLogEvents(logGroupName string, logStreamNamePrefix string,
 				} else {
 					exiting = true
 				}
-			default:
-				time.Sleep(100 * time.Millisecond)
 			}
 		}
 		if len(streamNames) == 0 {
-			return nil, &ErrLogsNotFound{"no such log stream(s)."}
+			return nil, api.NewErrLogsNotFound("no such log stream(s).")
 		}
 		if len(streamNames) >= 100 { //FilterLogEventPages won't take more than 100 stream names
 			streamNames = streamNames[0:100]
@@ -446,7 +434,11 @@ func (c LogStore) readLogEvents(logGroupName string, logStreamNamePrefix string,
 
 			if !recentAlreadySeenLogEvents.Has(*event.EventId) {
 				recentAlreadySeenLogEvents.Add(*event.EventId)
-				logEventsCh <- event
+				select {
+				case logEventsCh <- event:
+				case <-ctx.Done():
+					return false
+				}
 			} else {
 				//fmt.Printf("%s already seen\n", *event.EventId)
 			}
@@ -459,9 +451,16 @@ func (c LogStore) readLogEvents(logGroupName string, logStreamNamePrefix string,
 		defer close(logEventsCh)
 		defer close(errCh)
 
+		sendErr := func(err error) {
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
+		}
+
 		ss, err := listUnseenLogStreams(logGroupName, logStreamNamePrefix)
 		if err != nil {
-			errCh <- err
+			sendErr(err)
 			return
 		}
 		logStreams.reset(ss)
@@ -472,7 +471,7 @@ func (c LogStore) readLogEvents(logGroupName string, logStreamNamePrefix string,
 				lastLogStreamsListTime = time.Now()
 				ss, err := listUnseenLogStreams(logGroupName, logStreamNamePrefix)
 				if err != nil {
-					errCh <- err
+					sendErr(err)
 					return
 				}
 				logStreams.reset(ss)
@@ -480,14 +479,14 @@ func (c LogStore) readLogEvents(logGroupName string, logStreamNamePrefix string,
 			//FilterLogEventPages won't take more than 100 stream names
 			filter := createFilterLogEventsInput(logGroupName, logStreams.get(), lastSeenTimestamp)
 			// Block until the last page is seen
-			err := cwl.FilterLogEventsPages(filter, pageHandler)
+			err := cwl.FilterLogEventsPagesWithContext(ctx, filter, pageHandler)
 			if err != nil {
 				if awsErr, ok := err.(awserr.Error); ok {
 					switch awsErr.Code() {
 					case cloudwatchlogs.ErrCodeLimitExceededException, cloudwatchlogs.ErrCodeServiceUnavailableException:
 						fmt.Fprintf(os.Stderr, "retrying on error: %v", err)
 					default:
-						errCh <- err
+						sendErr(err)
 						return
 					}
 				}
@@ -496,7 +495,9 @@ func (c LogStore) readLogEvents(logGroupName string, logStreamNamePrefix string,
 			}
 			//AWS API accepts 5 reqs/sec
 			//time.Sleep(time.Millisecond * 205)
-			time.Sleep(1 * time.Second)
+			if sleep(ctx, 1*time.Second) != nil {
+				return
+			}
 		}
 	}()
 
@@ -516,7 +517,7 @@ func logStreamMatchesTimeRange(logStream *cloudwatchlogs.LogStream, startTimeMil
 
 // listLogStreams lists the streams of a given stream group
 // It returns a channel where the stream names are published
-func (c LogStore) listLogStreams(groupName string, streamNamePrefix string, startTimeMillis *int64) (<-chan *string, <-chan error) {
+func (c LogStore) listLogStreams(ctx context.Context, groupName string, streamNamePrefix string, startTimeMillis *int64) (<-chan *string, <-chan error) {
 	cwl := c.client
 	streamNamesCh := make(chan *string)
 	errCh := make(chan error)
@@ -529,7 +530,11 @@ func (c LogStore) listLogStreams(groupName string, streamNamePrefix string, star
 		for _, logStream := range res.LogStreams {
 			if logStreamMatchesTimeRange(logStream, startTimeMillis) {
 				fmt.Fprintf(os.Stderr, "fetched stream name: %s\n", *logStream.LogStreamName)
-				streamNamesCh <- logStream.LogStreamName
+				select {
+				case streamNamesCh <- logStream.LogStreamName:
+				case <-ctx.Done():
+					return false
+				}
 			}
 		}
 		return !lastPage
@@ -540,24 +545,29 @@ func (c LogStore) listLogStreams(groupName string, streamNamePrefix string, star
 		defer close(errCh)
 
 		for {
-			err := cwl.DescribeLogStreamsPages(params, handler)
+			err := cwl.DescribeLogStreamsPagesWithContext(ctx, params, handler)
 
 			if err == nil {
 				fmt.Fprintf(os.Stderr, "finishing fetch\n")
 				break
 			}
 
-			errCh <- err
-
 			if awsErr, ok := err.(awserr.Error); ok {
 				switch awsErr.Code() {
 				case cloudwatchlogs.ErrCodeLimitExceededException, cloudwatchlogs.ErrCodeServiceUnavailableException:
 					fmt.Fprintf(os.Stderr, "retrying in 1 second on error: %v\n", err)
-					time.Sleep(100 * time.Millisecond)
-				default:
-					break
+					if sleep(ctx, 1*time.Second) != nil {
+						return
+					}
+					continue
 				}
 			}
+
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
+			return
 		}
 	}()
 	return streamNamesCh, errCh
@@ -0,0 +1,37 @@
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/dynamodb/awssession"
+	"github.com/spf13/viper"
+	"os"
+)
+
+// newSession returns the AWS session for the namespace.
+// The profile and the region given via `div --profile --region` take precedence over the ones in `div.yaml`
+func newSession(config *api.Config, namespace string) (*session.Session, error) {
+	aws := config.Spec.AWS
+
+	profile := viper.GetString("profile")
+	if profile == "" {
+		profile = aws.Profile
+	}
+
+	region := viper.GetString("region")
+	if region == "" {
+		region = aws.Region
+	}
+
+	roleARN := aws.AssumeRoleARN
+	if ns, ok := aws.Namespaces[namespace]; ok && ns.AssumeRoleARN != "" {
+		roleARN = ns.AssumeRoleARN
+	}
+
+	return awssession.New(awssession.Options{
+		Debug:         os.Getenv("AWSDEBUG") != "",
+		Profile:       profile,
+		Region:        region,
+		AssumeRoleARN: roleARN,
+	})
+}
@@ -0,0 +1,48 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/guregu/dynamo"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+)
+
+func (p *dynamoResourceDB) UpdateStatus(ctx context.Context, resource *api.Resource) error {
+	resourceDef, err := p.resourceDefinitionForKind(ctx, resource.Kind)
+	if err != nil {
+		return err
+	}
+
+	var existing *api.Resource
+	e := api.Resource{}
+	err = p.tableFor(resourceDef).Get(HashKeyName, resource.Metadata.Name).OneWithContext(ctx, &e)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		err = dynamo.ErrNotFound
+	}
+	switch err {
+	case nil:
+		existing = &e
+	case dynamo.ErrNotFound:
+	default:
+		return fmt.Errorf("unexpected error: %v", err)
+	}
+
+	updated, err := framework.PrepareStatusWrite(resource, existing)
+	if err != nil {
+		return err
+	}
+
+	err = p.conditionalPut(resourceDef, updated, existing)().RunWithContext(ctx)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return api.NewErrConflict(fmt.Sprintf(`%s "%s" has been modified concurrently. get the latest and retry`, resourceDef.Metadata.Name, resource.Metadata.Name))
+	}
+	if err != nil {
+		return fmt.Errorf("unexpected error: %v", err)
+	}
+	*resource = *updated
+	fmt.Printf("%s \"%s\" status updated\n", resourceDef.Metadata.Name, resource.Metadata.Name)
+	return nil
+}
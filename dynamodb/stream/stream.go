@@ -4,6 +4,7 @@
 package stream
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -13,15 +14,74 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
 	"os"
-	"sync"
 )
 
+// ErrCheckpointExpired is returned when the subscriber is unable to resume from the checkpoint,
+// because records after the checkpoint have been trimmed from the stream, or the stream itself has been replaced.
+// It is also sent to the error channel when a shard reader has fallen behind records trimmed from the stream.
+// Relist items and start over without the checkpoint
+var ErrCheckpointExpired = errors.New("checkpoint expired")
+
+// Checkpoint is the position in the stream up to which records have been processed
+type Checkpoint struct {
+	StreamArn string
+	// StartedAt is when the subscriber has started reading the stream without a checkpoint.
+	// Shards without sequence numbers are read from records created around this time
+	StartedAt time.Time
+	// SequenceNumbers are the sequence numbers of the last records processed, keyed by shard IDs
+	SequenceNumbers map[string]string
+}
+
+// ShardRecord is a record along with the ID of the shard it is read from, so that the record can be checkpointed
+type ShardRecord struct {
+	*dynamodbstreams.Record
+	ShardId string
+}
+
 type StreamSubscriber struct {
 	dynamoSvc         *dynamodb.DynamoDB
 	streamSvc         *dynamodbstreams.DynamoDBStreams
 	table             *string
 	ShardIteratorType *string
 	Limit             *int64
+	checkpoint        *Checkpoint
+	streamArn         *string
+	// startedAt is when the subscriber has started reading the stream, from which shards opened later are read
+	startedAt time.Time
+}
+
+// shardRetryDelay is how long a shard reader waits before it's restarted after failing
+const shardRetryDelay = 5 * time.Second
+
+// shardReader is a shard to be read from the iterator, skipping records created before `since`
+type shardReader struct {
+	shardId  *string
+	iterator *string
+	since    time.Time
+	// lastSequenceNumber is the sequence number of the last record sent, so that a failed reader is resumed right after it
+	lastSequenceNumber *string
+}
+
+// shardResult tells that the reader has stopped. err is nil only when the shard has been read to the end
+type shardResult struct {
+	reader *shardReader
+	err    error
+}
+
+// shardState tracks a shard so that a child shard is read after its parent, and a shard is read only once
+type shardState struct {
+	shard *dynamodbstreams.Shard
+	// initial is true when the shard exists when the subscriber starts
+	initial bool
+	reader  *shardReader
+	started bool
+	done    bool
+	// lastSequenceNumber is the sequence number of the last record sent by the failed reader
+	lastSequenceNumber *string
+}
+
+func (s *shardState) closed() bool {
+	return s.shard.SequenceNumberRange != nil && s.shard.SequenceNumberRange.EndingSequenceNumber != nil
 }
 
 func NewStreamSubscriber(
@@ -47,169 +107,331 @@ func (r *StreamSubscriber) SetShardIteratorType(s string) {
 	r.ShardIteratorType = aws.String(s)
 }
 
-func (r *StreamSubscriber) GetStreamDataAsync() (<-chan *dynamodbstreams.Record, <-chan error) {
-	ch := make(chan *dynamodbstreams.Record, 1)
-	errCh := make(chan error, 1)
+// SetCheckpoint makes the subscriber resume reading right after the checkpoint, instead of the ShardIteratorType
+func (r *StreamSubscriber) SetCheckpoint(checkpoint *Checkpoint) {
+	r.checkpoint = checkpoint
+}
 
-	shardsReloadRequests := make(chan struct{}, 1)
-	shardsReloadRequests <- struct{}{}
+// StreamArn returns the ARN of the stream being read. It is available once GetStreamDataAsync succeeds
+func (r *StreamSubscriber) StreamArn() string {
+	return aws.StringValue(r.streamArn)
+}
 
-	readingShards := make(map[string]struct{})
-	shardProcessingLimit := 10
-	shardIteratorInputs := make(chan *dynamodbstreams.GetShardIteratorInput, shardProcessingLimit)
-	lock := sync.Mutex{}
+// GetStreamDataAsync starts reading the stream.
+// Any number of shards are read concurrently, while a child shard is read after its parent shard is read to the end,
+// so that records for an item are sent in order.
+// Shard iterators for existing shards are obtained before this returns, so that no record written after this returns is missed
+// even when the caller lists items before reading records.
+// ErrCheckpointExpired is returned when the subscriber is unable to resume from the checkpoint.
+// Errors of shard readers, like throttling, are sent to the error channel, and the failed readers are restarted right after the last records they have sent.
+// ErrCheckpointExpired is sent and the subscriber stops when records have been trimmed before being read.
+// Cancel the context to stop reading. Both channels are closed once all the shard readers have stopped
+func (r *StreamSubscriber) GetStreamDataAsync(ctx context.Context) (<-chan *ShardRecord, <-chan error, error) {
+	ch := make(chan *ShardRecord, 1)
+	errCh := make(chan error, 1)
 
-	streamArn, err := r.getLatestStreamArn()
+	r.startedAt = time.Now()
+	streamArn, err := r.getLatestStreamArn(ctx)
 	if err != nil {
-		errCh <- err
-		return ch, errCh
+		return nil, nil, err
+	}
+	if r.checkpoint != nil && r.checkpoint.StreamArn != *streamArn {
+		return nil, nil, ErrCheckpointExpired
 	}
-	shards, err := r.getShards(streamArn)
+	r.streamArn = streamArn
+	shards, err := r.getShards(ctx, streamArn)
 	if err != nil {
-		errCh <- err
-		return ch, errCh
+		return nil, nil, err
 	}
-	numShards := len(shards)
-	if numShards > shardProcessingLimit {
-		panic(fmt.Errorf("too many shards: div supports up to %d, but there were %d shards", shardProcessingLimit, numShards))
+	if r.checkpoint != nil {
+		for shardId := range r.checkpoint.SequenceNumbers {
+			found := false
+			for _, shard := range shards {
+				found = found || *shard.ShardId == shardId
+			}
+			if !found {
+				// The shard has been trimmed along with records after the checkpoint
+				return nil, nil, ErrCheckpointExpired
+			}
+		}
 	}
-	fmt.Fprintf(os.Stderr, "reading %d shards\n", numShards)
+
+	states := map[string]*shardState{}
 	for _, shard := range shards {
-		if _, ok := readingShards[*shard.ShardId]; !ok {
-			readingShards[*shard.ShardId] = struct{}{}
-			shardIteratorInputs <- &dynamodbstreams.GetShardIteratorInput{
-				StreamArn: streamArn,
-				ShardId:   shard.ShardId,
-				// Read from LATEST
-				ShardIteratorType: r.ShardIteratorType,
-			}
+		s := &shardState{shard: shard, initial: true}
+		if r.checkpoint == nil && s.closed() && *r.ShardIteratorType == dynamodbstreams.ShardIteratorTypeLatest {
+			// No record is read from the closed shard at LATEST
+			s.done = true
+		}
+		states[*shard.ShardId] = s
+	}
+	for _, s := range states {
+		if !r.isReady(s, states) {
+			continue
+		}
+		reader, err := r.initialShardReader(ctx, streamArn, s.shard.ShardId)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.reader = reader
+	}
+	fmt.Fprintf(os.Stderr, "reading %d shards\n", len(shards))
+
+	// Shard readers are stopped on ErrCheckpointExpired as well as when the caller cancels the context
+	ctx, cancel := context.WithCancel(ctx)
+
+	sendErr := func(err error) {
+		select {
+		case errCh <- err:
+		case <-ctx.Done():
 		}
 	}
 
 	go func() {
 		tick := time.NewTicker(time.Minute)
-		for {
-			select {
-			case <-tick.C:
-				shardsReloadRequests <- struct{}{}
+		defer tick.Stop()
+		doneShards := make(chan shardResult)
+		running := 0
+		defer func() {
+			// Wait for shard readers to stop before closing channels they send to
+			cancel()
+			for ; running > 0; running-- {
+				<-doneShards
 			}
-		}
-	}()
+			close(ch)
+			close(errCh)
+		}()
 
-	go func() {
 		for {
+			for _, s := range states {
+				if !r.isReady(s, states) {
+					continue
+				}
+				if s.reader == nil {
+					reader, err := r.shardReader(ctx, s)
+					if err == ErrCheckpointExpired {
+						sendErr(err)
+						return
+					}
+					if err != nil {
+						sendErr(err)
+						continue
+					}
+					s.reader = reader
+				}
+				s.started = true
+				running++
+				go func(reader *shardReader) {
+					err := r.readRecordsFromShardContinuously(ctx, reader, ch)
+					if err != nil && err != ErrCheckpointExpired && ctx.Err() == nil {
+						sendErr(fmt.Errorf("failed reading shard %s: %v", *reader.shardId, err))
+						// Wait before the reader is restarted, so that we won't keep hitting e.g. throttling
+						select {
+						case <-time.After(shardRetryDelay):
+						case <-ctx.Done():
+						}
+					}
+					doneShards <- shardResult{reader: reader, err: err}
+				}(s.reader)
+			}
+
 			select {
-			case <-shardsReloadRequests:
-				streamArn, err := r.getLatestStreamArn()
-				if err != nil {
-					errCh <- err
+			case <-ctx.Done():
+				return
+			case res := <-doneShards:
+				running--
+				s := states[*res.reader.shardId]
+				if res.err == nil {
+					s.done = true
+					continue
+				}
+				if res.err == ErrCheckpointExpired {
+					// Records have been trimmed before being read. Reading the shard again never recovers them
+					sendErr(ErrCheckpointExpired)
 					return
 				}
-				shards, err := r.getShards(streamArn)
+				// The shard is read again from where the reader has stopped, rather than marked done.
+				// Otherwise its children would be read while records left in the shard are skipped
+				s.started = false
+				s.reader = nil
+				if res.reader.lastSequenceNumber != nil {
+					s.lastSequenceNumber = res.reader.lastSequenceNumber
+				}
+			case <-tick.C:
+				shards, err := r.getShards(ctx, streamArn)
 				if err != nil {
-					errCh <- err
-					return
+					sendErr(err)
+					continue
 				}
+				current := map[string]struct{}{}
 				for _, shard := range shards {
-					lock.Lock()
-					if _, ok := readingShards[*shard.ShardId]; !ok {
-						readingShards[*shard.ShardId] = struct{}{}
-						shardIteratorInputs <- &dynamodbstreams.GetShardIteratorInput{
-							StreamArn: streamArn,
-							ShardId:   shard.ShardId,
-							// As this shard is created after we start reading, always try to read from TRIM_HORIZON
-							// So that we won't miss records that are created before we start reading
-							ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
-						}
+					current[*shard.ShardId] = struct{}{}
+					if s, ok := states[*shard.ShardId]; ok {
+						s.shard = shard
+					} else {
+						states[*shard.ShardId] = &shardState{shard: shard}
+					}
+				}
+				// Release shards that have been read to the end and trimmed from the stream
+				for shardId, s := range states {
+					if _, ok := current[shardId]; !ok && s.done {
+						delete(states, shardId)
 					}
-					lock.Unlock()
 				}
 			}
 		}
-
 	}()
 
-	limit := make(chan struct{}, shardProcessingLimit)
+	return ch, errCh, nil
+}
 
-	go func() {
-		for shardIteratorInput := range shardIteratorInputs {
-			limit <- struct{}{}
-			go func(sInput *dynamodbstreams.GetShardIteratorInput) {
-				err := r.readRecordsFromShardContinuously(sInput, ch)
-				if err != nil {
-					errCh <- err
-				}
-				// TODO: think about cleaning list of shards: delete(readingShards, *sInput.ShardId)
-				<-limit
-			}(shardIteratorInput)
-		}
-		println("restarting shards reader in 10 seconds...")
-		time.Sleep(time.Second * 10)
-	}()
-	return ch, errCh
+// isReady returns true when the shard is ready to be read, that is when the parent shard has been read to the end or trimmed
+func (r *StreamSubscriber) isReady(s *shardState, states map[string]*shardState) bool {
+	if s.started || s.done {
+		return false
+	}
+	if s.shard.ParentShardId == nil {
+		return true
+	}
+	parent, ok := states[*s.shard.ParentShardId]
+	return !ok || parent.done
 }
 
-func (r *StreamSubscriber) getShards(streamArn *string) (ids []*dynamodbstreams.Shard, err error) {
-	des, err := r.streamSvc.DescribeStream(&dynamodbstreams.DescribeStreamInput{
-		StreamArn: streamArn,
+// shardReader returns the reader for the shard whose parent has been read.
+// The shard whose reader has failed is read right after the last record sent, or from when the subscriber has started
+// when no record has been sent
+func (r *StreamSubscriber) shardReader(ctx context.Context, s *shardState) (*shardReader, error) {
+	if s.lastSequenceNumber != nil {
+		iter, err := r.streamSvc.GetShardIteratorWithContext(ctx, &dynamodbstreams.GetShardIteratorInput{
+			StreamArn:         r.streamArn,
+			ShardId:           s.shard.ShardId,
+			ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber),
+			SequenceNumber:    s.lastSequenceNumber,
+		})
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodbstreams.ErrCodeTrimmedDataAccessException {
+			return nil, ErrCheckpointExpired
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &shardReader{shardId: s.shard.ShardId, iterator: iter.ShardIterator, lastSequenceNumber: s.lastSequenceNumber}, nil
+	}
+	if s.initial {
+		if r.checkpoint == nil && *r.ShardIteratorType == dynamodbstreams.ShardIteratorTypeLatest {
+			// LATEST now would skip records written since the subscriber has started, like while the failed reader is waiting to be restarted.
+			// Approximate creation times of records are rounded down to minutes, so we may read a few records twice here
+			iter, err := r.streamSvc.GetShardIteratorWithContext(ctx, &dynamodbstreams.GetShardIteratorInput{
+				StreamArn:         r.streamArn,
+				ShardId:           s.shard.ShardId,
+				ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return &shardReader{shardId: s.shard.ShardId, iterator: iter.ShardIterator, since: r.startedAt.Truncate(time.Minute)}, nil
+		}
+		return r.initialShardReader(ctx, r.streamArn, s.shard.ShardId)
+	}
+	// As this shard is created after we start reading, always try to read from TRIM_HORIZON
+	// So that we won't miss records that are created before we start reading
+	iter, err := r.streamSvc.GetShardIteratorWithContext(ctx, &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         r.streamArn,
+		ShardId:           s.shard.ShardId,
+		ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
 	})
 	if err != nil {
 		return nil, err
 	}
-	// No shards
-	if 0 == len(des.StreamDescription.Shards) {
-		return nil, nil
-	}
-
-	return des.StreamDescription.Shards, nil
+	return &shardReader{shardId: s.shard.ShardId, iterator: iter.ShardIterator}, nil
 }
 
-func (r *StreamSubscriber) getLatestStreamArn() (*string, error) {
-	tableInfo, err := r.dynamoSvc.DescribeTable(&dynamodb.DescribeTableInput{TableName: r.table})
+// initialShardReader returns the reader for the shard that exists when the subscriber starts.
+// The shard is read right after the checkpoint if any, or from the ShardIteratorType
+func (r *StreamSubscriber) initialShardReader(ctx context.Context, streamArn, shardId *string) (*shardReader, error) {
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         streamArn,
+		ShardId:           shardId,
+		ShardIteratorType: r.ShardIteratorType,
+	}
+	var since time.Time
+	if r.checkpoint != nil {
+		if seq, ok := r.checkpoint.SequenceNumbers[*shardId]; ok {
+			input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+			input.SequenceNumber = aws.String(seq)
+		} else {
+			// No record has been processed in this shard since the subscriber has started without a checkpoint.
+			// Approximate creation times of records are rounded down to minutes, so we may read a few records twice here
+			input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon)
+			since = r.checkpoint.StartedAt.Truncate(time.Minute)
+		}
+	}
+	iter, err := r.streamSvc.GetShardIteratorWithContext(ctx, input)
+	if awsErr, ok := err.(awserr.Error); ok && r.checkpoint != nil && awsErr.Code() == dynamodbstreams.ErrCodeTrimmedDataAccessException {
+		return nil, ErrCheckpointExpired
+	}
 	if err != nil {
 		return nil, err
 	}
-	if nil == tableInfo.Table.LatestStreamArn {
-		return nil, errors.New("empty table stream arn")
-	}
-	return tableInfo.Table.LatestStreamArn, nil
+	return &shardReader{shardId: shardId, iterator: iter.ShardIterator, since: since}, nil
 }
 
-func (r *StreamSubscriber) processShardBackport(shardId, lastStreamArn *string, ch chan<- *dynamodbstreams.Record) error {
-	return r.readRecordsFromShardContinuously(&dynamodbstreams.GetShardIteratorInput{
-		StreamArn:         lastStreamArn,
-		ShardId:           shardId,
-		ShardIteratorType: r.ShardIteratorType,
-	}, ch)
+func (r *StreamSubscriber) getShards(ctx context.Context, streamArn *string) ([]*dynamodbstreams.Shard, error) {
+	shards := []*dynamodbstreams.Shard{}
+	var lastShardId *string
+	for {
+		des, err := r.streamSvc.DescribeStreamWithContext(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             streamArn,
+			ExclusiveStartShardId: lastShardId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, des.StreamDescription.Shards...)
+		lastShardId = des.StreamDescription.LastEvaluatedShardId
+		if lastShardId == nil {
+			return shards, nil
+		}
+	}
 }
 
-func (r *StreamSubscriber) readRecordsFromShardContinuously(input *dynamodbstreams.GetShardIteratorInput, ch chan<- *dynamodbstreams.Record) error {
-	iter, err := r.streamSvc.GetShardIterator(input)
+func (r *StreamSubscriber) getLatestStreamArn(ctx context.Context) (*string, error) {
+	tableInfo, err := r.dynamoSvc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: r.table})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if iter.ShardIterator == nil {
-		return nil
+	if nil == tableInfo.Table.LatestStreamArn {
+		return nil, errors.New("empty table stream arn")
 	}
+	return tableInfo.Table.LatestStreamArn, nil
+}
 
-	nextIterator := iter.ShardIterator
+func (r *StreamSubscriber) readRecordsFromShardContinuously(ctx context.Context, reader *shardReader, ch chan<- *ShardRecord) error {
+	nextIterator := reader.iterator
 
 	for nextIterator != nil {
-		recs, err := r.streamSvc.GetRecords(&dynamodbstreams.GetRecordsInput{
+		recs, err := r.streamSvc.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
 			ShardIterator: nextIterator,
 			Limit:         r.Limit,
 		})
-		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "TrimmedDataAccessException" {
-			//Trying to request data older than 24h, that's ok
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodbstreams.ErrCodeTrimmedDataAccessException {
+			// Records older than 24h have been trimmed before we read them. Tell the caller to relist rather than silently skipping them
 			//http://docs.aws.amazon.com/dynamodbstreams/latest/APIReference/API_GetShardIterator.html -> Errors
-			return nil
+			return ErrCheckpointExpired
 		}
 		if err != nil {
 			return err
 		}
 
 		for _, record := range recs.Records {
-			ch <- record
+			if created := record.Dynamodb.ApproximateCreationDateTime; created != nil && created.Before(reader.since) {
+				continue
+			}
+			select {
+			case ch <- &ShardRecord{Record: record, ShardId: *reader.shardId}:
+				reader.lastSequenceNumber = record.Dynamodb.SequenceNumber
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
 
 		nextIterator = recs.NextShardIterator
@@ -224,7 +446,11 @@ func (r *StreamSubscriber) readRecordsFromShardContinuously(input *dynamodbstrea
 			sleepDuration = time.Second * 10
 		}
 
-		time.Sleep(sleepDuration)
+		select {
+		case <-time.After(sleepDuration):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	return nil
 }
@@ -1,20 +1,20 @@
 package dynamodb
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
-	"github.com/elgs/jsonql"
 	"github.com/mumoshu/division/api"
 	"github.com/mumoshu/division/framework"
 	"os"
 	"time"
 )
 
-func (p *dynamoResourceDB) Wait(resource, name string, query string, output string, timeout time.Duration, logs bool) error {
+func (p *dynamoResourceDB) Wait(ctx context.Context, resource, name string, cond api.WaitCondition, output string, timeout time.Duration, logs bool) error {
 	if name == "" {
 		return fmt.Errorf("missing resource name")
 	}
-	r, err := p.wait(resource, name, query, timeout, logs)
+	r, err := p.wait(ctx, resource, name, cond, timeout, logs)
 	if err != nil {
 		return err
 	}
@@ -22,23 +22,29 @@ func (p *dynamoResourceDB) Wait(resource, name string, query string, output stri
 	return nil
 }
 
-func (p *dynamoResourceDB) wait(resource, name string, query string, timeout time.Duration, logs bool) (*api.Resource, error) {
-	resources, err := p.get(resource, name, []string{})
+func (p *dynamoResourceDB) wait(ctx context.Context, resource, name string, cond api.WaitCondition, timeout time.Duration, logs bool) (*api.Resource, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resources, err := p.get(ctx, resource, name, []string{})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, r := range resources {
-		matched, err := match(r, query)
+	for i := range resources {
+		matched, err := cond(&resources[i])
 		if err != nil {
 			return nil, err
 		}
 		if matched {
-			return &r, nil
+			return &resources[i], nil
 		}
 	}
 
-	rs, es := p.streamedResources(resource, name, []string{})
+	evs, es, _, err := p.streamedEvents(ctx, resource, name, []string{}, nil)
+	if err != nil {
+		return nil, err
+	}
 	to := make(<-chan time.Time)
 	if timeout > 0 {
 		to = time.After(timeout)
@@ -46,52 +52,49 @@ func (p *dynamoResourceDB) wait(resource, name string, query string, timeout tim
 	logMsgCh := make(<-chan *cloudwatchlogs.FilteredLogEvent)
 	logErrCh := make(<-chan error)
 	if logs {
-		logMsgCh, logErrCh = p.logs.read(resource, name, 0, true)
+		logMsgCh, logErrCh = p.logs.read(ctx, resource, name, 0, true)
 	}
 	for {
 		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		case <-to:
 			return nil, fmt.Errorf("timed out")
-		case err := <-es:
-			return nil, fmt.Errorf("failed streaming: %v", err)
-		case err := <-logErrCh:
-			return nil, fmt.Errorf("failed streaming logs: %v", err)
-		case msg := <-logMsgCh:
+		case err, ok := <-es:
+			if ok {
+				return nil, fmt.Errorf("failed streaming: %v", err)
+			}
+			es = nil
+		case err, ok := <-logErrCh:
+			if ok {
+				return nil, fmt.Errorf("failed streaming logs: %v", err)
+			}
+			logErrCh = nil
+		case msg, ok := <-logMsgCh:
+			if !ok {
+				logMsgCh = nil
+				continue
+			}
 			fmt.Fprintf(os.Stderr, "%s", *msg.Message)
-		case res := <-rs:
-			matched, e := match(*res, query)
+		case e, ok := <-evs:
+			if !ok {
+				return nil, fmt.Errorf("stream stopped unexpectedly: please rerun the div command")
+			}
+			ev := e.event
+			if ev == nil {
+				continue
+			}
+			if ev.Type == api.Deleted {
+				return nil, fmt.Errorf("%s \"%s\" has been deleted", resource, name)
+			}
+			res := ev.Object
+			matched, err := cond(res)
 			if err != nil {
-				return nil, e
+				return nil, err
 			}
 			if matched {
 				return res, nil
 			}
-		default:
 		}
 	}
-
-	return nil, fmt.Errorf("stream stopped unexpectedly: please rerun the div command")
-}
-
-func match(resource api.Resource, query string) (bool, error) {
-	r := resource.Format("json")
-
-	stringQuery, err := jsonql.NewStringQuery(r)
-	if err != nil {
-		return false, err
-	}
-
-	ret, err := stringQuery.Query(query)
-	if err != nil {
-		return false, err
-	}
-
-	switch ret.(type) {
-	case map[string]interface{}:
-		return true, nil
-	case nil:
-		return false, nil
-	default:
-		return false, fmt.Errorf("unexpected type of jsonql query result")
-	}
 }
@@ -0,0 +1,211 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NameIndex is the index every cache has, that looks up resources by `metadata.name`
+const NameIndex = "name"
+
+// IndexFunc returns values under which the resource is indexed. A resource without any value is left unindexed
+type IndexFunc func(resource *api.Resource) ([]string, error)
+
+// Indexers are index functions keyed by index names
+type Indexers map[string]IndexFunc
+
+// NameIndexFunc indexes resources by `metadata.name`
+func NameIndexFunc(resource *api.Resource) ([]string, error) {
+	return []string{resource.Metadata.Name}, nil
+}
+
+// LabelIndexFunc returns the index function that indexes resources by the value of the label
+func LabelIndexFunc(key string) IndexFunc {
+	return func(resource *api.Resource) ([]string, error) {
+		if v, ok := resource.Metadata.Labels[key]; ok {
+			return []string{v}, nil
+		}
+		return nil, nil
+	}
+}
+
+// FieldIndexFunc returns the index function that indexes resources by the value of the dot-separated field path like `spec.project`.
+// A resource is indexed under every element when the field is an array
+func FieldIndexFunc(path string) IndexFunc {
+	keys := strings.Split(path, ".")
+	return func(resource *api.Resource) ([]string, error) {
+		raw, err := json.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, nil
+			}
+			v = m[k]
+		}
+		switch typed := v.(type) {
+		case nil:
+			return nil, nil
+		case []interface{}:
+			values := []string{}
+			for _, e := range typed {
+				values = append(values, fmt.Sprint(e))
+			}
+			return values, nil
+		case map[string]interface{}:
+			return nil, fmt.Errorf("unable to index %s \"%s\" by \"%s\": the field is an object", resource.Kind, resource.Metadata.Name, path)
+		default:
+			return []string{fmt.Sprint(typed)}, nil
+		}
+	}
+}
+
+// Cache is the local copy of resources kept up to date by an informer, along with indices on them.
+// Resources returned by the cache are copies, so that callers are free to modify them
+type Cache struct {
+	items    map[string]*api.Resource
+	indexers Indexers
+	// indices are sets of resource names keyed by index names and values
+	indices map[string]map[string]map[string]struct{}
+	sync.RWMutex
+}
+
+func newCache(indexers Indexers) *Cache {
+	c := &Cache{
+		items:    map[string]*api.Resource{},
+		indexers: Indexers{NameIndex: NameIndexFunc},
+		indices:  map[string]map[string]map[string]struct{}{},
+	}
+	for name, f := range indexers {
+		c.indexers[name] = f
+	}
+	for name := range c.indexers {
+		c.indices[name] = map[string]map[string]struct{}{}
+	}
+	return c
+}
+
+// Get returns the resource named `name`. The bool is false when the resource isn't cached
+func (c *Cache) Get(name string) (*api.Resource, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	r, ok := c.items[name]
+	if !ok {
+		return nil, false
+	}
+	return r.DeepCopy(), true
+}
+
+// List returns all the cached resources sorted by names
+func (c *Cache) List() []*api.Resource {
+	c.RLock()
+	defer c.RUnlock()
+	names := []string{}
+	for name := range c.items {
+		names = append(names, name)
+	}
+	return c.copies(names)
+}
+
+// ByIndex returns the resources indexed under the value in the index, sorted by names
+func (c *Cache) ByIndex(index, value string) ([]*api.Resource, error) {
+	c.RLock()
+	defer c.RUnlock()
+	idx, ok := c.indices[index]
+	if !ok {
+		return nil, fmt.Errorf("index \"%s\" does not exist", index)
+	}
+	names := []string{}
+	for name := range idx[value] {
+		names = append(names, name)
+	}
+	return c.copies(names), nil
+}
+
+// copies must be called while the cache is locked
+func (c *Cache) copies(names []string) []*api.Resource {
+	sort.Strings(names)
+	rs := []*api.Resource{}
+	for _, name := range names {
+		rs = append(rs, c.items[name].DeepCopy())
+	}
+	return rs
+}
+
+// get returns the cached resource itself, not a copy
+func (c *Cache) get(name string) (*api.Resource, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	r, ok := c.items[name]
+	return r, ok
+}
+
+func (c *Cache) names() []string {
+	c.RLock()
+	defer c.RUnlock()
+	names := []string{}
+	for name := range c.items {
+		names = append(names, name)
+	}
+	return names
+}
+
+// put adds or replaces the resource. The cache owns the resource afterwards
+func (c *Cache) put(resource *api.Resource) error {
+	values := map[string][]string{}
+	for index, f := range c.indexers {
+		vs, err := f(resource)
+		if err != nil {
+			return err
+		}
+		values[index] = vs
+	}
+	c.Lock()
+	defer c.Unlock()
+	name := resource.Metadata.Name
+	c.unindex(name)
+	c.items[name] = resource
+	for index, vs := range values {
+		for _, v := range vs {
+			names, ok := c.indices[index][v]
+			if !ok {
+				names = map[string]struct{}{}
+				c.indices[index][v] = names
+			}
+			names[name] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (c *Cache) delete(name string) {
+	c.Lock()
+	defer c.Unlock()
+	c.unindex(name)
+	delete(c.items, name)
+}
+
+// unindex must be called while the cache is locked
+func (c *Cache) unindex(name string) {
+	for _, idx := range c.indices {
+		for v, names := range idx {
+			if _, ok := names[name]; !ok {
+				continue
+			}
+			delete(names, name)
+			if len(names) == 0 {
+				delete(idx, v)
+			}
+		}
+	}
+}
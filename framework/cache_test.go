@@ -0,0 +1,166 @@
+package framework
+
+import (
+	"github.com/mumoshu/division/api"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newCachedResource(name string, resourceVersion int64, created time.Time, labels map[string]string, spec map[string]interface{}) *api.Resource {
+	return &api.Resource{
+		NameHashKey: name,
+		Kind:        "Deployment",
+		Metadata: api.Metadata{
+			Name:              name,
+			ResourceVersion:   resourceVersion,
+			CreationTimestamp: created,
+			Labels:            labels,
+		},
+		Spec: spec,
+	}
+}
+
+// namesOf returns names of the resources in order
+func namesOf(rs []*api.Resource) []string {
+	names := []string{}
+	for _, r := range rs {
+		names = append(names, r.Metadata.Name)
+	}
+	return names
+}
+
+func TestCacheIndices(t *testing.T) {
+	created := time.Now()
+	c := newCache(Indexers{
+		"team":     LabelIndexFunc("team"),
+		"project":  FieldIndexFunc("spec.project"),
+		"clusters": FieldIndexFunc("spec.clusters"),
+	})
+
+	writes := []*api.Resource{
+		newCachedResource("foo", 1, created, map[string]string{"team": "frontend"}, map[string]interface{}{"project": "proj1", "clusters": []interface{}{"prod1", "prod2"}}),
+		newCachedResource("bar", 1, created, map[string]string{"team": "frontend"}, map[string]interface{}{"project": "proj2"}),
+		newCachedResource("baz", 1, created, nil, map[string]interface{}{"project": "proj1", "clusters": []interface{}{"prod2"}}),
+		// Updates move the resource to the new values
+		newCachedResource("foo", 2, created, map[string]string{"team": "backend"}, map[string]interface{}{"project": "proj2", "clusters": []interface{}{"prod1"}}),
+	}
+	for _, r := range writes {
+		if err := c.put(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	testcases := []struct {
+		index    string
+		value    string
+		expected []string
+	}{
+		{index: NameIndex, value: "foo", expected: []string{"foo"}},
+		{index: "team", value: "frontend", expected: []string{"bar"}},
+		{index: "team", value: "backend", expected: []string{"foo"}},
+		{index: "project", value: "proj1", expected: []string{"baz"}},
+		{index: "project", value: "proj2", expected: []string{"bar", "foo"}},
+		{index: "clusters", value: "prod1", expected: []string{"foo"}},
+		{index: "clusters", value: "prod2", expected: []string{"baz"}},
+		{index: "clusters", value: "prod3", expected: []string{}},
+	}
+
+	for _, tc := range testcases {
+		rs, err := c.ByIndex(tc.index, tc.value)
+		if err != nil {
+			t.Errorf("%s=%s: unexpected error: %v", tc.index, tc.value, err)
+			continue
+		}
+		if actual := namesOf(rs); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%s=%s: unexpected resources: expected=%v, actual=%v", tc.index, tc.value, tc.expected, actual)
+		}
+	}
+
+	if _, err := c.ByIndex("none", "foo"); err == nil {
+		t.Errorf("expected error for the missing index, but got none")
+	}
+
+	// Deleted resources are removed from every index
+	c.delete("foo")
+	for _, index := range []string{NameIndex, "team", "project", "clusters"} {
+		for v, names := range c.indices[index] {
+			if _, ok := names["foo"]; ok {
+				t.Errorf("the deleted resource is left in %s=%s", index, v)
+			}
+		}
+	}
+	if actual := namesOf(c.List()); !reflect.DeepEqual(actual, []string{"bar", "baz"}) {
+		t.Errorf("unexpected resources: %v", actual)
+	}
+
+	// The cache isn't modified via resources it returns
+	r, ok := c.Get("bar")
+	if !ok {
+		t.Fatalf("bar not found")
+	}
+	r.Metadata.Labels["team"] = "backend"
+	if rs, _ := c.ByIndex("team", "frontend"); !reflect.DeepEqual(namesOf(rs), []string{"bar"}) {
+		t.Errorf("the cache is modified: %v", namesOf(rs))
+	}
+	if cached, _ := c.Get("bar"); cached.Metadata.Labels["team"] != "frontend" {
+		t.Errorf("the cache is modified: %v", cached.Metadata.Labels)
+	}
+}
+
+func TestCachePutIndexError(t *testing.T) {
+	c := newCache(Indexers{"spec": FieldIndexFunc("spec")})
+	r := newCachedResource("foo", 1, time.Now(), nil, map[string]interface{}{"project": "proj1"})
+	if err := c.put(r); err == nil {
+		t.Errorf("expected error for indexing an object, but got none")
+	}
+	if _, ok := c.Get("foo"); ok {
+		t.Errorf("the resource failed to be indexed is cached")
+	}
+}
+
+func TestIsNewer(t *testing.T) {
+	created := time.Now()
+
+	testcases := []struct {
+		name     string
+		r        *api.Resource
+		than     *api.Resource
+		expected bool
+	}{
+		{
+			name:     "later resourceVersion",
+			r:        newCachedResource("foo", 3, created, nil, nil),
+			than:     newCachedResource("foo", 2, created, nil, nil),
+			expected: true,
+		},
+		{
+			name: "same resourceVersion",
+			r:    newCachedResource("foo", 2, created, nil, nil),
+			than: newCachedResource("foo", 2, created, nil, nil),
+		},
+		{
+			name: "earlier resourceVersion",
+			r:    newCachedResource("foo", 1, created, nil, nil),
+			than: newCachedResource("foo", 2, created, nil, nil),
+		},
+		{
+			// The resourceVersion of the recreated resource restarts from 1
+			name:     "recreated",
+			r:        newCachedResource("foo", 1, created.Add(time.Second), nil, nil),
+			than:     newCachedResource("foo", 5, created, nil, nil),
+			expected: true,
+		},
+		{
+			name: "deleted",
+			r:    newCachedResource("foo", 5, created, nil, nil),
+			than: newCachedResource("foo", 1, created.Add(time.Second), nil, nil),
+		},
+	}
+
+	for _, tc := range testcases {
+		if actual := isNewer(tc.r, tc.than); actual != tc.expected {
+			t.Errorf("%s: expected=%v, actual=%v", tc.name, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"strings"
+	"time"
+)
+
+// Conditions returns `status.conditions` of the resource
+func Conditions(resource *api.Resource) ([]api.Condition, error) {
+	v, ok := resource.Status["conditions"]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	conds := []api.Condition{}
+	if err := json.Unmarshal(raw, &conds); err != nil {
+		return nil, fmt.Errorf("invalid status.conditions of %s \"%s\": %v", resource.Kind, resource.Metadata.Name, err)
+	}
+	return conds, nil
+}
+
+// GetCondition returns the condition of the type, or nil when the resource doesn't have it
+func GetCondition(resource *api.Resource, condType string) (*api.Condition, error) {
+	conds, err := Conditions(resource)
+	if err != nil {
+		return nil, err
+	}
+	for i := range conds {
+		if conds[i].Type == condType {
+			return &conds[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// IsConditionTrue returns true when the resource has the condition of the type with the status "True"
+func IsConditionTrue(resource *api.Resource, condType string) bool {
+	c, err := GetCondition(resource, condType)
+	return err == nil && c != nil && c.Status == api.ConditionTrue
+}
+
+// SetCondition adds or replaces the condition of the same type in the status of the resource. Call UpdateStatus afterwards to persist it.
+// lastTransitionTime is updated only when the status of the condition changes, so that it tells since when the resource has been in the status
+func SetCondition(resource *api.Resource, cond api.Condition) error {
+	conds, err := Conditions(resource)
+	if err != nil {
+		return err
+	}
+	if cond.LastTransitionTime.IsZero() {
+		cond.LastTransitionTime = time.Now()
+	}
+	replaced := false
+	for i := range conds {
+		if conds[i].Type != cond.Type {
+			continue
+		}
+		if conds[i].Status == cond.Status {
+			cond.LastTransitionTime = conds[i].LastTransitionTime
+		}
+		conds[i] = cond
+		replaced = true
+	}
+	if !replaced {
+		conds = append(conds, cond)
+	}
+	return setConditions(resource, conds)
+}
+
+// RemoveCondition removes the condition of the type from the status of the resource
+func RemoveCondition(resource *api.Resource, condType string) error {
+	conds, err := Conditions(resource)
+	if err != nil {
+		return err
+	}
+	remaining := []api.Condition{}
+	for _, c := range conds {
+		if c.Type != condType {
+			remaining = append(remaining, c)
+		}
+	}
+	return setConditions(resource, remaining)
+}
+
+// setConditions writes conditions as generic values like other fields of the status, so that every backend is able to persist them
+func setConditions(resource *api.Resource, conds []api.Condition) error {
+	raw, err := json.Marshal(conds)
+	if err != nil {
+		return err
+	}
+	var v []interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	if resource.Status == nil {
+		resource.Status = map[string]interface{}{}
+	}
+	resource.Status["conditions"] = v
+	return nil
+}
+
+// ConditionStatusIs returns the wait condition satisfied when the resource has the condition of the type with the status.
+// Like Match, the resource whose status is stale never satisfies it
+func ConditionStatusIs(condType string, status api.ConditionStatus) api.WaitCondition {
+	return func(resource *api.Resource) (bool, error) {
+		if !resource.Observed() {
+			return false, nil
+		}
+		c, err := GetCondition(resource, condType)
+		if err != nil {
+			return false, err
+		}
+		return c != nil && strings.EqualFold(string(c.Status), string(status)), nil
+	}
+}
+
+// ParseWaitFor parses the `--for` flag of `div wait` formatted `condition=TYPE[=STATUS]`, whose status defaults to "True"
+func ParseWaitFor(waitFor string) (api.WaitCondition, error) {
+	parts := strings.Split(waitFor, "=")
+	if parts[0] != "condition" || len(parts) < 2 || len(parts) > 3 || parts[1] == "" {
+		return nil, fmt.Errorf(`invalid wait condition "%s": it must be formatted "condition=TYPE[=STATUS]"`, waitFor)
+	}
+	status := api.ConditionTrue
+	if len(parts) == 3 {
+		status = api.ConditionStatus(parts[2])
+	}
+	return ConditionStatusIs(parts[1], status), nil
+}
@@ -0,0 +1,83 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"time"
+)
+
+// PrepareWrite checks the resourceVersion of the resource against the existing one, and sets the next resourceVersion,
+// the next generation when the spec has changed, the creation timestamp and the status of the existing resource to the resource to be written.
+// `existing` is nil when the resource doesn't exist yet.
+// Backends should call this and then write the resource atomically, so that concurrent writers get ErrConflict
+func PrepareWrite(resource *api.Resource, existing *api.Resource) error {
+	if err := checkResourceVersion(resource, existing); err != nil {
+		return err
+	}
+	if existing == nil {
+		resource.Metadata.ResourceVersion = 1
+		resource.Metadata.Generation = 1
+		return nil
+	}
+	resource.Metadata.CreationTimestamp = existing.Metadata.CreationTimestamp
+	resource.Metadata.ResourceVersion = existing.Metadata.ResourceVersion + 1
+	resource.Metadata.Generation = existing.Metadata.Generation
+	if resource.Metadata.Generation == 0 {
+		// The resource is written before generation is introduced
+		resource.Metadata.Generation = 1
+	}
+	if !specEqual(resource.Spec, existing.Spec) {
+		resource.Metadata.Generation++
+	}
+	// The status is updated only via UpdateStatus, so that re-applying a manifest won't reset it
+	resource.Status = existing.Status
+	return nil
+}
+
+// PrepareStatusWrite returns the existing resource whose status is replaced with the one of `resource`.
+// Like PrepareWrite, backends should write the returned resource atomically
+func PrepareStatusWrite(resource *api.Resource, existing *api.Resource) (*api.Resource, error) {
+	if existing == nil {
+		return nil, api.NewErrResourceNotFound(fmt.Sprintf(`%s "%s" not found`, resource.Kind, resource.Metadata.Name))
+	}
+	if err := checkResourceVersion(resource, existing); err != nil {
+		return nil, err
+	}
+	updated := *existing
+	updated.Status = resource.Status
+	updated.Metadata.UpdateTimestamp = time.Now()
+	updated.Metadata.ResourceVersion = existing.Metadata.ResourceVersion + 1
+	return &updated, nil
+}
+
+func checkResourceVersion(resource *api.Resource, existing *api.Resource) error {
+	expected := resource.Metadata.ResourceVersion
+	if expected == 0 {
+		return nil
+	}
+	if existing == nil {
+		return api.NewErrConflict(fmt.Sprintf(`%s "%s" has been deleted: resourceVersion %d was expected. get the latest and retry`, resource.Kind, resource.Metadata.Name, expected))
+	}
+	if current := existing.Metadata.ResourceVersion; expected != current {
+		return api.NewErrConflict(fmt.Sprintf(`%s "%s" has been modified: resourceVersion %d was expected, but it was %d. get the latest and retry`, resource.Kind, resource.Metadata.Name, expected, current))
+	}
+	return nil
+}
+
+// specEqual compares specs in their JSON representations, so that e.g. an int read from a manifest equals to a float64 read from the database
+func specEqual(a, b map[string]interface{}) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	rawA, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	rawB, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(rawA, rawB)
+}
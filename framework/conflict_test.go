@@ -0,0 +1,189 @@
+package framework
+
+import (
+	"github.com/mumoshu/division/api"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newResource(resourceVersion, generation int64, spec, status map[string]interface{}) *api.Resource {
+	return &api.Resource{
+		NameHashKey: "foo",
+		Kind:        "Cluster",
+		Metadata: api.Metadata{
+			Name:            "foo",
+			ResourceVersion: resourceVersion,
+			Generation:      generation,
+		},
+		Spec:   spec,
+		Status: status,
+	}
+}
+
+func TestPrepareWrite(t *testing.T) {
+	created := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testcases := []struct {
+		name       string
+		resource   *api.Resource
+		existing   *api.Resource
+		conflict   bool
+		rv         int64
+		generation int64
+		status     map[string]interface{}
+	}{
+		{
+			name:       "create",
+			resource:   newResource(0, 0, map[string]interface{}{"a": "b"}, nil),
+			rv:         1,
+			generation: 1,
+		},
+		{
+			name:     "create deleted",
+			resource: newResource(3, 0, map[string]interface{}{"a": "b"}, nil),
+			conflict: true,
+		},
+		{
+			name:       "update without resourceVersion",
+			resource:   newResource(0, 0, map[string]interface{}{"a": "c"}, nil),
+			existing:   newResource(3, 2, map[string]interface{}{"a": "b"}, map[string]interface{}{"phase": "running"}),
+			rv:         4,
+			generation: 3,
+			status:     map[string]interface{}{"phase": "running"},
+		},
+		{
+			name:       "update with the latest resourceVersion",
+			resource:   newResource(3, 2, map[string]interface{}{"a": "c"}, nil),
+			existing:   newResource(3, 2, map[string]interface{}{"a": "b"}, nil),
+			rv:         4,
+			generation: 3,
+		},
+		{
+			name:     "update with a stale resourceVersion",
+			resource: newResource(2, 2, map[string]interface{}{"a": "c"}, nil),
+			existing: newResource(3, 2, map[string]interface{}{"a": "b"}, nil),
+			conflict: true,
+		},
+		{
+			name:       "unchanged spec",
+			resource:   newResource(0, 0, map[string]interface{}{"a": "b"}, map[string]interface{}{"phase": "reset"}),
+			existing:   newResource(3, 2, map[string]interface{}{"a": "b"}, map[string]interface{}{"phase": "running"}),
+			rv:         4,
+			generation: 2,
+			status:     map[string]interface{}{"phase": "running"},
+		},
+		{
+			name:       "written before generation",
+			resource:   newResource(0, 0, map[string]interface{}{"a": "b"}, nil),
+			existing:   newResource(3, 0, map[string]interface{}{"a": "b"}, nil),
+			rv:         4,
+			generation: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		if tc.existing != nil {
+			tc.existing.Metadata.CreationTimestamp = created
+		}
+		err := PrepareWrite(tc.resource, tc.existing)
+		if tc.conflict {
+			if _, ok := err.(*api.ErrConflict); !ok {
+				t.Errorf("%s: expected ErrConflict, but got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		m := tc.resource.Metadata
+		if m.ResourceVersion != tc.rv {
+			t.Errorf("%s: unexpected resourceVersion: expected=%d, actual=%d", tc.name, tc.rv, m.ResourceVersion)
+		}
+		if m.Generation != tc.generation {
+			t.Errorf("%s: unexpected generation: expected=%d, actual=%d", tc.name, tc.generation, m.Generation)
+		}
+		if tc.existing != nil && !m.CreationTimestamp.Equal(created) {
+			t.Errorf("%s: unexpected creationTimestamp: %v", tc.name, m.CreationTimestamp)
+		}
+		if tc.existing != nil && !reflect.DeepEqual(tc.resource.Status, tc.status) {
+			t.Errorf("%s: unexpected status: expected=%v, actual=%v", tc.name, tc.status, tc.resource.Status)
+		}
+	}
+}
+
+func TestPrepareStatusWrite(t *testing.T) {
+	testcases := []struct {
+		name     string
+		resource *api.Resource
+		existing *api.Resource
+		err      interface{}
+	}{
+		{
+			name:     "missing",
+			resource: newResource(0, 0, nil, map[string]interface{}{"phase": "running"}),
+			err:      &api.ErrResourceNotFound{},
+		},
+		{
+			name:     "stale",
+			resource: newResource(2, 1, nil, map[string]interface{}{"phase": "running"}),
+			existing: newResource(3, 1, map[string]interface{}{"a": "b"}, nil),
+			err:      &api.ErrConflict{},
+		},
+		{
+			name:     "latest",
+			resource: newResource(3, 1, map[string]interface{}{"a": "c"}, map[string]interface{}{"phase": "running"}),
+			existing: newResource(3, 1, map[string]interface{}{"a": "b"}, nil),
+		},
+	}
+
+	for _, tc := range testcases {
+		updated, err := PrepareStatusWrite(tc.resource, tc.existing)
+		if tc.err != nil {
+			if reflect.TypeOf(err) != reflect.TypeOf(tc.err) {
+				t.Errorf("%s: expected %T, but got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if updated.Metadata.ResourceVersion != tc.existing.Metadata.ResourceVersion+1 {
+			t.Errorf("%s: unexpected resourceVersion: %d", tc.name, updated.Metadata.ResourceVersion)
+		}
+		// Only the status is written, so that the spec written concurrently is kept
+		if !reflect.DeepEqual(updated.Spec, tc.existing.Spec) {
+			t.Errorf("%s: unexpected spec: expected=%v, actual=%v", tc.name, tc.existing.Spec, updated.Spec)
+		}
+		if !reflect.DeepEqual(updated.Status, tc.resource.Status) {
+			t.Errorf("%s: unexpected status: expected=%v, actual=%v", tc.name, tc.resource.Status, updated.Status)
+		}
+	}
+}
+
+func TestSpecEqual(t *testing.T) {
+	testcases := []struct {
+		a        map[string]interface{}
+		b        map[string]interface{}
+		expected bool
+	}{
+		{a: nil, b: nil, expected: true},
+		{a: nil, b: map[string]interface{}{}, expected: true},
+		{a: map[string]interface{}{"a": "b"}, b: nil, expected: false},
+		{a: map[string]interface{}{"a": "b"}, b: map[string]interface{}{"a": "b"}, expected: true},
+		{a: map[string]interface{}{"a": "b"}, b: map[string]interface{}{"a": "c"}, expected: false},
+		{a: map[string]interface{}{"a": "b", "c": "d"}, b: map[string]interface{}{"c": "d", "a": "b"}, expected: true},
+		// An int read from a manifest equals to a float64 read from the database
+		{a: map[string]interface{}{"replicas": 3}, b: map[string]interface{}{"replicas": float64(3)}, expected: true},
+		{a: map[string]interface{}{"replicas": 3}, b: map[string]interface{}{"replicas": "3"}, expected: false},
+		{a: map[string]interface{}{"a": []interface{}{"b", "c"}}, b: map[string]interface{}{"a": []interface{}{"c", "b"}}, expected: false},
+	}
+
+	for i, tc := range testcases {
+		if actual := specEqual(tc.a, tc.b); actual != tc.expected {
+			t.Errorf("case %d: unexpected result for %v and %v: expected=%v, actual=%v", i, tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"os"
+	"sync"
+	"time"
+)
+
+// Result tells the controller what to do after a successful reconciliation
+type Result struct {
+	// RequeueAfter reconciles the resource again after the duration, when non-zero
+	RequeueAfter time.Duration
+}
+
+// Reconciler brings the actual state closer to the desired state of the resource named `name`.
+// The resource may have been deleted, which is told by the informer's cache.
+// Returning an error reconciles the resource again with exponential backoff
+type Reconciler interface {
+	Reconcile(ctx context.Context, name string) (Result, error)
+}
+
+// ReconcilerFunc is the function implementing Reconciler
+type ReconcilerFunc func(ctx context.Context, name string) (Result, error)
+
+func (f ReconcilerFunc) Reconcile(ctx context.Context, name string) (Result, error) {
+	return f(ctx, name)
+}
+
+type ControllerOptions struct {
+	// Workers is the number of resources reconciled concurrently. Defaults to 1
+	Workers int
+	// RateLimiter delays reconciliations retried on errors. Defaults to the exponential backoff from DefaultBaseDelay up to DefaultMaxDelay
+	RateLimiter RateLimiter
+}
+
+// Controller reconciles resources of a kind whenever they are changed or resynced.
+// A resource is reconciled by one worker at a time, and changes made to it while being reconciled are coalesced into one more reconciliation
+type Controller struct {
+	resource   string
+	queue      *WorkQueue
+	reconciler Reconciler
+	workers    int
+}
+
+func newController(resource string, informer *Informer, reconciler Reconciler, opts ControllerOptions) *Controller {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	rateLimiter := opts.RateLimiter
+	if rateLimiter == nil {
+		rateLimiter = NewExponentialBackoff(DefaultBaseDelay, DefaultMaxDelay)
+	}
+	c := &Controller{
+		resource:   resource,
+		queue:      NewWorkQueue(rateLimiter),
+		reconciler: reconciler,
+		workers:    workers,
+	}
+	informer.AddEventHandler(ResourceEventHandlerFuncs{
+		AddFunc: func(obj *api.Resource) {
+			c.Enqueue(obj.Metadata.Name)
+		},
+		UpdateFunc: func(oldObj, newObj *api.Resource) {
+			c.Enqueue(newObj.Metadata.Name)
+		},
+		DeleteFunc: func(obj *api.Resource) {
+			c.Enqueue(obj.Metadata.Name)
+		},
+	})
+	return c
+}
+
+// Enqueue reconciles the resource named `name`, e.g. when a resource of another kind it depends on is changed
+func (c *Controller) Enqueue(name string) {
+	c.queue.Add(name)
+}
+
+// run reconciles resources until the context is canceled, and then waits for reconciliations in progress
+func (c *Controller) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.processNextItem(ctx) {
+			}
+		}()
+	}
+	<-ctx.Done()
+	c.queue.ShutDown()
+	wg.Wait()
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	name, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(name)
+
+	res, err := c.reconciler.Reconcile(ctx, name)
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "failed reconciling %s \"%s\". retrying (%d): %v\n", c.resource, name, c.queue.NumRequeues(name)+1, err)
+			c.queue.AddRateLimited(name)
+		}
+		return true
+	}
+	c.queue.Forget(name)
+	if res.RequeueAfter > 0 {
+		c.queue.AddAfter(name, res.RequeueAfter)
+	}
+	return true
+}
+
+// Manager runs controllers along with informers shared among them, so that a reconciler is able to look up resources of any kind
+// in the informer's cache without querying the store
+type Manager struct {
+	store       api.Store
+	opts        InformerOptions
+	informers   map[string]*Informer
+	controllers []*Controller
+	mu          sync.Mutex
+}
+
+// NewManager returns the manager whose informers are created with the options
+func NewManager(store api.Store, opts InformerOptions) *Manager {
+	return &Manager{
+		store:     store,
+		opts:      opts,
+		informers: map[string]*Informer{},
+	}
+}
+
+// Informer returns the shared informer for the resource. Informers must be obtained before Run
+func (m *Manager) Informer(resource string) (*Informer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i, ok := m.informers[resource]; ok {
+		return i, nil
+	}
+	i, err := NewInformer(m.store, resource, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	m.informers[resource] = i
+	return i, nil
+}
+
+// Register adds the controller that reconciles resources of the kind with the reconciler. Controllers must be registered before Run
+func (m *Manager) Register(resource string, reconciler Reconciler, opts ControllerOptions) (*Controller, error) {
+	informer, err := m.Informer(resource)
+	if err != nil {
+		return nil, err
+	}
+	c := newController(resource, informer, reconciler, opts)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.controllers = append(m.controllers, c)
+	return c, nil
+}
+
+// Run starts informers, and then starts controllers once all the informers have synced.
+// It blocks until the context is canceled and reconciliations in progress are finished
+func (m *Manager) Run(ctx context.Context) {
+	m.mu.Lock()
+	informers := []*Informer{}
+	for _, i := range m.informers {
+		informers = append(informers, i)
+	}
+	controllers := append([]*Controller{}, m.controllers...)
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, i := range informers {
+		wg.Add(1)
+		go func(i *Informer) {
+			defer wg.Done()
+			i.Run(ctx)
+		}(i)
+	}
+	for _, i := range informers {
+		if !i.WaitForSync(ctx) {
+			return
+		}
+	}
+	for _, c := range controllers {
+		wg.Add(1)
+		go func(c *Controller) {
+			defer wg.Done()
+			c.run(ctx)
+		}(c)
+	}
+	<-ctx.Done()
+}
@@ -0,0 +1,211 @@
+package framework
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"os"
+	"os/exec"
+)
+
+// Converter returns the resource converted to the version. It's called only when the resource is of another version
+type Converter func(ctx context.Context, resource *api.Resource, version string) (*api.Resource, error)
+
+type converters map[string]Converter
+
+// Converters is the registry of converters keyed by kind.
+// A converter registered for the kind takes precedence over `conversion` of the definition,
+// so that programs built on the framework, like controllers, can convert resources in Go
+var Converters converters
+
+func (c converters) Register(kind string, converter Converter) {
+	if _, exists := c[kind]; exists {
+		panic(fmt.Errorf(`duplicate converter for kind "%s" detected`, kind))
+	}
+	c[kind] = converter
+}
+
+func init() {
+	Converters = converters{}
+}
+
+// conversionRequest is written to the stdin of the conversion command
+type conversionRequest struct {
+	DesiredAPIVersion string        `json:"desiredAPIVersion"`
+	Object            *api.Resource `json:"object"`
+}
+
+// ConvertResource returns the resource converted to the version.
+// The resource is returned as is when it's already at the version, or the definition has no versions
+func ConvertResource(ctx context.Context, def *api.CustomResourceDefinition, resource *api.Resource, version string) (*api.Resource, error) {
+	if len(def.Spec.Versions) == 0 {
+		return resource, nil
+	}
+	if def.Spec.Version(version) == nil {
+		return nil, fmt.Errorf(`%s has no version "%s"`, def.Metadata.Name, version)
+	}
+	from := def.Spec.VersionOf(resource)
+	if from == version && resource.APIVersion == version {
+		return resource, nil
+	}
+	r := resource.DeepCopy()
+	r.APIVersion = from
+	if from == version {
+		return r, nil
+	}
+
+	var converted *api.Resource
+	var err error
+	if convert, ok := Converters[def.Spec.Names.Kind]; ok {
+		converted, err = convert(ctx, r, version)
+	} else {
+		converted, err = convertByDefinition(ctx, def, r, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf(`failed converting %s "%s" from %s to %s: %v`, def.Metadata.Name, resource.Metadata.Name, from, version, err)
+	}
+	if converted.APIVersion != version {
+		return nil, fmt.Errorf(`failed converting %s "%s" from %s to %s: the converter returned apiVersion "%s"`, def.Metadata.Name, resource.Metadata.Name, from, version, converted.APIVersion)
+	}
+	// Converters convert only spec and status. Anything else is kept as is, so that e.g. resourceVersion isn't lost
+	converted.NameHashKey = resource.NameHashKey
+	converted.Kind = resource.Kind
+	converted.Metadata = resource.Metadata
+	return converted, nil
+}
+
+func convertByDefinition(ctx context.Context, def *api.CustomResourceDefinition, resource *api.Resource, version string) (*api.Resource, error) {
+	strategy := api.NoneConverter
+	if def.Spec.Conversion != nil && def.Spec.Conversion.Strategy != "" {
+		strategy = def.Spec.Conversion.Strategy
+	}
+	switch strategy {
+	case api.NoneConverter:
+		resource.APIVersion = version
+		return resource, nil
+	case api.CommandConverter:
+		return runConversionCommand(ctx, def.Spec.Conversion.Command, resource, version)
+	default:
+		return nil, fmt.Errorf(`unexpected conversion strategy "%s": it must be one of "%s" and "%s"`, strategy, api.NoneConverter, api.CommandConverter)
+	}
+}
+
+func runConversionCommand(ctx context.Context, command []string, resource *api.Resource, version string) (*api.Resource, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("missing conversion command")
+	}
+	req, err := json.Marshal(conversionRequest{
+		DesiredAPIVersion: version,
+		Object:            resource,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var stdout bytes.Buffer
+	c := exec.CommandContext(ctx, command[0], command[1:]...)
+	c.Stdin = bytes.NewReader(req)
+	c.Stdout = &stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("conversion command %v: %v", command, err)
+	}
+	converted := &api.Resource{}
+	if err := json.Unmarshal(stdout.Bytes(), converted); err != nil {
+		return nil, fmt.Errorf("conversion command %v: unexpected output: %v", command, err)
+	}
+	return converted, nil
+}
+
+// ConvertWatchEvents converts objects of watch events to the version, so that watchers see resources at the version they know.
+// The context must be the one the events are watched with
+func ConvertWatchEvents(ctx context.Context, def *api.CustomResourceDefinition, version string, events <-chan *api.WatchEvent, errs <-chan error) (<-chan *api.WatchEvent, <-chan error) {
+	evCh := make(chan *api.WatchEvent)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(evCh)
+		defer close(errCh)
+		for events != nil || errs != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				errCh <- err
+				return
+			case ev, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				converted, err := convertWatchEvent(ctx, def, version, ev)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				select {
+				case evCh <- converted:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return evCh, errCh
+}
+
+func convertWatchEvent(ctx context.Context, def *api.CustomResourceDefinition, version string, ev *api.WatchEvent) (*api.WatchEvent, error) {
+	converted := &api.WatchEvent{Type: ev.Type}
+	var err error
+	if converted.Object, err = ConvertResource(ctx, def, ev.Object, version); err != nil {
+		return nil, err
+	}
+	if ev.OldObject != nil {
+		if converted.OldObject, err = ConvertResource(ctx, def, ev.OldObject, version); err != nil {
+			return nil, err
+		}
+	}
+	return converted, nil
+}
+
+// AdmitResource prepares the resource to be written by Apply. It normalizes and validates the resource at its version,
+// and then converts it to the storage version. The namespace of a cluster-scoped resource is cleared.
+// Backends should call this after looking up the definition and before writing the resource
+func AdmitResource(ctx context.Context, def *api.CustomResourceDefinition, resource *api.Resource) error {
+	if len(def.Spec.Versions) > 0 {
+		version := def.Spec.VersionOf(resource)
+		if v := def.Spec.Version(version); v == nil || !v.Served {
+			return api.NewErrInvalid(resource.Kind, resource.Metadata.Name, []string{fmt.Sprintf(`apiVersion: Unsupported value: "%s": supported values: %s`, version, servedVersions(def))})
+		}
+	}
+	if def.Spec.ClusterScoped() {
+		// The resource is shared among namespaces
+		resource.Metadata.Namespace = ""
+	}
+	if err := NormalizeResource(def, resource); err != nil {
+		return err
+	}
+	if err := ValidateResource(def, resource); err != nil {
+		return err
+	}
+	converted, err := ConvertResource(ctx, def, resource, def.Spec.StorageVersion())
+	if err != nil {
+		return err
+	}
+	*resource = *converted
+	return nil
+}
+
+func servedVersions(def *api.CustomResourceDefinition) string {
+	served := []interface{}{}
+	for _, v := range def.Spec.Versions {
+		if v.Served {
+			served = append(served, v.Name)
+		}
+	}
+	return formatValues(served)
+}
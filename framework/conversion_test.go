@@ -0,0 +1,174 @@
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/mumoshu/division/api"
+	"testing"
+)
+
+func newVersionedDefinition(kind string, conversion *api.CustomResourceConversion) *api.CustomResourceDefinition {
+	return &api.CustomResourceDefinition{
+		Metadata: api.Metadata{Name: "widget"},
+		Spec: api.CustomResourceDefinitionSpec{
+			Names: api.CustomResourceDefinitionNames{Kind: kind},
+			Versions: []api.CustomResourceDefinitionVersion{
+				{Name: "v1", Served: true},
+				{Name: "v2", Served: true, Storage: true},
+			},
+			Conversion: conversion,
+		},
+	}
+}
+
+func newWidget(apiVersion string, spec map[string]interface{}) *api.Resource {
+	return &api.Resource{
+		NameHashKey: "foo",
+		APIVersion:  apiVersion,
+		Kind:        "Widget",
+		Metadata: api.Metadata{
+			Name:            "foo",
+			ResourceVersion: 3,
+		},
+		Spec: spec,
+	}
+}
+
+// Converters are registered once, like the ones registered by packages on init
+func init() {
+	// Renames spec.size to spec.replicas in v2
+	Converters.Register("ConvertedWidget", func(ctx context.Context, resource *api.Resource, version string) (*api.Resource, error) {
+		switch version {
+		case "v2":
+			resource.Spec["replicas"] = resource.Spec["size"]
+			delete(resource.Spec, "size")
+		case "v1":
+			resource.Spec["size"] = resource.Spec["replicas"]
+			delete(resource.Spec, "replicas")
+		}
+		resource.APIVersion = version
+		return resource, nil
+	})
+	Converters.Register("BrokenWidget", func(ctx context.Context, resource *api.Resource, version string) (*api.Resource, error) {
+		return resource, nil
+	})
+}
+
+func TestConvertResource(t *testing.T) {
+	testcases := []struct {
+		name     string
+		def      *api.CustomResourceDefinition
+		resource *api.Resource
+		version  string
+		expected *api.Resource
+		err      bool
+	}{
+		{
+			name:     "unversioned",
+			def:      &api.CustomResourceDefinition{Metadata: api.Metadata{Name: "widget"}},
+			resource: newWidget("", map[string]interface{}{"size": 1}),
+			version:  "v2",
+			expected: newWidget("", map[string]interface{}{"size": 1}),
+		},
+		{
+			name:     "same version",
+			def:      newVersionedDefinition("Widget", nil),
+			resource: newWidget("v2", map[string]interface{}{"size": 1}),
+			version:  "v2",
+			expected: newWidget("v2", map[string]interface{}{"size": 1}),
+		},
+		{
+			name:     "without apiVersion",
+			def:      newVersionedDefinition("Widget", nil),
+			resource: newWidget("", map[string]interface{}{"size": 1}),
+			version:  "v1",
+			expected: newWidget("v1", map[string]interface{}{"size": 1}),
+		},
+		{
+			name:     "none",
+			def:      newVersionedDefinition("Widget", nil),
+			resource: newWidget("v1", map[string]interface{}{"size": 1}),
+			version:  "v2",
+			expected: newWidget("v2", map[string]interface{}{"size": 1}),
+		},
+		{
+			name:     "unknown version",
+			def:      newVersionedDefinition("Widget", nil),
+			resource: newWidget("v1", map[string]interface{}{"size": 1}),
+			version:  "v3",
+			err:      true,
+		},
+		{
+			name:     "converter",
+			def:      newVersionedDefinition("ConvertedWidget", nil),
+			resource: newWidget("v1", map[string]interface{}{"size": 1}),
+			version:  "v2",
+			expected: newWidget("v2", map[string]interface{}{"replicas": 1}),
+		},
+		{
+			name:     "converter returning another version",
+			def:      newVersionedDefinition("BrokenWidget", nil),
+			resource: newWidget("v1", map[string]interface{}{"size": 1}),
+			version:  "v2",
+			err:      true,
+		},
+		{
+			name: "command",
+			def: newVersionedDefinition("Widget", &api.CustomResourceConversion{
+				Strategy: api.CommandConverter,
+				Command:  []string{"sh", "-c", `cat >/dev/null; echo '{"apiVersion": "v2", "kind": "Ignored", "metadata": {"name": "bar"}, "spec": {"replicas": 1}}'`},
+			}),
+			resource: newWidget("v1", map[string]interface{}{"size": 1}),
+			version:  "v2",
+			expected: newWidget("v2", map[string]interface{}{"replicas": 1}),
+		},
+		{
+			name: "failing command",
+			def: newVersionedDefinition("Widget", &api.CustomResourceConversion{
+				Strategy: api.CommandConverter,
+				Command:  []string{"sh", "-c", "exit 1"},
+			}),
+			resource: newWidget("v1", map[string]interface{}{"size": 1}),
+			version:  "v2",
+			err:      true,
+		},
+		{
+			name:     "unknown strategy",
+			def:      newVersionedDefinition("Widget", &api.CustomResourceConversion{Strategy: "Webhook"}),
+			resource: newWidget("v1", map[string]interface{}{"size": 1}),
+			version:  "v2",
+			err:      true,
+		},
+	}
+
+	for _, tc := range testcases {
+		original := tc.resource.DeepCopy()
+		actual, err := ConvertResource(context.Background(), tc.def, tc.resource, tc.version)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected error, but got none: %+v", tc.name, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if expected, actual := jsonOf(t, tc.expected), jsonOf(t, actual); expected != actual {
+			t.Errorf("%s: unexpected resource:\nexpected=%s\nactual=%s", tc.name, expected, actual)
+		}
+		// The resource given is never modified
+		if expected, actual := jsonOf(t, original), jsonOf(t, tc.resource); expected != actual {
+			t.Errorf("%s: the resource is modified:\nexpected=%s\nactual=%s", tc.name, expected, actual)
+		}
+	}
+}
+
+// jsonOf returns the resource in JSON along with the hash key, so that resources are compared regardless of Go types of numbers
+func jsonOf(t *testing.T, r *api.Resource) string {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return r.NameHashKey + " " + string(raw)
+}
@@ -0,0 +1,128 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"os"
+	"sort"
+)
+
+// NormalizeResource drops fields not listed in the schema of the version of the resource when the definition has `preserveUnknownFields: false`,
+// and then sets `default`s of the schema to missing fields.
+// It runs before ValidateResource in AdmitResource, so that defaults are validated and written along with the resource
+func NormalizeResource(def *api.CustomResourceDefinition, resource *api.Resource) error {
+	schema := def.Spec.SchemaFor(def.Spec.VersionOf(resource))
+	if resource.Kind == api.CustomResourceDefinitionKind || schema == nil {
+		return nil
+	}
+	obj, err := toJSONValue(resource)
+	if err != nil {
+		return err
+	}
+	if def.Spec.Prunes() {
+		// Top-level fields are never pruned, as they are the fields of every resource.
+		// Fields of metadata are never pruned either, as they are managed by div
+		m, _ := obj.(map[string]interface{})
+		for _, name := range []string{"spec", "status"} {
+			prop, ok := schema.Properties[name]
+			if !ok || m[name] == nil {
+				continue
+			}
+			for _, path := range prune(m[name], &prop, name) {
+				fmt.Fprintf(os.Stderr, "%s \"%s\": dropped unknown field %s\n", resource.Kind, resource.Metadata.Name, path)
+			}
+		}
+	}
+	if err := applyDefaults(obj, schema); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	normalized := api.Resource{}
+	if err := json.Unmarshal(raw, &normalized); err != nil {
+		return err
+	}
+	normalized.NameHashKey = resource.NameHashKey
+	*resource = normalized
+	return nil
+}
+
+// prune deletes fields not listed in the schema from the value in place, and returns paths to the deleted fields
+func prune(v interface{}, schema *api.JSONSchemaProps, path string) []string {
+	if schema.XPreserveUnknownFields {
+		return nil
+	}
+	pruned := []string{}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(t))
+		for name := range t {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := schema.Properties[name]
+			if !ok {
+				delete(t, name)
+				pruned = append(pruned, join(path, name))
+				continue
+			}
+			pruned = append(pruned, prune(t[name], &prop, join(path, name))...)
+		}
+	case []interface{}:
+		if schema.Items != nil {
+			for i, item := range t {
+				pruned = append(pruned, prune(item, schema.Items, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return pruned
+}
+
+// applyDefaults sets defaults to missing or null fields of the value in place, including fields of defaults themselves
+func applyDefaults(v interface{}, schema *api.JSONSchemaProps) error {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for name := range schema.Properties {
+			prop := schema.Properties[name]
+			if t[name] == nil && prop.Default != nil {
+				d, err := deepCopyJSON(prop.Default)
+				if err != nil {
+					return err
+				}
+				t[name] = d
+			}
+			if fv, ok := t[name]; ok {
+				if err := applyDefaults(fv, &prop); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if schema.Items != nil {
+			for _, item := range t {
+				if err := applyDefaults(item, schema.Items); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deepCopyJSON copies the default so that resources never share it with the definition, converting it into the form read from JSON
+func deepCopyJSON(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var c interface{}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,162 @@
+package framework
+
+import (
+	"github.com/mumoshu/division/api"
+	"reflect"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	testcases := []struct {
+		schema   string
+		value    string
+		expected string
+		pruned   []string
+	}{
+		{
+			schema:   `{"properties": {"app": {}}}`,
+			value:    `{"app": "foo"}`,
+			expected: `{"app": "foo"}`,
+			pruned:   []string{},
+		},
+		{
+			schema:   `{"properties": {"app": {}}}`,
+			value:    `{"app": "foo", "ap": "foo", "sha": "abc"}`,
+			expected: `{"app": "foo"}`,
+			pruned:   []string{"spec.ap", "spec.sha"},
+		},
+		{
+			schema:   `{"properties": {"labels": {"x-kubernetes-preserve-unknown-fields": true}}}`,
+			value:    `{"labels": {"team": "frontend"}, "label": {}}`,
+			expected: `{"labels": {"team": "frontend"}}`,
+			pruned:   []string{"spec.label"},
+		},
+		{
+			schema:   `{"properties": {"ports": {"items": {"properties": {"port": {}}}}}}`,
+			value:    `{"ports": [{"port": 80, "protocol": "TCP"}, {"port": 443}]}`,
+			expected: `{"ports": [{"port": 80}, {"port": 443}]}`,
+			pruned:   []string{"spec.ports[0].protocol"},
+		},
+		{
+			// Fields of objects without properties are pruned unless they're preserved
+			schema:   `{"properties": {"values": {}}}`,
+			value:    `{"values": {"a": {"b": "c"}}}`,
+			expected: `{"values": {}}`,
+			pruned:   []string{"spec.values.a"},
+		},
+	}
+
+	for i, tc := range testcases {
+		v := valueOf(t, tc.value)
+		pruned := prune(v, schemaOf(t, tc.schema), "spec")
+		if expected := valueOf(t, tc.expected); !reflect.DeepEqual(v, expected) {
+			t.Errorf("case %d: unexpected value: expected=%v, actual=%v", i, expected, v)
+		}
+		if !reflect.DeepEqual(pruned, tc.pruned) {
+			t.Errorf("case %d: unexpected pruned fields: expected=%v, actual=%v", i, tc.pruned, pruned)
+		}
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	testcases := []struct {
+		schema   string
+		value    string
+		expected string
+	}{
+		{
+			schema:   `{"properties": {"replicas": {"default": 1}}}`,
+			value:    `{}`,
+			expected: `{"replicas": 1}`,
+		},
+		{
+			schema:   `{"properties": {"replicas": {"default": 1}}}`,
+			value:    `{"replicas": 3}`,
+			expected: `{"replicas": 3}`,
+		},
+		{
+			schema:   `{"properties": {"replicas": {"default": 1}}}`,
+			value:    `{"replicas": null}`,
+			expected: `{"replicas": 1}`,
+		},
+		{
+			// Fields of defaults are defaulted too
+			schema:   `{"properties": {"strategy": {"default": {}, "properties": {"type": {"default": "Rolling"}}}}}`,
+			value:    `{}`,
+			expected: `{"strategy": {"type": "Rolling"}}`,
+		},
+		{
+			schema:   `{"properties": {"ports": {"items": {"properties": {"protocol": {"default": "TCP"}}}}}}`,
+			value:    `{"ports": [{"port": 80}, {"port": 53, "protocol": "UDP"}]}`,
+			expected: `{"ports": [{"port": 80, "protocol": "TCP"}, {"port": 53, "protocol": "UDP"}]}`,
+		},
+		{
+			schema:   `{"properties": {"spec": {"properties": {"replicas": {"default": 1}}}}}`,
+			value:    `{}`,
+			expected: `{}`,
+		},
+	}
+
+	for i, tc := range testcases {
+		v := valueOf(t, tc.value)
+		if err := applyDefaults(v, schemaOf(t, tc.schema)); err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if expected := valueOf(t, tc.expected); !reflect.DeepEqual(v, expected) {
+			t.Errorf("case %d: unexpected value: expected=%v, actual=%v", i, expected, v)
+		}
+	}
+}
+
+func TestApplyDefaultsCopiesDefaults(t *testing.T) {
+	schema := schemaOf(t, `{"properties": {"labels": {"default": {"team": "frontend"}}}}`)
+
+	a := valueOf(t, `{}`)
+	if err := applyDefaults(a, schema); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.(map[string]interface{})["labels"].(map[string]interface{})["team"] = "backend"
+
+	b := valueOf(t, `{}`)
+	if err := applyDefaults(b, schema); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := valueOf(t, `{"labels": {"team": "frontend"}}`); !reflect.DeepEqual(b, expected) {
+		t.Errorf("the default is shared among resources: expected=%v, actual=%v", expected, b)
+	}
+}
+
+func TestNormalizeResource(t *testing.T) {
+	preserve := false
+	def := &api.CustomResourceDefinition{
+		Metadata: api.Metadata{Name: "deployment"},
+		Spec: api.CustomResourceDefinitionSpec{
+			Names:                 api.CustomResourceDefinitionNames{Kind: "Deployment"},
+			PreserveUnknownFields: &preserve,
+			Validation: &api.CustomResourceValidation{
+				OpenAPIV3Schema: schemaOf(t, `{"properties": {"spec": {"properties": {"app": {}, "replicas": {"default": 1}}}}}`),
+			},
+		},
+	}
+	resource := &api.Resource{
+		NameHashKey: "foo",
+		Kind:        "Deployment",
+		Metadata: api.Metadata{
+			Name:   "foo",
+			Labels: map[string]string{"team": "frontend"},
+		},
+		Spec: map[string]interface{}{"app": "foo", "ap": "foo"},
+	}
+
+	if err := NormalizeResource(def, resource); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := map[string]interface{}{"app": "foo", "replicas": float64(1)}; !reflect.DeepEqual(resource.Spec, expected) {
+		t.Errorf("unexpected spec: expected=%v, actual=%v", expected, resource.Spec)
+	}
+	// Metadata is never pruned
+	if resource.NameHashKey != "foo" || resource.Metadata.Labels["team"] != "frontend" {
+		t.Errorf("unexpected metadata: %s %+v", resource.NameHashKey, resource.Metadata)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/ghodss/yaml"
 	"github.com/mumoshu/division/api"
-	"io"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -29,20 +28,9 @@ func LoadResourceFromYaml(data []byte) (*api.Resource, error) {
 }
 
 func LoadResourceFromYamlFile(file string) (*api.Resource, error) {
-	var bytes []byte
-	if file == "-" {
-		bytes = make([]byte, 1024*10)
-		read, err := io.ReadFull(os.Stdin, bytes)
-		if err != nil {
-			return nil, err
-		}
-		fmt.Fprintf(os.Stderr, "read %d byytes\n", read)
-	} else {
-		raw, err := ioutil.ReadFile(file)
-		if err != nil {
-			return nil, err
-		}
-		bytes = raw
+	bytes, err := readFile(file)
+	if err != nil {
+		return nil, err
 	}
 	resource, err := LoadResourceFromYaml(bytes)
 	if err != nil {
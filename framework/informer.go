@@ -0,0 +1,280 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"os"
+	"sync"
+	"time"
+)
+
+// relistInterval is how long the informer waits before relisting after the watch fails
+const relistInterval = 5 * time.Second
+
+// ResourceEventHandler is notified of changes to resources cached by an informer.
+// Handlers are called one at a time after the cache is updated, so they must not block for long
+type ResourceEventHandler interface {
+	OnAdd(obj *api.Resource)
+	// OnUpdate is also called with the unchanged resource on every resync
+	OnUpdate(oldObj, newObj *api.Resource)
+	OnDelete(obj *api.Resource)
+}
+
+// ResourceEventHandlerFuncs is the ResourceEventHandler calling whichever functions are set
+type ResourceEventHandlerFuncs struct {
+	AddFunc    func(obj *api.Resource)
+	UpdateFunc func(oldObj, newObj *api.Resource)
+	DeleteFunc func(obj *api.Resource)
+}
+
+func (f ResourceEventHandlerFuncs) OnAdd(obj *api.Resource) {
+	if f.AddFunc != nil {
+		f.AddFunc(obj)
+	}
+}
+
+func (f ResourceEventHandlerFuncs) OnUpdate(oldObj, newObj *api.Resource) {
+	if f.UpdateFunc != nil {
+		f.UpdateFunc(oldObj, newObj)
+	}
+}
+
+func (f ResourceEventHandlerFuncs) OnDelete(obj *api.Resource) {
+	if f.DeleteFunc != nil {
+		f.DeleteFunc(obj)
+	}
+}
+
+type InformerOptions struct {
+	// Selectors restricts cached resources to ones with matching labels
+	Selectors []string
+	// ResyncPeriod is how often resources are relisted and redelivered to handlers as updates. Zero disables resyncs
+	ResyncPeriod time.Duration
+	// Indexers are indices maintained in addition to NameIndex
+	Indexers Indexers
+	// Checkpoint is passed to the watch, so that the informer resumes the watch from where the previous one has stopped
+	Checkpoint string
+}
+
+// Informer keeps the local cache of resources up to date by listing and then watching them, and notifies handlers of changes.
+// It relists resources on every resync and after the watch fails, so that the cache eventually converges to the store
+type Informer struct {
+	store    api.Store
+	resource string
+	opts     InformerOptions
+	cache    *Cache
+	handlers []ResourceEventHandler
+	synced   chan struct{}
+	mu       sync.Mutex
+}
+
+func NewInformer(store api.Store, resource string, opts InformerOptions) (*Informer, error) {
+	if _, err := api.ParseSelectors(opts.Selectors); err != nil {
+		return nil, err
+	}
+	return &Informer{
+		store:    store,
+		resource: resource,
+		opts:     opts,
+		cache:    newCache(opts.Indexers),
+		synced:   make(chan struct{}),
+	}, nil
+}
+
+// Cache returns the cache, that is empty until the informer has synced
+func (i *Informer) Cache() *Cache {
+	return i.cache
+}
+
+// AddEventHandler adds the handler. Handlers must be added before Run
+func (i *Informer) AddEventHandler(handler ResourceEventHandler) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.handlers = append(i.handlers, handler)
+}
+
+// HasSynced returns true once the initial list of resources has been cached
+func (i *Informer) HasSynced() bool {
+	select {
+	case <-i.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForSync blocks until the informer has synced, and returns false if the context is canceled before that
+func (i *Informer) WaitForSync(ctx context.Context) bool {
+	select {
+	case <-i.synced:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Run lists and watches resources until the context is canceled
+func (i *Informer) Run(ctx context.Context) {
+	for {
+		if err := i.listAndWatch(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "failed watching %s. relisting in %s: %v\n", i.resource, relistInterval, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(relistInterval):
+		}
+	}
+}
+
+func (i *Informer) listAndWatch(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Start watching before listing so that we won't miss changes made in between.
+	// Events for resources older than the cached ones are ignored, including ones for the resources listed by the watch itself
+	events, errs := i.store.Watch(ctx, i.resource, "", i.opts.Selectors, api.WatchOptions{Checkpoint: i.opts.Checkpoint})
+
+	if err := i.relist(ctx, false); err != nil {
+		return err
+	}
+	select {
+	case <-i.synced:
+	default:
+		close(i.synced)
+	}
+
+	var resync <-chan time.Time
+	if i.opts.ResyncPeriod > 0 {
+		tick := time.NewTicker(i.opts.ResyncPeriod)
+		defer tick.Stop()
+		resync = tick.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-resync:
+			if err := i.relist(ctx, true); err != nil {
+				return err
+			}
+		case err, ok := <-errs:
+			if ok {
+				return err
+			}
+			errs = nil
+		case ev, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("watch stopped unexpectedly")
+			}
+			if err := i.handleEvent(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// relist replaces the cache with the latest resources. Unchanged resources are redelivered to handlers on resync
+func (i *Informer) relist(ctx context.Context, resync bool) error {
+	resources, err := i.store.GetSync(ctx, i.resource, "", i.opts.Selectors)
+	if err != nil {
+		return err
+	}
+	listed := map[string]struct{}{}
+	for _, r := range resources {
+		listed[r.Metadata.Name] = struct{}{}
+		old, exists := i.cache.get(r.Metadata.Name)
+		if exists && !isNewer(r, old) {
+			if resync {
+				i.notifyUpdate(old, old)
+			}
+			continue
+		}
+		if err := i.put(old, r); err != nil {
+			return err
+		}
+	}
+	for _, name := range i.cache.names() {
+		if _, ok := listed[name]; ok {
+			continue
+		}
+		if old, exists := i.cache.get(name); exists {
+			i.cache.delete(name)
+			i.notifyDelete(old)
+		}
+	}
+	return nil
+}
+
+func (i *Informer) handleEvent(ev *api.WatchEvent) error {
+	name := ev.Object.Metadata.Name
+	old, exists := i.cache.get(name)
+	switch ev.Type {
+	case api.Added, api.Modified:
+		if exists && !isNewer(ev.Object, old) {
+			return nil
+		}
+		return i.put(old, ev.Object)
+	case api.Deleted:
+		// The deleted resource may have only its name, when the backend is unable to tell more about it
+		if !exists || (!ev.Object.Metadata.CreationTimestamp.IsZero() && isNewer(old, ev.Object)) {
+			return nil
+		}
+		i.cache.delete(name)
+		i.notifyDelete(old)
+		return nil
+	default:
+		return fmt.Errorf("unexpected watch event type: %s", ev.Type)
+	}
+}
+
+// put caches the resource, and notifies handlers of it as added when `old` is nil, or as updated otherwise
+func (i *Informer) put(old, resource *api.Resource) error {
+	if err := i.cache.put(resource.DeepCopy()); err != nil {
+		return err
+	}
+	if old == nil {
+		i.notifyAdd(resource)
+	} else {
+		i.notifyUpdate(old, resource)
+	}
+	return nil
+}
+
+func (i *Informer) notifyAdd(obj *api.Resource) {
+	for _, h := range i.eventHandlers() {
+		h.OnAdd(obj.DeepCopy())
+	}
+}
+
+func (i *Informer) notifyUpdate(oldObj, newObj *api.Resource) {
+	for _, h := range i.eventHandlers() {
+		h.OnUpdate(oldObj.DeepCopy(), newObj.DeepCopy())
+	}
+}
+
+func (i *Informer) notifyDelete(obj *api.Resource) {
+	for _, h := range i.eventHandlers() {
+		h.OnDelete(obj.DeepCopy())
+	}
+}
+
+func (i *Informer) eventHandlers() []ResourceEventHandler {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]ResourceEventHandler{}, i.handlers...)
+}
+
+// isNewer returns true when `r` is a later write than `than`.
+// A recreated resource is distinguished from the deleted one by its creation timestamp, as its resourceVersion restarts from 1
+func isNewer(r, than *api.Resource) bool {
+	if !r.Metadata.CreationTimestamp.Equal(than.Metadata.CreationTimestamp) {
+		return r.Metadata.CreationTimestamp.After(than.Metadata.CreationTimestamp)
+	}
+	return r.Metadata.ResourceVersion > than.Metadata.ResourceVersion
+}
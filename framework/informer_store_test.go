@@ -0,0 +1,196 @@
+package framework_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	_ "github.com/mumoshu/division/memory"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newMemoryStore returns the store backed by the new memory database prefixed with `name`, that has deployments defined
+func newMemoryStore(t *testing.T, name string) api.Store {
+	name = fmt.Sprintf("%s-%d", name, time.Now().UnixNano())
+	config := &api.Config{
+		Kind:     "Config",
+		Metadata: api.Metadata{Name: name},
+		Spec: api.ConfigSpec{
+			Backend: "memory://" + name,
+			CustomResourceDefinitions: []api.CustomResourceDefinition{
+				{
+					Kind:     api.CustomResourceDefinitionKind,
+					Metadata: api.Metadata{Name: "deployment"},
+					Spec: api.CustomResourceDefinitionSpec{
+						Names: api.CustomResourceDefinitionNames{Kind: "Deployment"},
+					},
+				},
+			},
+		},
+	}
+	db, _, err := framework.NewStoreFromConfig(config, "production")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return db
+}
+
+func newDeployment(name, team string) *api.Resource {
+	return &api.Resource{
+		Kind: "Deployment",
+		Metadata: api.Metadata{
+			Name:   name,
+			Labels: map[string]string{"team": team},
+		},
+		Spec: map[string]interface{}{"app": name},
+	}
+}
+
+// notifications sends notifications of the informer like "update foo 1->2"
+func notifications(i *framework.Informer) <-chan string {
+	ch := make(chan string, 100)
+	i.AddEventHandler(framework.ResourceEventHandlerFuncs{
+		AddFunc: func(obj *api.Resource) {
+			ch <- fmt.Sprintf("add %s %d", obj.Metadata.Name, obj.Metadata.ResourceVersion)
+		},
+		UpdateFunc: func(oldObj, newObj *api.Resource) {
+			ch <- fmt.Sprintf("update %s %d->%d", newObj.Metadata.Name, oldObj.Metadata.ResourceVersion, newObj.Metadata.ResourceVersion)
+		},
+		DeleteFunc: func(obj *api.Resource) {
+			ch <- fmt.Sprintf("delete %s %d", obj.Metadata.Name, obj.Metadata.ResourceVersion)
+		},
+	})
+	return ch
+}
+
+func expectNotification(t *testing.T, ch <-chan string, expected string) {
+	select {
+	case actual := <-ch:
+		if actual != expected {
+			t.Errorf("unexpected notification: expected=%s, actual=%s", expected, actual)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for notification: %s", expected)
+	}
+}
+
+func teamOf(t *testing.T, c *framework.Cache, team string) []string {
+	rs, err := c.ByIndex("team", team)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, r := range rs {
+		names = append(names, r.Metadata.Name)
+	}
+	return names
+}
+
+func TestInformerListsAndWatches(t *testing.T) {
+	db := newMemoryStore(t, "informer-test-watch")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Resources written before the informer starts are listed
+	if err := db.Apply(ctx, newDeployment("foo", "frontend")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := db.Apply(ctx, newDeployment("other", "backend")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	i, err := framework.NewInformer(db, "deployment", framework.InformerOptions{
+		Selectors: []string{"team in (frontend,infra)"},
+		Indexers:  framework.Indexers{"team": framework.LabelIndexFunc("team")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ch := notifications(i)
+	go i.Run(ctx)
+	if !i.WaitForSync(ctx) {
+		t.Fatalf("the informer hasn't synced")
+	}
+	expectNotification(t, ch, "add foo 1")
+
+	testcases := []struct {
+		name     string
+		write    func() error
+		expected string
+		frontend []string
+		infra    []string
+	}{
+		{
+			name:     "created",
+			write:    func() error { return db.Apply(ctx, newDeployment("bar", "frontend")) },
+			expected: "add bar 1",
+			frontend: []string{"bar", "foo"},
+			infra:    []string{},
+		},
+		{
+			name:     "relabeled",
+			write:    func() error { return db.Apply(ctx, newDeployment("foo", "infra")) },
+			expected: "update foo 1->2",
+			frontend: []string{"bar"},
+			infra:    []string{"foo"},
+		},
+		{
+			name:     "deleted",
+			write:    func() error { return db.Delete(ctx, "deployment", "bar") },
+			expected: "delete bar 1",
+			frontend: []string{},
+			infra:    []string{"foo"},
+		},
+		{
+			// Resources no longer matching the selector are deleted from the cache
+			name:     "unselected",
+			write:    func() error { return db.Apply(ctx, newDeployment("foo", "backend")) },
+			expected: "delete foo 2",
+			frontend: []string{},
+			infra:    []string{},
+		},
+	}
+
+	for _, tc := range testcases {
+		if err := tc.write(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		expectNotification(t, ch, tc.expected)
+		if actual := teamOf(t, i.Cache(), "frontend"); !reflect.DeepEqual(actual, tc.frontend) {
+			t.Errorf("%s: unexpected frontend deployments: expected=%v, actual=%v", tc.name, tc.frontend, actual)
+		}
+		if actual := teamOf(t, i.Cache(), "infra"); !reflect.DeepEqual(actual, tc.infra) {
+			t.Errorf("%s: unexpected infra deployments: expected=%v, actual=%v", tc.name, tc.infra, actual)
+		}
+	}
+
+	select {
+	case n := <-ch:
+		t.Errorf("unexpected notification: %s", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestInformerResyncs(t *testing.T) {
+	db := newMemoryStore(t, "informer-test-resync")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := db.Apply(ctx, newDeployment("foo", "frontend")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	i, err := framework.NewInformer(db, "deployment", framework.InformerOptions{ResyncPeriod: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ch := notifications(i)
+	go i.Run(ctx)
+	expectNotification(t, ch, "add foo 1")
+
+	// Unchanged resources are redelivered as updates on every resync
+	expectNotification(t, ch, "update foo 1->1")
+	expectNotification(t, ch, "update foo 1->1")
+}
@@ -0,0 +1,139 @@
+package framework
+
+import (
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingHandler records notifications like "update foo 1->2"
+type recordingHandler struct {
+	events []string
+}
+
+func (h *recordingHandler) OnAdd(obj *api.Resource) {
+	h.events = append(h.events, fmt.Sprintf("add %s %d", obj.Metadata.Name, obj.Metadata.ResourceVersion))
+}
+
+func (h *recordingHandler) OnUpdate(oldObj, newObj *api.Resource) {
+	h.events = append(h.events, fmt.Sprintf("update %s %d->%d", newObj.Metadata.Name, oldObj.Metadata.ResourceVersion, newObj.Metadata.ResourceVersion))
+}
+
+func (h *recordingHandler) OnDelete(obj *api.Resource) {
+	h.events = append(h.events, fmt.Sprintf("delete %s %d", obj.Metadata.Name, obj.Metadata.ResourceVersion))
+}
+
+func TestInformerHandleEvent(t *testing.T) {
+	created := time.Now()
+	recreated := created.Add(time.Second)
+	foo := func(rv int64, created time.Time) *api.Resource {
+		return newCachedResource("foo", rv, created, map[string]string{"rv": fmt.Sprint(rv)}, nil)
+	}
+	// nameOnly is the deleted resource the backend knows only the name of
+	nameOnly := &api.Resource{Metadata: api.Metadata{Name: "foo"}}
+
+	testcases := []struct {
+		name     string
+		events   []*api.WatchEvent
+		expected []string
+		// cached is the resourceVersion of the cached resource, or 0 when it isn't cached
+		cached int64
+	}{
+		{
+			name: "in order",
+			events: []*api.WatchEvent{
+				{Type: api.Added, Object: foo(1, created)},
+				{Type: api.Modified, Object: foo(2, created)},
+			},
+			expected: []string{"add foo 1", "update foo 1->2"},
+			cached:   2,
+		},
+		{
+			// Events for resources older than the cached ones are ignored, like the ones for the resources already listed
+			name: "out of order",
+			events: []*api.WatchEvent{
+				{Type: api.Modified, Object: foo(3, created)},
+				{Type: api.Modified, Object: foo(2, created)},
+				{Type: api.Added, Object: foo(3, created)},
+			},
+			expected: []string{"add foo 3"},
+			cached:   3,
+		},
+		{
+			name: "deleted",
+			events: []*api.WatchEvent{
+				{Type: api.Added, Object: foo(1, created)},
+				{Type: api.Deleted, Object: foo(1, created)},
+			},
+			expected: []string{"add foo 1", "delete foo 1"},
+		},
+		{
+			name: "deleted with the name only",
+			events: []*api.WatchEvent{
+				{Type: api.Added, Object: foo(2, created)},
+				{Type: api.Deleted, Object: nameOnly},
+			},
+			expected: []string{"add foo 2", "delete foo 2"},
+		},
+		{
+			name: "deleted before cached",
+			events: []*api.WatchEvent{
+				{Type: api.Deleted, Object: foo(1, created)},
+			},
+			expected: []string{},
+		},
+		{
+			// The deletion of the previous resource arrives after the recreated one
+			name: "deleted after recreated",
+			events: []*api.WatchEvent{
+				{Type: api.Added, Object: foo(1, recreated)},
+				{Type: api.Deleted, Object: foo(4, created)},
+			},
+			expected: []string{"add foo 1"},
+			cached:   1,
+		},
+		{
+			name: "recreated",
+			events: []*api.WatchEvent{
+				{Type: api.Added, Object: foo(4, created)},
+				{Type: api.Added, Object: foo(1, recreated)},
+			},
+			expected: []string{"add foo 4", "update foo 4->1"},
+			cached:   1,
+		},
+	}
+
+	for _, tc := range testcases {
+		i, err := NewInformer(nil, "deployment", InformerOptions{Indexers: Indexers{"rv": LabelIndexFunc("rv")}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		h := &recordingHandler{events: []string{}}
+		i.AddEventHandler(h)
+		for _, ev := range tc.events {
+			if err := i.handleEvent(ev); err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+		}
+		if !reflect.DeepEqual(h.events, tc.expected) {
+			t.Errorf("%s: unexpected notifications: expected=%v, actual=%v", tc.name, tc.expected, h.events)
+		}
+		r, ok := i.Cache().Get("foo")
+		if tc.cached == 0 {
+			if ok {
+				t.Errorf("%s: unexpected resource cached: %+v", tc.name, r.Metadata)
+			}
+			continue
+		}
+		if !ok || r.Metadata.ResourceVersion != tc.cached {
+			t.Errorf("%s: unexpected resource cached: expected=%d, actual=%v", tc.name, tc.cached, r)
+			continue
+		}
+		// The index follows the cached resource
+		if rs, _ := i.Cache().ByIndex("rv", fmt.Sprint(tc.cached)); !reflect.DeepEqual(namesOf(rs), []string{"foo"}) {
+			t.Errorf("%s: unexpected resources in the index: %v", tc.name, namesOf(rs))
+		}
+	}
+}
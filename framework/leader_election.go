@@ -0,0 +1,147 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"os"
+	"time"
+)
+
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+type LeaderElectionConfig struct {
+	// Lease is the name of the lease shared among candidates
+	Lease string
+	// Identity is the name of this candidate, that must be unique among candidates
+	Identity string
+	// LeaseDuration is how long the other candidates wait before taking over the lease the leader has stopped renewing
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps retrying to renew the lease before giving up the leadership
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval between tries to acquire or renew the lease
+	RetryPeriod time.Duration
+}
+
+// LeaderElector runs a function on only one of candidates at a time, by letting candidates compete for the lease in the store
+type LeaderElector struct {
+	store  api.Store
+	config LeaderElectionConfig
+}
+
+func NewLeaderElector(store api.Store, config LeaderElectionConfig) (*LeaderElector, error) {
+	if config.Lease == "" {
+		return nil, fmt.Errorf("missing lease name")
+	}
+	if config.Identity == "" {
+		return nil, fmt.Errorf("missing identity of the candidate")
+	}
+	if config.LeaseDuration <= config.RenewDeadline {
+		return nil, fmt.Errorf("lease duration %s must be greater than renew deadline %s", config.LeaseDuration, config.RenewDeadline)
+	}
+	if config.RenewDeadline <= config.RetryPeriod {
+		return nil, fmt.Errorf("renew deadline %s must be greater than retry period %s", config.RenewDeadline, config.RetryPeriod)
+	}
+	return &LeaderElector{
+		store:  store,
+		config: config,
+	}, nil
+}
+
+// Run blocks until this candidate becomes the leader, and then calls `lead` with the context that is canceled once the leadership is lost.
+// It returns nil after `lead` returns on its own or the context is canceled, releasing the lease for other candidates.
+// It returns an error as soon as the leadership is lost, canceling the context of `lead` without waiting for it to return.
+// The caller should exit then, as `lead` may still be running on both this candidate and the new leader
+func (le *LeaderElector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	if !le.acquire(ctx) {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "\"%s\" acquired lease \"%s\". started leading\n", le.config.Identity, le.config.Lease)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leaderCtx)
+	}()
+
+	if err := le.renew(leaderCtx, done); err != nil {
+		// Return without waiting for `lead`, that may be blocked on e.g. a script that can't be canceled
+		return err
+	}
+	cancel()
+	<-done
+
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), le.config.RenewDeadline)
+	defer cancelRelease()
+	if err := le.store.ReleaseLease(releaseCtx, le.config.Lease, le.config.Identity); err != nil {
+		fmt.Fprintf(os.Stderr, "failed releasing lease \"%s\": %v\n", le.config.Lease, err)
+	}
+	return nil
+}
+
+// acquire retries acquiring the lease until it succeeds, and returns false if the context is canceled before that
+func (le *LeaderElector) acquire(ctx context.Context) bool {
+	waiting := false
+	for {
+		_, err := le.store.AcquireLease(ctx, le.config.Lease, le.config.Identity, le.config.LeaseDuration)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		// Avoid flooding logs while the other candidate keeps renewing the lease
+		if _, ok := err.(*api.ErrConflict); ok {
+			if !waiting {
+				fmt.Fprintf(os.Stderr, "waiting for lease: %v\n", err)
+			}
+			waiting = true
+		} else {
+			fmt.Fprintf(os.Stderr, "failed acquiring lease \"%s\". retrying: %v\n", le.config.Lease, err)
+			waiting = false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(le.config.RetryPeriod):
+		}
+	}
+}
+
+// renew keeps renewing the lease until the context is canceled or `done` is closed.
+// It returns an error when the lease has been taken by another candidate, or it isn't renewed within the renew deadline
+func (le *LeaderElector) renew(ctx context.Context, done <-chan struct{}) error {
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case <-time.After(le.config.RetryPeriod):
+		}
+		renewCtx, cancel := context.WithTimeout(ctx, le.config.RenewDeadline-time.Since(renewed))
+		_, err := le.store.RenewLease(renewCtx, le.config.Lease, le.config.Identity, le.config.LeaseDuration)
+		cancel()
+		if err == nil {
+			renewed = time.Now()
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if _, ok := err.(*api.ErrConflict); ok {
+			return fmt.Errorf("lost lease \"%s\": %v", le.config.Lease, err)
+		}
+		if time.Since(renewed) >= le.config.RenewDeadline {
+			return fmt.Errorf("lost lease \"%s\": failed renewing it within %s: %v", le.config.Lease, le.config.RenewDeadline, err)
+		}
+		fmt.Fprintf(os.Stderr, "failed renewing lease \"%s\". retrying: %v\n", le.config.Lease, err)
+	}
+}
@@ -0,0 +1,99 @@
+package framework
+
+import (
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"math"
+	"time"
+)
+
+// PrepareLeaseAcquire returns the lease to be written for the holder acquiring or renewing it.
+// `existing` is nil when the lease doesn't exist yet.
+// Like PrepareWrite, backends should call this and then write the lease atomically, so that only one of concurrent holders wins
+func PrepareLeaseAcquire(existing *api.Resource, name, holder string, duration time.Duration) (*api.Resource, error) {
+	now := time.Now()
+	spec := api.LeaseSpec{}
+	if existing != nil {
+		s, err := api.LeaseSpecOf(existing)
+		if err != nil {
+			return nil, err
+		}
+		spec = *s
+	}
+	if spec.HolderIdentity != holder {
+		if !spec.Expired(now) {
+			return nil, api.NewErrConflict(fmt.Sprintf(`lease "%s" is held by "%s" until %s`, name, spec.HolderIdentity, expiry(&spec).Format(time.RFC3339)))
+		}
+		if existing != nil {
+			spec.LeaseTransitions++
+		}
+		spec.HolderIdentity = holder
+		spec.AcquireTime = now
+	}
+	spec.LeaseDurationSeconds = durationSeconds(duration)
+	spec.RenewTime = now
+	return newLeaseFor(existing, name, spec)
+}
+
+// PrepareLeaseRenew returns the lease to be written for the holder renewing it.
+// It fails with ErrConflict when the holder no longer has the lease, even if nobody else has acquired it
+func PrepareLeaseRenew(existing *api.Resource, name, holder string, duration time.Duration) (*api.Resource, error) {
+	if err := checkLeaseHolder(existing, name, holder); err != nil {
+		return nil, err
+	}
+	return PrepareLeaseAcquire(existing, name, holder, duration)
+}
+
+// PrepareLeaseRelease returns the lease to be written for the holder releasing it
+func PrepareLeaseRelease(existing *api.Resource, name, holder string) (*api.Resource, error) {
+	if err := checkLeaseHolder(existing, name, holder); err != nil {
+		return nil, err
+	}
+	spec, err := api.LeaseSpecOf(existing)
+	if err != nil {
+		return nil, err
+	}
+	spec.HolderIdentity = ""
+	return newLeaseFor(existing, name, *spec)
+}
+
+func checkLeaseHolder(existing *api.Resource, name, holder string) error {
+	if existing == nil {
+		return api.NewErrConflict(fmt.Sprintf(`lease "%s" is not held by "%s": it does not exist`, name, holder))
+	}
+	spec, err := api.LeaseSpecOf(existing)
+	if err != nil {
+		return err
+	}
+	if spec.HolderIdentity != holder {
+		return api.NewErrConflict(fmt.Sprintf(`lease "%s" is not held by "%s": it is held by "%s"`, name, holder, spec.HolderIdentity))
+	}
+	if spec.Expired(time.Now()) {
+		return api.NewErrConflict(fmt.Sprintf(`lease "%s" held by "%s" has expired at %s`, name, holder, expiry(spec).Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// newLeaseFor returns the lease with the spec, that is to be written only when the lease is still the `existing` one
+func newLeaseFor(existing *api.Resource, name string, spec api.LeaseSpec) (*api.Resource, error) {
+	lease, err := api.NewLease(name, spec)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	lease.Metadata.CreationTimestamp = now
+	lease.Metadata.UpdateTimestamp = now
+	if existing != nil {
+		lease.Metadata.Labels = existing.Metadata.Labels
+		lease.Metadata.ResourceVersion = existing.Metadata.ResourceVersion
+	}
+	return lease, nil
+}
+
+func expiry(spec *api.LeaseSpec) time.Time {
+	return spec.RenewTime.Add(time.Duration(spec.LeaseDurationSeconds) * time.Second)
+}
+
+func durationSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
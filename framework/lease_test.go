@@ -0,0 +1,185 @@
+package framework
+
+import (
+	"github.com/mumoshu/division/api"
+	"testing"
+	"time"
+)
+
+func newLease(t *testing.T, holder string, renewed time.Time, transitions int) *api.Resource {
+	lease, err := api.NewLease("gateway", api.LeaseSpec{
+		HolderIdentity:       holder,
+		LeaseDurationSeconds: 15,
+		AcquireTime:          renewed,
+		RenewTime:            renewed,
+		LeaseTransitions:     transitions,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lease.Metadata.ResourceVersion = 7
+	return lease
+}
+
+func TestPrepareLease(t *testing.T) {
+	now := time.Now()
+	held := now.Add(-5 * time.Second)
+	expired := now.Add(-time.Minute)
+
+	type prepareFunc func(existing *api.Resource) (*api.Resource, error)
+	acquire := func(holder string) prepareFunc {
+		return func(existing *api.Resource) (*api.Resource, error) {
+			return PrepareLeaseAcquire(existing, "gateway", holder, 15*time.Second)
+		}
+	}
+	renew := func(holder string) prepareFunc {
+		return func(existing *api.Resource) (*api.Resource, error) {
+			return PrepareLeaseRenew(existing, "gateway", holder, 15*time.Second)
+		}
+	}
+	release := func(holder string) prepareFunc {
+		return func(existing *api.Resource) (*api.Resource, error) {
+			return PrepareLeaseRelease(existing, "gateway", holder)
+		}
+	}
+
+	testcases := []struct {
+		name        string
+		existing    *api.Resource
+		prepare     prepareFunc
+		conflict    bool
+		holder      string
+		transitions int
+		acquired    bool
+	}{
+		{
+			name:     "acquire new",
+			prepare:  acquire("a"),
+			holder:   "a",
+			acquired: true,
+		},
+		{
+			name:     "acquire held by another",
+			existing: newLease(t, "b", held, 1),
+			prepare:  acquire("a"),
+			conflict: true,
+		},
+		{
+			name:        "acquire expired",
+			existing:    newLease(t, "b", expired, 1),
+			prepare:     acquire("a"),
+			holder:      "a",
+			transitions: 2,
+			acquired:    true,
+		},
+		{
+			name:        "acquire released",
+			existing:    newLease(t, "", held, 1),
+			prepare:     acquire("a"),
+			holder:      "a",
+			transitions: 2,
+			acquired:    true,
+		},
+		{
+			name:        "acquire held by itself",
+			existing:    newLease(t, "a", held, 1),
+			prepare:     acquire("a"),
+			holder:      "a",
+			transitions: 1,
+		},
+		{
+			name:        "renew",
+			existing:    newLease(t, "a", held, 1),
+			prepare:     renew("a"),
+			holder:      "a",
+			transitions: 1,
+		},
+		{
+			name:     "renew missing",
+			prepare:  renew("a"),
+			conflict: true,
+		},
+		{
+			name:     "renew expired",
+			existing: newLease(t, "a", expired, 1),
+			prepare:  renew("a"),
+			conflict: true,
+		},
+		{
+			name:     "renew held by another",
+			existing: newLease(t, "b", held, 1),
+			prepare:  renew("a"),
+			conflict: true,
+		},
+		{
+			name:        "release",
+			existing:    newLease(t, "a", held, 1),
+			prepare:     release("a"),
+			holder:      "",
+			transitions: 1,
+		},
+		{
+			name:     "release held by another",
+			existing: newLease(t, "b", held, 1),
+			prepare:  release("a"),
+			conflict: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		lease, err := tc.prepare(tc.existing)
+		if tc.conflict {
+			if _, ok := err.(*api.ErrConflict); !ok {
+				t.Errorf("%s: expected ErrConflict, but got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		spec, err := api.LeaseSpecOf(lease)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if spec.HolderIdentity != tc.holder {
+			t.Errorf("%s: unexpected holder: expected=%s, actual=%s", tc.name, tc.holder, spec.HolderIdentity)
+		}
+		if spec.LeaseTransitions != tc.transitions {
+			t.Errorf("%s: unexpected transitions: expected=%d, actual=%d", tc.name, tc.transitions, spec.LeaseTransitions)
+		}
+		if acquired := !spec.AcquireTime.Before(now); acquired != tc.acquired {
+			t.Errorf("%s: unexpected acquireTime: %v", tc.name, spec.AcquireTime)
+		}
+		// The lease is written only when it's still the existing one
+		if tc.existing != nil && lease.Metadata.ResourceVersion != tc.existing.Metadata.ResourceVersion {
+			t.Errorf("%s: unexpected resourceVersion: %d", tc.name, lease.Metadata.ResourceVersion)
+		}
+		if tc.holder != "" && spec.Expired(now) {
+			t.Errorf("%s: the lease has expired: %+v", tc.name, spec)
+		}
+	}
+}
+
+func TestLeaseSpecExpired(t *testing.T) {
+	renewed := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testcases := []struct {
+		holder   string
+		now      time.Time
+		expected bool
+	}{
+		{holder: "a", now: renewed, expected: false},
+		{holder: "a", now: renewed.Add(15 * time.Second), expected: false},
+		{holder: "a", now: renewed.Add(16 * time.Second), expected: true},
+		{holder: "", now: renewed, expected: true},
+	}
+
+	for i, tc := range testcases {
+		spec := api.LeaseSpec{HolderIdentity: tc.holder, LeaseDurationSeconds: 15, RenewTime: renewed}
+		if actual := spec.Expired(tc.now); actual != tc.expected {
+			t.Errorf("case %d: unexpected result at %v: expected=%v, actual=%v", i, tc.now, tc.expected, actual)
+		}
+	}
+}
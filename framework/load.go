@@ -0,0 +1,161 @@
+package framework
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/mumoshu/division/api"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// readFile reads the whole file, or stdin when the file is "-"
+func readFile(file string) ([]byte, error) {
+	if file == "-" {
+		bytes, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "read %d bytes from stdin\n", len(bytes))
+		return bytes, nil
+	}
+	return ioutil.ReadFile(file)
+}
+
+// LoadResourcesFromYaml loads resources from `---` separated YAML documents.
+// Each document is either a resource, or a list of resources of kind `list` like the one emitted by `div get -o json`
+func LoadResourcesFromYaml(data []byte) ([]*api.Resource, error) {
+	resources := []*api.Resource{}
+	for i, doc := range documentSeparator.Split(string(data), -1) {
+		raw, err := yaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+		// Skip documents containing only whitespaces and comments
+		if raw = bytes.TrimSpace(raw); len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+		var kind struct {
+			Kind string `json:"kind"`
+		}
+		if err := yaml.Unmarshal(raw, &kind); err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+		var items []*api.Resource
+		if strings.ToLower(kind.Kind) == "list" {
+			var list api.List
+			if err := yaml.Unmarshal(raw, &list); err != nil {
+				return nil, fmt.Errorf("document %d: %v", i, err)
+			}
+			for j := range list.Items {
+				items = append(items, &list.Items[j])
+			}
+		} else {
+			r, err := LoadResourceFromYaml(raw)
+			if err != nil {
+				return nil, fmt.Errorf("document %d: %v", i, err)
+			}
+			items = append(items, r)
+		}
+		for _, r := range items {
+			if r.Metadata.Name == "" {
+				return nil, fmt.Errorf("document %d: %s has no metadata.name", i, r.Kind)
+			}
+			r.NameHashKey = r.Metadata.Name
+			resources = append(resources, r)
+		}
+	}
+	return resources, nil
+}
+
+func isManifest(path string) bool {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// LoadResourcesFromFile loads resources from the file, stdin when the file is "-", or `.yaml`, `.yml` and `.json` files in the directory.
+// Sub-directories are read only when `recursive` is true
+func LoadResourcesFromFile(file string, recursive bool) ([]*api.Resource, error) {
+	if file != "-" {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return loadResourcesFromDir(file, recursive)
+		}
+	}
+	raw, err := readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := LoadResourcesFromYaml(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", file, err)
+	}
+	return resources, nil
+}
+
+func loadResourcesFromDir(dir string, recursive bool) ([]*api.Resource, error) {
+	resources := []*api.Resource{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isManifest(path) {
+			return nil
+		}
+		rs, err := LoadResourcesFromFile(path, false)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, rs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// SortResourcesForApply sorts resources so that custom resource definitions are applied before custom resources.
+// The order of resources is preserved otherwise
+func SortResourcesForApply(resources []*api.Resource) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Kind == api.CustomResourceDefinitionKind && resources[j].Kind != api.CustomResourceDefinitionKind
+	})
+}
+
+// ApplyFile applies all the resources loaded from the file, stdin or the directory to the store, custom resource definitions first
+func ApplyFile(ctx context.Context, store api.Store, file string, recursive bool) error {
+	resources, err := LoadResourcesFromFile(file, recursive)
+	if err != nil {
+		return err
+	}
+	if len(resources) == 0 {
+		return fmt.Errorf("no resources found in %s", file)
+	}
+	SortResourcesForApply(resources)
+	for _, r := range resources {
+		if err := store.Apply(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package framework
+
+import (
+	"fmt"
+	"github.com/elgs/jsonql"
+	"github.com/mumoshu/division/api"
+)
+
+// Match returns true when the resource matches the jsonql query.
+// The resource whose status is stale never matches, so that a query on the status won't be satisfied by the status for the previous spec
+func Match(resource api.Resource, query string) (bool, error) {
+	if !resource.Observed() {
+		return false, nil
+	}
+
+	r := resource.Format("json")
+
+	stringQuery, err := jsonql.NewStringQuery(r)
+	if err != nil {
+		return false, err
+	}
+
+	ret, err := stringQuery.Query(query)
+	if err != nil {
+		return false, err
+	}
+
+	switch ret.(type) {
+	case map[string]interface{}:
+		return true, nil
+	case nil:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected type of jsonql query result")
+	}
+}
+
+// QueryCondition returns the wait condition satisfied when the resource matches the jsonql query
+func QueryCondition(query string) api.WaitCondition {
+	return func(resource *api.Resource) (bool, error) {
+		return Match(*resource, query)
+	}
+}
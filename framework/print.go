@@ -0,0 +1,38 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+)
+
+// PrintStreamedResourcesSync writes resources received from the channel to stdout until the context is canceled, the channels are closed or an error is received.
+// When `wait` is true, resources are written at once after the channel is closed
+func PrintStreamedResourcesSync(ctx context.Context, ch <-chan *api.Resource, errCh <-chan error, output string, wait bool) error {
+	rs := []api.Resource{}
+	for ch != nil || errCh != nil {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-errCh:
+			if ok {
+				return fmt.Errorf("stream error: %v", err)
+			}
+			errCh = nil
+		case resource, ok := <-ch:
+			if !ok {
+				ch = nil
+				continue
+			}
+			if wait {
+				rs = append(rs, *resource)
+			} else {
+				WriteToStdout(resource.Format(output))
+			}
+		}
+	}
+	if wait {
+		WriteToStdout(api.Resources(rs).Format(output))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"sort"
+	"strings"
+)
+
+const (
+	// AllCategory is the category every custom resource belongs to
+	AllCategory = "all"
+)
+
+// builtinDefinitions are the definitions of resources every store has
+var builtinDefinitions = []api.CustomResourceDefinition{
+	{
+		Kind:     api.CustomResourceDefinitionKind,
+		Metadata: api.Metadata{Name: api.CustomResourceDefinitionResource},
+		Spec: api.CustomResourceDefinitionSpec{
+			Scope: api.ClusterScoped,
+			Names: api.CustomResourceDefinitionNames{
+				Kind:       api.CustomResourceDefinitionKind,
+				ShortNames: []string{"crd", "crds"},
+			},
+		},
+	},
+	{
+		Kind:     api.CustomResourceDefinitionKind,
+		Metadata: api.Metadata{Name: api.LeaseResource},
+		Spec: api.CustomResourceDefinitionSpec{
+			Names: api.CustomResourceDefinitionNames{
+				Kind: api.LeaseKind,
+			},
+		},
+	},
+}
+
+// ResourceResolver resolves the resource type given on the command-line into the name of its definition, that is accepted by stores.
+// The type is either the name, the singular, the plural, a short name or the kind of the definition, in any case
+type ResourceResolver struct {
+	defs []api.CustomResourceDefinition
+}
+
+// NewResourceResolver returns the resolver for the definitions and the built-in ones.
+// The first definition wins when more than one definition has the same name
+func NewResourceResolver(defs []api.CustomResourceDefinition) *ResourceResolver {
+	seen := map[string]bool{}
+	uniq := []api.CustomResourceDefinition{}
+	for _, d := range append(append([]api.CustomResourceDefinition{}, builtinDefinitions...), defs...) {
+		if !seen[d.Metadata.Name] {
+			seen[d.Metadata.Name] = true
+			uniq = append(uniq, d)
+		}
+	}
+	return &ResourceResolver{defs: uniq}
+}
+
+// Resolve returns the definition of the resource type, and the version when the type is suffixed by it like "deployments.v1".
+// The bool is false when no definition matched, or the definition has no such version
+func (r *ResourceResolver) Resolve(resource string) (*api.CustomResourceDefinition, string, bool) {
+	var version string
+	if i := strings.Index(resource, "."); i >= 0 {
+		resource, version = resource[:i], resource[i+1:]
+	}
+	d := r.definition(strings.ToLower(resource))
+	if d == nil || version != "" && d.Spec.Version(version) == nil {
+		return nil, "", false
+	}
+	return d, version, true
+}
+
+func (r *ResourceResolver) definition(resource string) *api.CustomResourceDefinition {
+	// Names take precedence over aliases, so that a definition can't be shadowed by another one's alias
+	for i := range r.defs {
+		if strings.ToLower(r.defs[i].Metadata.Name) == resource {
+			return &r.defs[i]
+		}
+	}
+	for i := range r.defs {
+		for _, n := range resourceNames(r.defs[i]) {
+			if n == resource {
+				return &r.defs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveCategory returns names of definitions in the category, sorted by name. It's empty when no definition is in the category
+func (r *ResourceResolver) ResolveCategory(category string) []string {
+	category = strings.ToLower(category)
+	names := []string{}
+	for _, d := range r.defs[len(builtinDefinitions):] {
+		in := category == AllCategory
+		for _, c := range d.Spec.Names.Categories {
+			in = in || strings.ToLower(c) == category
+		}
+		if in {
+			names = append(names, d.Metadata.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe returns the resource types like "cluster (clusters, cl)" for helping users find the right one
+func (r *ResourceResolver) Describe() []string {
+	descs := []string{}
+	for _, d := range r.defs {
+		aliases := []string{PluralOf(d)}
+		aliases = append(aliases, d.Spec.Names.ShortNames...)
+		descs = append(descs, fmt.Sprintf("%s (%s)", d.Metadata.Name, strings.Join(aliases, ", ")))
+	}
+	sort.Strings(descs)
+	return descs
+}
+
+// PluralOf returns the plural of the resource defined by the definition, that defaults to the plural form of the name
+func PluralOf(d api.CustomResourceDefinition) string {
+	if d.Spec.Names.Plural != "" {
+		return strings.ToLower(d.Spec.Names.Plural)
+	}
+	name := strings.ToLower(d.Metadata.Name)
+	switch {
+	case name == "":
+		return ""
+	case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "ch"), strings.HasSuffix(name, "sh"):
+		return name + "es"
+	case strings.HasSuffix(name, "y") && len(name) > 1 && !strings.ContainsAny(name[len(name)-2:len(name)-1], "aeiou"):
+		return name[:len(name)-1] + "ies"
+	default:
+		return name + "s"
+	}
+}
+
+func resourceNames(d api.CustomResourceDefinition) []string {
+	names := []string{
+		strings.ToLower(d.Metadata.Name),
+		PluralOf(d),
+		strings.ToLower(d.Spec.Names.Kind),
+	}
+	if d.Spec.Names.Singular != "" {
+		names = append(names, strings.ToLower(d.Spec.Names.Singular))
+	}
+	for _, s := range d.Spec.Names.ShortNames {
+		names = append(names, strings.ToLower(s))
+	}
+	return names
+}
+
+// LoadResourceResolver returns the resolver for definitions in the config file and the store
+func LoadResourceResolver(ctx context.Context, store api.Store, configFile string) (*ResourceResolver, error) {
+	config, err := LoadConfigFromYamlFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	crds, err := store.GetCRDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewResourceResolver(append(config.Spec.CustomResourceDefinitions, crds...)), nil
+}
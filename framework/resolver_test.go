@@ -0,0 +1,130 @@
+package framework
+
+import (
+	"github.com/mumoshu/division/api"
+	"reflect"
+	"testing"
+)
+
+func TestPluralOf(t *testing.T) {
+	testcases := []struct {
+		name     string
+		plural   string
+		expected string
+	}{
+		{name: "cluster", expected: "clusters"},
+		{name: "Deployment", expected: "deployments"},
+		{name: "status", expected: "statuses"},
+		{name: "box", expected: "boxes"},
+		{name: "patch", expected: "patches"},
+		{name: "mesh", expected: "meshes"},
+		{name: "policy", expected: "policies"},
+		{name: "gateway", expected: "gateways"},
+		{name: "y", expected: "ys"},
+		{name: "person", plural: "People", expected: "people"},
+		{name: "", expected: ""},
+	}
+
+	for _, tc := range testcases {
+		d := api.CustomResourceDefinition{
+			Metadata: api.Metadata{Name: tc.name},
+			Spec: api.CustomResourceDefinitionSpec{
+				Names: api.CustomResourceDefinitionNames{Plural: tc.plural},
+			},
+		}
+		if actual := PluralOf(d); actual != tc.expected {
+			t.Errorf("unexpected plural of \"%s\": expected=%s, actual=%s", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestResourceResolver(t *testing.T) {
+	r := NewResourceResolver([]api.CustomResourceDefinition{
+		{
+			Metadata: api.Metadata{Name: "cluster"},
+			Spec: api.CustomResourceDefinitionSpec{
+				Names: api.CustomResourceDefinitionNames{
+					Kind:       "Cluster",
+					ShortNames: []string{"cl"},
+					Categories: []string{"infra"},
+				},
+			},
+		},
+		{
+			Metadata: api.Metadata{Name: "deployment"},
+			Spec: api.CustomResourceDefinitionSpec{
+				Names: api.CustomResourceDefinitionNames{
+					Kind:       "Deployment",
+					ShortNames: []string{"deploy"},
+				},
+				Versions: []api.CustomResourceDefinitionVersion{
+					{Name: "v1", Served: true},
+					{Name: "v2", Served: true, Storage: true},
+				},
+			},
+		},
+		{
+			// A definition named like another one's alias is never shadowed by the alias
+			Metadata: api.Metadata{Name: "cl"},
+			Spec: api.CustomResourceDefinitionSpec{
+				Names: api.CustomResourceDefinitionNames{Kind: "Cl"},
+			},
+		},
+		{
+			// The first definition wins
+			Metadata: api.Metadata{Name: "cluster"},
+			Spec: api.CustomResourceDefinitionSpec{
+				Names: api.CustomResourceDefinitionNames{Kind: "Other"},
+			},
+		},
+	})
+
+	testcases := []struct {
+		resource string
+		expected string
+		kind     string
+		version  string
+		ok       bool
+	}{
+		{resource: "cluster", expected: "cluster", kind: "Cluster", ok: true},
+		{resource: "clusters", expected: "cluster", kind: "Cluster", ok: true},
+		{resource: "Cluster", expected: "cluster", kind: "Cluster", ok: true},
+		{resource: "CLUSTERS", expected: "cluster", kind: "Cluster", ok: true},
+		{resource: "cl", expected: "cl", kind: "Cl", ok: true},
+		{resource: "deploy", expected: "deployment", kind: "Deployment", ok: true},
+		{resource: "deployments.v1", expected: "deployment", kind: "Deployment", version: "v1", ok: true},
+		{resource: "deployments.v3"},
+		{resource: "crds", expected: "customresourcedefinition", kind: "CustomResourceDefinition", ok: true},
+		{resource: "lease", expected: "lease", kind: "Lease", ok: true},
+		{resource: "release"},
+	}
+
+	for _, tc := range testcases {
+		d, version, ok := r.Resolve(tc.resource)
+		if ok != tc.ok {
+			t.Errorf("unexpected result for \"%s\": expected=%v, actual=%v", tc.resource, tc.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if d.Metadata.Name != tc.expected || d.Spec.Names.Kind != tc.kind || version != tc.version {
+			t.Errorf("unexpected definition for \"%s\": expected=%s(%s) %s, actual=%s(%s) %s", tc.resource, tc.expected, tc.kind, tc.version, d.Metadata.Name, d.Spec.Names.Kind, version)
+		}
+	}
+
+	categories := []struct {
+		category string
+		expected []string
+	}{
+		{category: "all", expected: []string{"cl", "cluster", "deployment"}},
+		{category: "INFRA", expected: []string{"cluster"}},
+		{category: "none", expected: []string{}},
+	}
+
+	for _, tc := range categories {
+		if actual := r.ResolveCategory(tc.category); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("unexpected names in category \"%s\": expected=%v, actual=%v", tc.category, tc.expected, actual)
+		}
+	}
+}
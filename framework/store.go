@@ -0,0 +1,96 @@
+package framework
+
+import (
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"sort"
+	"strings"
+)
+
+const defaultBackend = "dynamodb://"
+
+type storeProviders map[string]StoreProvider
+
+// StoreProviders is the registry of datastore backends keyed by uri scheme.
+// Each backend pkg registers itself on init, so that importing it is enough to make it selectable via `spec.backend`.
+var StoreProviders storeProviders
+
+// StoreProvider instantiates a store and its log store for the namespace, from the path part of the backend uri
+type StoreProvider func(path string, config *api.Config, namespace string) (api.Store, api.LogStore, error)
+
+func (p storeProviders) Register(scheme string, provider StoreProvider) {
+	if _, exists := p[scheme]; exists {
+		panic(fmt.Errorf(`duplicate store provider for scheme "%s" detected`, scheme))
+	}
+	p[scheme] = provider
+}
+
+func (p storeProviders) schemes() []string {
+	schemes := []string{}
+	for s := range p {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+func init() {
+	StoreProviders = storeProviders{}
+}
+
+// NewStore returns the store selected by the config file, for the namespace
+func NewStore(configFile string, namespace string) (api.Store, error) {
+	store, _, err := newStoreAndLogs(configFile, namespace)
+	return store, err
+}
+
+// NewLogStore returns the log store selected by the config file, for the namespace
+func NewLogStore(configFile string, namespace string) (api.LogStore, error) {
+	_, logs, err := newStoreAndLogs(configFile, namespace)
+	return logs, err
+}
+
+func newStoreAndLogs(configFile string, namespace string) (api.Store, api.LogStore, error) {
+	config, err := LoadConfigFromYamlFile(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewStoreFromConfig(config, namespace)
+}
+
+// NewStoreFromConfig returns the store and the log store for the backend specified in the config
+func NewStoreFromConfig(config *api.Config, namespace string) (api.Store, api.LogStore, error) {
+	backend := backendOf(config)
+	scheme, path, err := splitURI(backend)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`invalid format of backend "%s": it must be formatted "<scheme>://<path>"`, backend)
+	}
+	provider, exists := StoreProviders[scheme]
+	if !exists {
+		return nil, nil, fmt.Errorf(`unexpected scheme "%s" found in backend "%s": it must be one of %v`, scheme, backend, StoreProviders.schemes())
+	}
+	return provider(path, config, namespace)
+}
+
+func backendOf(config *api.Config) string {
+	if config.Spec.Backend != "" {
+		return config.Spec.Backend
+	}
+	if config.Spec.Source != "" {
+		// "file://" in `source` is always a config file rather than the file store
+		if scheme, _, err := splitURI(config.Spec.Source); err == nil && scheme != "file" {
+			if _, exists := StoreProviders[scheme]; exists {
+				return config.Spec.Source
+			}
+		}
+	}
+	return defaultBackend
+}
+
+func splitURI(uri string) (string, string, error) {
+	parts := strings.Split(uri, "://")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf(`invalid uri "%s"`, uri)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,271 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var schemaTypes = map[string]bool{
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"integer": true,
+	"number":  true,
+	"boolean": true,
+}
+
+// ValidateResource validates the resource against the schema of its version, or `validation.openAPIV3Schema` of its definition.
+// Definitions are validated too, so that a malformed schema is rejected before any resource is validated against it.
+// It runs in AdmitResource before the resource is written, so that malformed resources never reach controllers
+func ValidateResource(def *api.CustomResourceDefinition, resource *api.Resource) error {
+	var causes []string
+	if resource.Kind == api.CustomResourceDefinitionKind {
+		causes = validateDefinition(resource)
+	} else if schema := def.Spec.SchemaFor(def.Spec.VersionOf(resource)); schema != nil {
+		obj, err := toJSONValue(resource)
+		if err != nil {
+			return err
+		}
+		causes = validateValue(obj, schema, "")
+	}
+	if len(causes) > 0 {
+		return api.NewErrInvalid(resource.Kind, resource.Metadata.Name, causes)
+	}
+	return nil
+}
+
+// toJSONValue converts the resource into the form read from JSON, so that e.g. an int written by a controller is validated as a float64
+func toJSONValue(resource *api.Resource) (interface{}, error) {
+	raw, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func validateValue(v interface{}, schema *api.JSONSchemaProps, path string) []string {
+	if schema.Type != "" && !hasType(v, schema.Type) {
+		return []string{invalidValue(path, v, fmt.Sprintf("must be of type %s", schema.Type))}
+	}
+	causes := []string{}
+	if len(schema.Enum) > 0 && !inEnum(v, schema.Enum) {
+		causes = append(causes, fmt.Sprintf("%s: Unsupported value: %s: supported values: %s", fieldPath(path), formatValue(v), formatValues(schema.Enum)))
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, ok := t[name]; !ok {
+				causes = append(causes, fmt.Sprintf("%s: Required value", join(path, name)))
+			}
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if fv, ok := t[name]; ok {
+				prop := schema.Properties[name]
+				causes = append(causes, validateValue(fv, &prop, join(path, name))...)
+			}
+		}
+	case []interface{}:
+		if schema.Items != nil {
+			for i, item := range t {
+				causes = append(causes, validateValue(item, schema.Items, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case string:
+		if schema.Pattern != "" {
+			// The pattern has been validated along with the definition
+			if re, err := regexp.Compile(schema.Pattern); err == nil && !re.MatchString(t) {
+				causes = append(causes, invalidValue(path, v, fmt.Sprintf("must match the pattern %s", schema.Pattern)))
+			}
+		}
+		n := int64(utf8.RuneCountInString(t))
+		if schema.MinLength != nil && n < *schema.MinLength {
+			causes = append(causes, invalidValue(path, v, fmt.Sprintf("must be at least %d characters long", *schema.MinLength)))
+		}
+		if schema.MaxLength != nil && n > *schema.MaxLength {
+			causes = append(causes, invalidValue(path, v, fmt.Sprintf("must be at most %d characters long", *schema.MaxLength)))
+		}
+	case float64:
+		if schema.Minimum != nil && t < *schema.Minimum {
+			causes = append(causes, invalidValue(path, v, fmt.Sprintf("must be greater than or equal to %v", *schema.Minimum)))
+		}
+		if schema.Maximum != nil && t > *schema.Maximum {
+			causes = append(causes, invalidValue(path, v, fmt.Sprintf("must be less than or equal to %v", *schema.Maximum)))
+		}
+	}
+	return causes
+}
+
+func hasType(v interface{}, typ string) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return typ == "object"
+	case []interface{}:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || typ == "integer" && t == math.Trunc(t)
+	}
+	return false
+}
+
+func inEnum(v interface{}, enum []interface{}) bool {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	for _, e := range enum {
+		// Compare in JSON, as enum values read from DynamoDB can have different Go types than the ones read from JSON
+		if r, err := json.Marshal(e); err == nil && string(r) == string(raw) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDefinition validates the schema of the definition being applied
+func validateDefinition(resource *api.Resource) []string {
+	raw, err := json.Marshal(resource.Spec)
+	if err != nil {
+		return []string{fmt.Sprintf("spec: Invalid value: %v", err)}
+	}
+	spec := api.CustomResourceDefinitionSpec{}
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return []string{fmt.Sprintf("spec: Invalid value: %v", err)}
+	}
+	causes := []string{}
+	if spec.Names.Kind == "" {
+		causes = append(causes, "spec.names.kind: Required value")
+	}
+	switch spec.Scope {
+	case "", api.NamespaceScoped, api.ClusterScoped:
+	default:
+		causes = append(causes, fmt.Sprintf(`spec.scope: Unsupported value: "%s": supported values: "%s", "%s"`, spec.Scope, api.NamespaceScoped, api.ClusterScoped))
+	}
+	if spec.Validation != nil && spec.Validation.OpenAPIV3Schema != nil {
+		causes = append(causes, validateSchema(spec.Validation.OpenAPIV3Schema, "spec.validation.openAPIV3Schema")...)
+	}
+	causes = append(causes, validateVersions(spec)...)
+	return causes
+}
+
+func validateVersions(spec api.CustomResourceDefinitionSpec) []string {
+	causes := []string{}
+	seen := map[string]bool{}
+	storage := 0
+	for i, v := range spec.Versions {
+		path := fmt.Sprintf("spec.versions[%d]", i)
+		if v.Name == "" {
+			causes = append(causes, path+".name: Required value")
+		} else if seen[v.Name] {
+			causes = append(causes, fmt.Sprintf(`%s.name: Duplicate value: "%s"`, path, v.Name))
+		}
+		seen[v.Name] = true
+		if v.Storage {
+			storage++
+		}
+		if v.Schema != nil && v.Schema.OpenAPIV3Schema != nil {
+			causes = append(causes, validateSchema(v.Schema.OpenAPIV3Schema, path+".schema.openAPIV3Schema")...)
+		}
+	}
+	if len(spec.Versions) > 0 && storage != 1 {
+		causes = append(causes, fmt.Sprintf("spec.versions: Invalid value: %d storage versions: exactly one version must be the storage version", storage))
+	}
+	if c := spec.Conversion; c != nil {
+		switch c.Strategy {
+		case "", api.NoneConverter:
+		case api.CommandConverter:
+			if len(c.Command) == 0 {
+				causes = append(causes, "spec.conversion.command: Required value")
+			}
+		default:
+			causes = append(causes, fmt.Sprintf(`spec.conversion.strategy: Unsupported value: "%s": supported values: "%s", "%s"`, c.Strategy, api.NoneConverter, api.CommandConverter))
+		}
+	}
+	return causes
+}
+
+func validateSchema(schema *api.JSONSchemaProps, path string) []string {
+	causes := []string{}
+	if schema.Type != "" && !schemaTypes[schema.Type] {
+		causes = append(causes, fmt.Sprintf(`%s.type: Unsupported value: "%s": supported values: "object", "array", "string", "integer", "number", "boolean"`, path, schema.Type))
+	}
+	if schema.Pattern != "" {
+		if _, err := regexp.Compile(schema.Pattern); err != nil {
+			causes = append(causes, fmt.Sprintf(`%s.pattern: Invalid value: "%s": %v`, path, schema.Pattern, err))
+		}
+	}
+	if schema.Default != nil {
+		// The default is validated in the form it's written to resources
+		if d, err := deepCopyJSON(schema.Default); err != nil {
+			causes = append(causes, fmt.Sprintf("%s.default: Invalid value: %v", path, err))
+		} else {
+			causes = append(causes, validateValue(d, schema, path+".default")...)
+		}
+	}
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop := schema.Properties[name]
+		causes = append(causes, validateSchema(&prop, fmt.Sprintf("%s.properties[%s]", path, name))...)
+	}
+	if schema.Items != nil {
+		causes = append(causes, validateSchema(schema.Items, path+".items")...)
+	}
+	return causes
+}
+
+func invalidValue(path string, v interface{}, detail string) string {
+	return fmt.Sprintf("%s: Invalid value: %s: %s", fieldPath(path), formatValue(v), detail)
+}
+
+func formatValue(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(raw)
+}
+
+func formatValues(vs []interface{}) string {
+	formatted := make([]string, len(vs))
+	for i, v := range vs {
+		formatted[i] = formatValue(v)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// fieldPath returns the path to be shown in errors, that is empty for the resource itself
+func fieldPath(path string) string {
+	if path == "" {
+		return "<root>"
+	}
+	return path
+}
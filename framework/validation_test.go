@@ -0,0 +1,204 @@
+package framework
+
+import (
+	"encoding/json"
+	"github.com/mumoshu/division/api"
+	"reflect"
+	"testing"
+)
+
+// schemaOf returns the schema written in JSON
+func schemaOf(t *testing.T, raw string) *api.JSONSchemaProps {
+	schema := &api.JSONSchemaProps{}
+	if err := json.Unmarshal([]byte(raw), schema); err != nil {
+		t.Fatalf("invalid schema %s: %v", raw, err)
+	}
+	return schema
+}
+
+// valueOf returns the value written in JSON, in the form read from JSON
+func valueOf(t *testing.T, raw string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		t.Fatalf("invalid value %s: %v", raw, err)
+	}
+	return v
+}
+
+func TestValidateValue(t *testing.T) {
+	testcases := []struct {
+		schema   string
+		value    string
+		expected []string
+	}{
+		{
+			schema:   `{}`,
+			value:    `{"a": 1}`,
+			expected: []string{},
+		},
+		{
+			schema:   `{"type": "object"}`,
+			value:    `"a"`,
+			expected: []string{`<root>: Invalid value: "a": must be of type object`},
+		},
+		{
+			schema:   `{"type": "integer"}`,
+			value:    `1.5`,
+			expected: []string{`<root>: Invalid value: 1.5: must be of type integer`},
+		},
+		{
+			schema:   `{"type": "integer"}`,
+			value:    `2`,
+			expected: []string{},
+		},
+		{
+			schema: `{"type": "object", "required": ["spec"], "properties": {"spec": {"type": "object", "required": ["app", "sha1"]}}}`,
+			value:  `{"spec": {"app": "foo"}}`,
+			expected: []string{
+				`spec.sha1: Required value`,
+			},
+		},
+		{
+			schema:   `{"type": "object", "required": ["spec"]}`,
+			value:    `{}`,
+			expected: []string{`spec: Required value`},
+		},
+		{
+			schema: `{"properties": {"spec": {"properties": {"replicas": {"type": "integer", "minimum": 1, "maximum": 3}, "app": {"type": "string"}}}}}`,
+			value:  `{"spec": {"replicas": 0, "app": 1}}`,
+			expected: []string{
+				`spec.app: Invalid value: 1: must be of type string`,
+				`spec.replicas: Invalid value: 0: must be greater than or equal to 1`,
+			},
+		},
+		{
+			schema:   `{"properties": {"replicas": {"maximum": 3}}}`,
+			value:    `{"replicas": 4}`,
+			expected: []string{`replicas: Invalid value: 4: must be less than or equal to 3`},
+		},
+		{
+			schema:   `{"properties": {"sha1": {"type": "string", "pattern": "^[0-9a-f]+$"}}}`,
+			value:    `{"sha1": "master"}`,
+			expected: []string{`sha1: Invalid value: "master": must match the pattern ^[0-9a-f]+$`},
+		},
+		{
+			schema: `{"properties": {"name": {"type": "string", "minLength": 2, "maxLength": 3}}}`,
+			value:  `{"name": "a"}`,
+			expected: []string{
+				`name: Invalid value: "a": must be at least 2 characters long`,
+			},
+		},
+		{
+			// Lengths are counted in characters rather than bytes
+			schema:   `{"properties": {"name": {"type": "string", "maxLength": 3}}}`,
+			value:    `{"name": "あいう"}`,
+			expected: []string{},
+		},
+		{
+			schema:   `{"properties": {"env": {"enum": ["prod", "dev"]}}}`,
+			value:    `{"env": "staging"}`,
+			expected: []string{`env: Unsupported value: "staging": supported values: "prod", "dev"`},
+		},
+		{
+			schema:   `{"properties": {"replicas": {"enum": [1, 2]}}}`,
+			value:    `{"replicas": 2}`,
+			expected: []string{},
+		},
+		{
+			schema: `{"properties": {"ports": {"type": "array", "items": {"type": "integer"}}}}`,
+			value:  `{"ports": [80, "443", 8080.5]}`,
+			expected: []string{
+				`ports[1]: Invalid value: "443": must be of type integer`,
+				`ports[2]: Invalid value: 8080.5: must be of type integer`,
+			},
+		},
+	}
+
+	for i, tc := range testcases {
+		actual := validateValue(valueOf(t, tc.value), schemaOf(t, tc.schema), "")
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("case %d: unexpected causes for %s against %s:\nexpected=%q\nactual=%q", i, tc.value, tc.schema, tc.expected, actual)
+		}
+	}
+}
+
+func TestValidateResource(t *testing.T) {
+	def := &api.CustomResourceDefinition{
+		Metadata: api.Metadata{Name: "deployment"},
+		Spec: api.CustomResourceDefinitionSpec{
+			Names: api.CustomResourceDefinitionNames{Kind: "Deployment"},
+			Validation: &api.CustomResourceValidation{
+				OpenAPIV3Schema: schemaOf(t, `{"properties": {"spec": {"required": ["app"]}}}`),
+			},
+		},
+	}
+
+	testcases := []struct {
+		name     string
+		resource *api.Resource
+		causes   []string
+	}{
+		{
+			name:     "valid",
+			resource: &api.Resource{Kind: "Deployment", Metadata: api.Metadata{Name: "foo"}, Spec: map[string]interface{}{"app": "foo"}},
+		},
+		{
+			name:     "invalid",
+			resource: &api.Resource{Kind: "Deployment", Metadata: api.Metadata{Name: "foo"}, Spec: map[string]interface{}{}},
+			causes:   []string{"spec.app: Required value"},
+		},
+		{
+			name: "valid definition",
+			resource: &api.Resource{Kind: api.CustomResourceDefinitionKind, Metadata: api.Metadata{Name: "foo"}, Spec: map[string]interface{}{
+				"names": map[string]interface{}{"kind": "Foo"},
+				"scope": api.ClusterScoped,
+			}},
+		},
+		{
+			name: "invalid definition",
+			resource: &api.Resource{Kind: api.CustomResourceDefinitionKind, Metadata: api.Metadata{Name: "foo"}, Spec: map[string]interface{}{
+				"names": map[string]interface{}{},
+				"scope": "Global",
+				"validation": map[string]interface{}{
+					"openAPIV3Schema": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"spec": map[string]interface{}{"type": "map", "pattern": "("},
+							"size": map[string]interface{}{"type": "integer", "default": "large"},
+						},
+					},
+				},
+				"versions": []interface{}{
+					map[string]interface{}{"name": "v1"},
+					map[string]interface{}{"name": "v1"},
+				},
+			}},
+			causes: []string{
+				"spec.names.kind: Required value",
+				`spec.scope: Unsupported value: "Global": supported values: "Namespaced", "Cluster"`,
+				`spec.validation.openAPIV3Schema.properties[size].default: Invalid value: "large": must be of type integer`,
+				`spec.validation.openAPIV3Schema.properties[spec].type: Unsupported value: "map": supported values: "object", "array", "string", "integer", "number", "boolean"`,
+				"spec.validation.openAPIV3Schema.properties[spec].pattern: Invalid value: \"(\": error parsing regexp: missing closing ): `(`",
+				`spec.versions[1].name: Duplicate value: "v1"`,
+				"spec.versions: Invalid value: 0 storage versions: exactly one version must be the storage version",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		err := ValidateResource(def, tc.resource)
+		if tc.causes == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		invalid, ok := err.(*api.ErrInvalid)
+		if !ok {
+			t.Errorf("%s: expected ErrInvalid, but got %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(invalid.Causes, tc.causes) {
+			t.Errorf("%s: unexpected causes:\nexpected=%q\nactual=%q", tc.name, tc.causes, invalid.Causes)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"os"
+	"time"
+)
+
+// Wait watches the resource until it satisfies the condition, optionally streaming its logs to stderr.
+// This is for backends whose GetAsync and LogStore.Read are cheap enough to be used as-is
+func Wait(ctx context.Context, store api.Store, logs api.LogStore, resource, name string, cond api.WaitCondition, timeout time.Duration, withLogs bool) (*api.Resource, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rs, es := store.GetAsync(ctx, resource, name, []string{}, true)
+	to := make(<-chan time.Time)
+	if timeout > 0 {
+		to = time.After(timeout)
+	}
+	logMsgCh := make(<-chan string)
+	logErrCh := make(<-chan error)
+	if withLogs {
+		logMsgCh, logErrCh = logs.Read(ctx, resource, name, 0, true)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-to:
+			return nil, fmt.Errorf("timed out")
+		case err, ok := <-es:
+			if ok {
+				return nil, fmt.Errorf("failed streaming: %v", err)
+			}
+			es = nil
+		case err, ok := <-logErrCh:
+			if ok {
+				return nil, fmt.Errorf("failed streaming logs: %v", err)
+			}
+			logErrCh = nil
+		case msg, ok := <-logMsgCh:
+			if !ok {
+				logMsgCh = nil
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%s", msg)
+		case res, ok := <-rs:
+			if !ok {
+				return nil, fmt.Errorf("stream stopped unexpectedly: please rerun the div command")
+			}
+			matched, err := cond(res)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				return res, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/mumoshu/division/api"
+)
+
+// NewWatchEvent returns the event for the change from `oldObj` to `newObj` seen by a watcher interested in resources matching the selector.
+// `oldObj` is nil for a newly created resource, and `newObj` is nil for a deleted resource.
+// Like Kubernetes, a resource that starts matching the selector is ADDED, and a resource that stops matching it is DELETED.
+// nil is returned when the change is invisible to the watcher
+func NewWatchEvent(oldObj, newObj *api.Resource, selector api.Selector) *api.WatchEvent {
+	oldMatches := oldObj != nil && selector.Matches(oldObj.Metadata.Labels)
+	newMatches := newObj != nil && selector.Matches(newObj.Metadata.Labels)
+	switch {
+	case oldMatches && newMatches:
+		return &api.WatchEvent{Type: api.Modified, Object: newObj, OldObject: oldObj}
+	case newMatches:
+		return &api.WatchEvent{Type: api.Added, Object: newObj}
+	case oldMatches && newObj != nil:
+		return &api.WatchEvent{Type: api.Deleted, Object: newObj}
+	case oldMatches:
+		return &api.WatchEvent{Type: api.Deleted, Object: oldObj}
+	default:
+		return nil
+	}
+}
+
+// WatchedResources converts watch events to resources for GetAsync, skipping DELETED events.
+// The context must be the one the events are watched with
+func WatchedResources(ctx context.Context, events <-chan *api.WatchEvent, errs <-chan error) (<-chan *api.Resource, <-chan error) {
+	resCh := make(chan *api.Resource)
+	go func() {
+		defer close(resCh)
+		for ev := range events {
+			if ev.Type == api.Deleted {
+				continue
+			}
+			select {
+			case resCh <- ev.Object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return resCh, errs
+}
+
+// PrintWatchEventsSync writes watch events to stdout until the context is canceled or an error is received.
+// The `json` output is a newline-delimited JSON stream of events, and the `yaml` output is a stream of YAML documents
+func PrintWatchEventsSync(ctx context.Context, events <-chan *api.WatchEvent, errs <-chan error, output string) error {
+	for events != nil || errs != nil {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-errs:
+			if ok {
+				return fmt.Errorf("stream error: %v", err)
+			}
+			errs = nil
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if err := printWatchEvent(ev, output); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func printWatchEvent(ev *api.WatchEvent, output string) error {
+	switch output {
+	case "json":
+		raw, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		WriteToStdout(string(raw))
+	case "yaml":
+		raw, err := yaml.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		WriteToStdout("---\n" + string(raw))
+	default:
+		return fmt.Errorf("unexpected output format: %s", output)
+	}
+	return nil
+}
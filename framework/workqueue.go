@@ -0,0 +1,166 @@
+package framework
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBaseDelay = time.Second
+	DefaultMaxDelay  = 5 * time.Minute
+)
+
+// RateLimiter tells how long to wait before retrying the key
+type RateLimiter interface {
+	When(key string) time.Duration
+	// Forget resets the delay for the key after it has been processed successfully
+	Forget(key string)
+	NumRequeues(key string) int
+}
+
+// ExponentialBackoff doubles the delay from BaseDelay up to MaxDelay on every failure of the key
+type ExponentialBackoff struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	failures  map[string]int
+	mu        sync.Mutex
+}
+
+func NewExponentialBackoff(baseDelay, maxDelay time.Duration) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		BaseDelay: baseDelay,
+		MaxDelay:  maxDelay,
+		failures:  map[string]int{},
+	}
+}
+
+func (b *ExponentialBackoff) When(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.failures[key]
+	b.failures[key] = n + 1
+	d := float64(b.BaseDelay) * math.Pow(2, float64(n))
+	if d > float64(b.MaxDelay) {
+		return b.MaxDelay
+	}
+	return time.Duration(d)
+}
+
+func (b *ExponentialBackoff) Forget(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, key)
+}
+
+func (b *ExponentialBackoff) NumRequeues(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures[key]
+}
+
+// WorkQueue is a FIFO queue of keys to be processed by workers.
+// A key added while it is queued is deduplicated, and a key added while it is being processed is queued again once processed,
+// so that a key is never processed by more than one worker at a time
+type WorkQueue struct {
+	queue        []string
+	dirty        map[string]struct{}
+	processing   map[string]struct{}
+	rateLimiter  RateLimiter
+	shuttingDown bool
+	cond         *sync.Cond
+}
+
+func NewWorkQueue(rateLimiter RateLimiter) *WorkQueue {
+	return &WorkQueue{
+		dirty:       map[string]struct{}{},
+		processing:  map[string]struct{}{},
+		rateLimiter: rateLimiter,
+		cond:        sync.NewCond(&sync.Mutex{}),
+	}
+}
+
+func (q *WorkQueue) Add(key string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[key]; ok {
+		return
+	}
+	q.dirty[key] = struct{}{}
+	if _, ok := q.processing[key]; ok {
+		return
+	}
+	q.queue = append(q.queue, key)
+	q.cond.Signal()
+}
+
+// AddAfter adds the key after the delay
+func (q *WorkQueue) AddAfter(key string, delay time.Duration) {
+	if delay <= 0 {
+		q.Add(key)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		q.Add(key)
+	})
+}
+
+// AddRateLimited adds the key after the delay given by the rate limiter, that is usually done when processing the key has failed
+func (q *WorkQueue) AddRateLimited(key string) {
+	q.AddAfter(key, q.rateLimiter.When(key))
+}
+
+// Forget resets the rate limiter for the key, that is usually done when the key has been processed successfully
+func (q *WorkQueue) Forget(key string) {
+	q.rateLimiter.Forget(key)
+}
+
+func (q *WorkQueue) NumRequeues(key string) int {
+	return q.rateLimiter.NumRequeues(key)
+}
+
+// Get blocks until a key is available. The bool is true when the queue is shut down, in which case the worker should exit.
+// Call Done with the key once it is processed
+func (q *WorkQueue) Get() (string, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.queue) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		return "", true
+	}
+	key := q.queue[0]
+	q.queue = q.queue[1:]
+	q.processing[key] = struct{}{}
+	delete(q.dirty, key)
+	return key, false
+}
+
+// Done marks the key as processed, and queues it again if it has been added while it is being processed
+func (q *WorkQueue) Done(key string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.processing, key)
+	if _, ok := q.dirty[key]; ok {
+		q.queue = append(q.queue, key)
+		q.cond.Signal()
+	}
+}
+
+func (q *WorkQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return len(q.queue)
+}
+
+// ShutDown makes workers exit once they call Get after the queue is drained. Keys added afterwards are ignored
+func (q *WorkQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
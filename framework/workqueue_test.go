@@ -0,0 +1,170 @@
+package framework
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// drain gets all the keys currently queued, marking each of them done
+func drain(q *WorkQueue) []string {
+	keys := []string{}
+	for q.Len() > 0 {
+		key, _ := q.Get()
+		keys = append(keys, key)
+		q.Done(key)
+	}
+	return keys
+}
+
+func TestWorkQueueDedup(t *testing.T) {
+	testcases := []struct {
+		added    []string
+		expected []string
+	}{
+		{
+			added:    []string{},
+			expected: []string{},
+		},
+		{
+			added:    []string{"a", "b", "c"},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			added:    []string{"a", "b", "a", "a", "c", "b"},
+			expected: []string{"a", "b", "c"},
+		},
+	}
+
+	for i, tc := range testcases {
+		q := NewWorkQueue(NewExponentialBackoff(DefaultBaseDelay, DefaultMaxDelay))
+		for _, key := range tc.added {
+			q.Add(key)
+		}
+		if actual := drain(q); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("case %d: unexpected keys: expected=%v, actual=%v", i, tc.expected, actual)
+		}
+	}
+}
+
+func TestWorkQueueRequeueWhileProcessing(t *testing.T) {
+	q := NewWorkQueue(NewExponentialBackoff(DefaultBaseDelay, DefaultMaxDelay))
+	q.Add("a")
+
+	key, shutdown := q.Get()
+	if key != "a" || shutdown {
+		t.Fatalf("unexpected result of Get: key=%s, shutdown=%v", key, shutdown)
+	}
+
+	// The key being processed is never handed to another worker
+	q.Add("a")
+	q.Add("a")
+	if n := q.Len(); n != 0 {
+		t.Errorf("the key being processed is queued: len=%d", n)
+	}
+
+	// The key added while processed is queued once again, exactly once
+	q.Done("a")
+	if actual := drain(q); !reflect.DeepEqual(actual, []string{"a"}) {
+		t.Errorf("unexpected keys after Done: %v", actual)
+	}
+	if n := q.Len(); n != 0 {
+		t.Errorf("unexpected keys left: len=%d", n)
+	}
+}
+
+func TestWorkQueueShutDown(t *testing.T) {
+	q := NewWorkQueue(NewExponentialBackoff(DefaultBaseDelay, DefaultMaxDelay))
+	q.Add("a")
+	q.ShutDown()
+	q.Add("b")
+
+	// Keys queued before the shutdown are drained
+	if key, shutdown := q.Get(); key != "a" || shutdown {
+		t.Errorf("unexpected result of Get: key=%s, shutdown=%v", key, shutdown)
+	}
+	q.Done("a")
+
+	done := make(chan bool)
+	go func() {
+		_, shutdown := q.Get()
+		done <- shutdown
+	}()
+	select {
+	case shutdown := <-done:
+		if !shutdown {
+			t.Errorf("Get returned a key after the shutdown")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Get blocked after the shutdown")
+	}
+}
+
+func TestWorkQueueAddAfter(t *testing.T) {
+	q := NewWorkQueue(NewExponentialBackoff(DefaultBaseDelay, DefaultMaxDelay))
+	q.AddAfter("a", 50*time.Millisecond)
+	if n := q.Len(); n != 0 {
+		t.Errorf("the key is queued before the delay: len=%d", n)
+	}
+
+	done := make(chan string)
+	go func() {
+		key, _ := q.Get()
+		done <- key
+	}()
+	select {
+	case key := <-done:
+		if key != "a" {
+			t.Errorf("unexpected key: %s", key)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("the key isn't queued after the delay")
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	testcases := []struct {
+		base     time.Duration
+		max      time.Duration
+		expected []time.Duration
+	}{
+		{
+			base:     time.Second,
+			max:      time.Minute,
+			expected: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			base:     time.Second,
+			max:      5 * time.Second,
+			expected: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+		{
+			base:     DefaultBaseDelay,
+			max:      DefaultMaxDelay,
+			expected: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+	}
+
+	for i, tc := range testcases {
+		b := NewExponentialBackoff(tc.base, tc.max)
+		for n, expected := range tc.expected {
+			if actual := b.When("a"); actual != expected {
+				t.Errorf("case %d: unexpected delay for failure %d: expected=%v, actual=%v", i, n+1, expected, actual)
+			}
+		}
+		if actual := b.NumRequeues("a"); actual != len(tc.expected) {
+			t.Errorf("case %d: unexpected requeues: expected=%d, actual=%d", i, len(tc.expected), actual)
+		}
+		// Keys are backed off independently
+		if actual := b.When("b"); actual != tc.base {
+			t.Errorf("case %d: unexpected delay for another key: expected=%v, actual=%v", i, tc.base, actual)
+		}
+		b.Forget("a")
+		if actual := b.NumRequeues("a"); actual != 0 {
+			t.Errorf("case %d: unexpected requeues after Forget: %d", i, actual)
+		}
+		if actual := b.When("a"); actual != tc.base {
+			t.Errorf("case %d: unexpected delay after Forget: expected=%v, actual=%v", i, tc.base, actual)
+		}
+	}
+}
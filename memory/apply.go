@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"time"
+)
+
+func (p *memoryResourceDB) ApplyFile(ctx context.Context, file string) error {
+	return framework.ApplyFile(ctx, p, file, false)
+}
+
+func (p *memoryResourceDB) resourceDefinitionForKind(kind string) (*api.CustomResourceDefinition, error) {
+	crds, err := getCRDs(p.db)
+	if err != nil {
+		return nil, err
+	}
+	defs := append(append([]api.CustomResourceDefinition{}, p.resourceDefs...), crds...)
+	for i := range defs {
+		if defs[i].ResourceKind() == kind {
+			return &defs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no resource definition found in %v: kind=%s", defs, kind)
+}
+
+func (p *memoryResourceDB) Apply(ctx context.Context, resource *api.Resource) error {
+	resource.Metadata.CreationTimestamp = time.Now()
+	resource.Metadata.UpdateTimestamp = time.Now()
+	if resource.NameHashKey == "" {
+		resource.NameHashKey = resource.Metadata.Name
+	}
+
+	resourceDef, err := p.resourceDefinitionForKind(resource.Kind)
+	if err != nil {
+		return err
+	}
+	if err := framework.AdmitResource(ctx, resourceDef, resource); err != nil {
+		return err
+	}
+	table := p.tableNameFor(resourceDef)
+
+	updated, err := p.db.apply(table, resource)
+	if err != nil {
+		return err
+	}
+	if updated {
+		fmt.Printf("%s \"%s\" updated\n", resourceDef.Metadata.Name, resource.Metadata.Name)
+	} else {
+		fmt.Printf("%s \"%s\" created\n", resourceDef.Metadata.Name, resource.Metadata.Name)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"encoding/json"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+)
+
+// LoadConfigFromMemory loads resource definitions applied to the in-memory database named `path`
+func LoadConfigFromMemory(path string, context *api.Config) (*api.Config, error) {
+	dynamicRDs, err := getCRDs(databaseNamed(path))
+	if err != nil {
+		return nil, err
+	}
+
+	rdOfDynamicRDs := api.CustomResourceDefinition{
+		Kind: api.CustomResourceDefinitionKind,
+		Metadata: api.Metadata{
+			Name: api.CustomResourceDefinitionResource,
+		},
+		Spec: api.CustomResourceDefinitionSpec{
+			Names: api.CustomResourceDefinitionNames{
+				Kind: api.CustomResourceDefinitionKind,
+			},
+		},
+	}
+	rds := []api.CustomResourceDefinition{
+		rdOfDynamicRDs,
+	}
+	rds = append(rds, dynamicRDs...)
+	return &api.Config{
+		Metadata: api.Metadata{
+			Name: context.Metadata.Name,
+		},
+		Spec: api.ConfigSpec{
+			CustomResourceDefinitions: rds,
+		},
+	}, nil
+}
+
+func getCRDs(db *database) ([]api.CustomResourceDefinition, error) {
+	crds := []api.CustomResourceDefinition{}
+	resources, _ := db.scan(api.CustomResourceDefinitionResource)
+	for _, r := range resources {
+		raw, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		var crd api.CustomResourceDefinition
+		if err := json.Unmarshal(raw, &crd); err != nil {
+			return nil, err
+		}
+		crds = append(crds, crd)
+	}
+	return crds, nil
+}
+
+func init() {
+	framework.ConfigLoaders.Register("memory", LoadConfigFromMemory)
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+)
+
+func (p *memoryResourceDB) Delete(ctx context.Context, resource string, name string) error {
+	if _, deleted := p.db.delete(p.tableNameForResourceNamed(resource), name); !deleted {
+		return fmt.Errorf(`%s "%s" not found`, resource, name)
+	}
+	fmt.Printf("%s \"%s\" deleted\n", resource, name)
+	return nil
+}
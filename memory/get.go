@@ -0,0 +1,165 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"os"
+)
+
+func (p *memoryResourceDB) GetPrint(ctx context.Context, resource, name string, selectors []string, output string, watch bool) error {
+	if watch {
+		evCh, errCh := p.Watch(ctx, resource, name, selectors, api.WatchOptions{})
+
+		return framework.PrintWatchEventsSync(ctx, evCh, errCh, output)
+	}
+
+	resCh, errCh := p.GetAsync(ctx, resource, name, selectors, false)
+
+	return framework.PrintStreamedResourcesSync(ctx, resCh, errCh, output, false)
+}
+
+func (p *memoryResourceDB) GetCRDs(ctx context.Context) ([]api.CustomResourceDefinition, error) {
+	return getCRDs(p.db)
+}
+
+func (p *memoryResourceDB) get(resource, name string, selectors []string) (api.Resources, error) {
+	selector, err := api.ParseSelectors(selectors)
+	if err != nil {
+		return nil, err
+	}
+	table := p.tableNameForResourceNamed(resource)
+	resources := api.Resources{}
+	if name != "" {
+		r, exists := p.db.get(table, name)
+		if exists && selector.Matches(r.Metadata.Labels) {
+			resources = append(resources, r)
+		}
+		if len(resources) == 0 {
+			return nil, api.NewErrResourceNotFound(fmt.Sprintf(`%s "%s" not found`, resource, name))
+		}
+	} else {
+		rs, _ := p.db.scan(table)
+		for _, r := range rs {
+			if selector.Matches(r.Metadata.Labels) {
+				resources = append(resources, r)
+			}
+		}
+		if len(resources) == 0 {
+			fmt.Fprintf(os.Stderr, "no %s found\n", resource)
+		}
+	}
+	return resources, nil
+}
+
+func (p *memoryResourceDB) GetSync(ctx context.Context, resource, name string, selectors []string) ([]*api.Resource, error) {
+	resources, err := p.get(resource, name, selectors)
+	if err != nil {
+		return nil, err
+	}
+	rs := []*api.Resource{}
+	for i := range resources {
+		rs = append(rs, &resources[i])
+	}
+	return rs, nil
+}
+
+func (p *memoryResourceDB) GetAsync(ctx context.Context, resource, name string, selectors []string, watch bool) (<-chan *api.Resource, <-chan error) {
+	if watch {
+		evCh, errCh := p.Watch(ctx, resource, name, selectors, api.WatchOptions{})
+
+		return framework.WatchedResources(ctx, evCh, errCh)
+	}
+
+	resCh := make(chan *api.Resource)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(resCh)
+		defer close(errCh)
+
+		resources, err := p.get(resource, name, selectors)
+		if err != nil {
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		for i := range resources {
+			select {
+			case resCh <- &resources[i]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resCh, errCh
+}
+
+func (p *memoryResourceDB) Watch(ctx context.Context, resource, name string, selectors []string, opts api.WatchOptions) (<-chan *api.WatchEvent, <-chan error) {
+	evCh := make(chan *api.WatchEvent)
+	errCh := make(chan error)
+
+	// Start watching before listing so that we won't miss changes made in between
+	table := p.tableNameForResourceNamed(resource)
+	w := p.db.watch(table)
+
+	go func() {
+		defer close(evCh)
+		defer close(errCh)
+		defer p.db.unwatch(table, w)
+
+		sendErr := func(err error) {
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
+		}
+		send := func(ev *api.WatchEvent) bool {
+			select {
+			case evCh <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		selector, err := api.ParseSelectors(selectors)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		resources, err := p.get(resource, name, selectors)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		for i := range resources {
+			if !send(&api.WatchEvent{Type: api.Added, Object: &resources[i]}) {
+				return
+			}
+		}
+
+		for {
+			select {
+			case c := <-w.out:
+				if name != "" && name != c.name() {
+					continue
+				}
+				if ev := framework.NewWatchEvent(c.oldObj, c.newObj, selector); ev != nil && !send(ev) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return evCh, errCh
+}
@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"context"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"time"
+)
+
+func (p *memoryResourceDB) AcquireLease(ctx context.Context, name, holder string, duration time.Duration) (*api.Resource, error) {
+	return p.db.update(p.tableNameForResourceNamed(api.LeaseResource), name, func(existing *api.Resource) (*api.Resource, error) {
+		return framework.PrepareLeaseAcquire(existing, name, holder, duration)
+	})
+}
+
+func (p *memoryResourceDB) RenewLease(ctx context.Context, name, holder string, duration time.Duration) (*api.Resource, error) {
+	return p.db.update(p.tableNameForResourceNamed(api.LeaseResource), name, func(existing *api.Resource) (*api.Resource, error) {
+		return framework.PrepareLeaseRenew(existing, name, holder, duration)
+	})
+}
+
+func (p *memoryResourceDB) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := p.db.update(p.tableNameForResourceNamed(api.LeaseResource), name, func(existing *api.Resource) (*api.Resource, error) {
+		return framework.PrepareLeaseRelease(existing, name, holder)
+	})
+	return err
+}
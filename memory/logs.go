@@ -0,0 +1,204 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const logPollInterval = 100 * time.Millisecond
+
+type logEvent struct {
+	timestamp time.Time
+	message   string
+}
+
+type logStream struct {
+	events []logEvent
+	sync.RWMutex
+}
+
+func (s *logStream) append(msg string) {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, logEvent{timestamp: time.Now(), message: msg})
+}
+
+// since returns events at and after the offset
+func (s *logStream) since(offset int) []logEvent {
+	s.RLock()
+	defer s.RUnlock()
+	if offset >= len(s.events) {
+		return nil
+	}
+	return append([]logEvent{}, s.events[offset:]...)
+}
+
+type LogStore struct {
+	db        *database
+	namespace string
+}
+
+func (c *LogStore) streamKey(resource, name string) string {
+	return fmt.Sprintf("%s-%s/%s", c.namespace, resource, name)
+}
+
+func (c *LogStore) stream(resource, name string, create bool) *logStream {
+	c.db.Lock()
+	defer c.db.Unlock()
+	key := c.streamKey(resource, name)
+	s, ok := c.db.logs[key]
+	if !ok && create {
+		s = &logStream{}
+		c.db.logs[key] = s
+	}
+	return s
+}
+
+func (c *LogStore) Read(ctx context.Context, resource, name string, since time.Duration, follow bool) (<-chan string, <-chan error) {
+	msgs := make(chan string)
+	errs := make(chan error)
+
+	go func() {
+		defer close(msgs)
+		defer close(errs)
+
+		s := c.stream(resource, name, false)
+		if s == nil {
+			select {
+			case errs <- api.NewErrLogsNotFound(fmt.Sprintf("log stream for resource=%s name=%s does not exist (yet)", resource, name)):
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		var startTime time.Time
+		if since.Nanoseconds() != 0 {
+			startTime = time.Now().Add(-since)
+		}
+
+		offset := 0
+		for {
+			events := s.since(offset)
+			offset += len(events)
+			for _, e := range events {
+				if e.timestamp.Before(startTime) {
+					continue
+				}
+				select {
+				case msgs <- e.message:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !follow {
+				return
+			}
+			select {
+			case <-time.After(logPollInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return msgs, errs
+}
+
+func (c *LogStore) ReadPrint(ctx context.Context, resource, name string, since time.Duration, follow bool) error {
+	logsCh, errCh := c.Read(ctx, resource, name, since, follow)
+	var err error
+	for logsCh != nil || errCh != nil {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-errCh:
+			if ok {
+				err = e
+			}
+			errCh = nil
+		case msg, ok := <-logsCh:
+			if !ok {
+				logsCh = nil
+				continue
+			}
+			fmt.Printf("%s", msg)
+		}
+	}
+	return err
+}
+
+// logWriter appends a log event per line written
+type logWriter struct {
+	stream *logStream
+	buf    bytes.Buffer
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf.Next(i + 1)
+		w.stream.append(string(line))
+	}
+	return len(p), nil
+}
+
+func (w *logWriter) Close() error {
+	if w.buf.Len() > 0 {
+		w.stream.append(w.buf.String() + "\n")
+		w.buf.Reset()
+	}
+	return nil
+}
+
+func (c *LogStore) Writer(ctx context.Context, resource, name string) (io.WriteCloser, error) {
+	return &logWriter{stream: c.stream(resource, name, true)}, nil
+}
+
+func (c *LogStore) WriteFile(ctx context.Context, resource, name string, file string) error {
+	var rawInput []byte
+	if file == "-" {
+		var buf bytes.Buffer
+
+		nr, err := io.Copy(&buf, os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %v", err)
+		}
+		rawInput = buf.Bytes()
+		fmt.Fprintf(os.Stderr, "read %d bytes from stdin\n", nr)
+	} else {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rawInput = raw
+	}
+	w, err := c.Writer(ctx, resource, name)
+	if err != nil {
+		return err
+	}
+	w.Write(rawInput)
+	w.Write([]byte("\n"))
+	return w.Close()
+}
+
+func (c *LogStore) Delete(ctx context.Context, resource, name string) error {
+	c.db.Lock()
+	defer c.db.Unlock()
+	key := c.streamKey(resource, name)
+	if _, ok := c.db.logs[key]; !ok {
+		return api.NewErrLogsNotFound(fmt.Sprintf("log stream for resource=%s name=%s does not exist", resource, name))
+	}
+	delete(c.db.logs, key)
+	return nil
+}
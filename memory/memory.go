@@ -0,0 +1,280 @@
+package memory
+
+import (
+	"fmt"
+	"github.com/mumoshu/division/api"
+	"github.com/mumoshu/division/framework"
+	"sync"
+)
+
+// databases holds every in-memory database created within the process, keyed by the path of `memory://<path>`.
+// Stores and log stores for the same path share a database, so that e.g. a gateway and a deployer running in
+// the same process can communicate with each other.
+var databases = struct {
+	items map[string]*database
+	sync.Mutex
+}{
+	items: map[string]*database{},
+}
+
+type database struct {
+	tables   map[string]map[string]api.Resource
+	watchers map[string][]*watcher
+	logs     map[string]*logStream
+	sync.RWMutex
+}
+
+func databaseNamed(name string) *database {
+	databases.Lock()
+	defer databases.Unlock()
+	db, ok := databases.items[name]
+	if !ok {
+		db = &database{
+			tables:   map[string]map[string]api.Resource{},
+			watchers: map[string][]*watcher{},
+			logs:     map[string]*logStream{},
+		}
+		databases.items[name] = db
+	}
+	return db
+}
+
+func (d *database) get(table, name string) (api.Resource, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	r, ok := d.tables[table][name]
+	if !ok {
+		return api.Resource{}, false
+	}
+	return *r.DeepCopy(), true
+}
+
+func (d *database) scan(table string) (api.Resources, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	items, ok := d.tables[table]
+	if !ok {
+		return nil, false
+	}
+	rs := api.Resources{}
+	for _, r := range items {
+		rs = append(rs, *r.DeepCopy())
+	}
+	return rs, true
+}
+
+// apply stores the resource after checking its resourceVersion, and returns true if it has updated an existing one
+func (d *database) apply(table string, resource *api.Resource) (bool, error) {
+	d.Lock()
+	defer d.Unlock()
+	items, ok := d.tables[table]
+	if !ok {
+		items = map[string]api.Resource{}
+		d.tables[table] = items
+	}
+	var existing *api.Resource
+	if e, exists := items[resource.NameHashKey]; exists {
+		existing = &e
+	}
+	if err := framework.PrepareWrite(resource, existing); err != nil {
+		return false, err
+	}
+	items[resource.NameHashKey] = *resource.DeepCopy()
+	d.notify(table, existing, resource)
+	return existing != nil, nil
+}
+
+// update atomically writes the resource computed from the existing one, that is nil when the resource doesn't exist
+func (d *database) update(table, name string, prepare func(existing *api.Resource) (*api.Resource, error)) (*api.Resource, error) {
+	d.Lock()
+	defer d.Unlock()
+	items, ok := d.tables[table]
+	if !ok {
+		items = map[string]api.Resource{}
+		d.tables[table] = items
+	}
+	var existing *api.Resource
+	if e, exists := items[name]; exists {
+		existing = &e
+	}
+	resource, err := prepare(existing)
+	if err != nil {
+		return nil, err
+	}
+	if err := framework.PrepareWrite(resource, existing); err != nil {
+		return nil, err
+	}
+	items[name] = *resource.DeepCopy()
+	d.notify(table, existing, resource)
+	return resource, nil
+}
+
+// updateStatus replaces the status of the existing resource, and then updates the resource to the stored one
+func (d *database) updateStatus(table string, resource *api.Resource) error {
+	d.Lock()
+	defer d.Unlock()
+	var existing *api.Resource
+	if e, exists := d.tables[table][resource.Metadata.Name]; exists {
+		existing = &e
+	}
+	updated, err := framework.PrepareStatusWrite(resource, existing)
+	if err != nil {
+		return err
+	}
+	d.tables[table][updated.NameHashKey] = *updated.DeepCopy()
+	d.notify(table, existing, updated)
+	*resource = *updated
+	return nil
+}
+
+func (d *database) delete(table, name string) (*api.Resource, bool) {
+	d.Lock()
+	defer d.Unlock()
+	existing, ok := d.tables[table][name]
+	if !ok {
+		return nil, false
+	}
+	delete(d.tables[table], name)
+	d.notify(table, &existing, nil)
+	return &existing, true
+}
+
+func (d *database) watch(table string) *watcher {
+	d.Lock()
+	defer d.Unlock()
+	w := newWatcher()
+	d.watchers[table] = append(d.watchers[table], w)
+	return w
+}
+
+// unwatch stops notifying the watcher, and then stops it
+func (d *database) unwatch(table string, w *watcher) {
+	d.Lock()
+	defer d.Unlock()
+	watchers := []*watcher{}
+	for _, x := range d.watchers[table] {
+		if x != w {
+			watchers = append(watchers, x)
+		}
+	}
+	d.watchers[table] = watchers
+	close(w.stop)
+}
+
+// change is a write to a table. oldObj is nil for a created resource, and newObj is nil for a deleted resource
+type change struct {
+	oldObj *api.Resource
+	newObj *api.Resource
+}
+
+func (c *change) name() string {
+	if c.newObj != nil {
+		return c.newObj.NameHashKey
+	}
+	return c.oldObj.NameHashKey
+}
+
+// notify must be called while the database is locked
+func (d *database) notify(table string, oldObj, newObj *api.Resource) {
+	for _, w := range d.watchers[table] {
+		c := &change{}
+		if oldObj != nil {
+			c.oldObj = oldObj.DeepCopy()
+		}
+		if newObj != nil {
+			c.newObj = newObj.DeepCopy()
+		}
+		w.in <- c
+	}
+}
+
+// watcher buffers notifications without a bound, so that a consumer is free to write to the database
+// while it is handling a notification
+type watcher struct {
+	in   chan *change
+	out  chan *change
+	stop chan struct{}
+}
+
+func newWatcher() *watcher {
+	w := &watcher{
+		in:   make(chan *change),
+		out:  make(chan *change),
+		stop: make(chan struct{}),
+	}
+	go func() {
+		queue := []*change{}
+		for {
+			var out chan *change
+			var next *change
+			if len(queue) > 0 {
+				out = w.out
+				next = queue[0]
+			}
+			select {
+			case r := <-w.in:
+				queue = append(queue, r)
+			case out <- next:
+				queue = queue[1:]
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+type memoryResourceDB struct {
+	db           *database
+	config       *api.Config
+	logs         *LogStore
+	namespace    string
+	resourceDefs []api.CustomResourceDefinition
+}
+
+// tableNameForResourceNamed returns the name of the table for the resource, that is shared among namespaces when the resource is cluster-scoped
+func (p *memoryResourceDB) tableNameForResourceNamed(resource string) string {
+	if resource == api.CustomResourceDefinitionResource {
+		return resource
+	}
+	// Leases are always namespaced
+	if resource != api.LeaseResource {
+		crds, _ := getCRDs(p.db)
+		for _, d := range append(append([]api.CustomResourceDefinition{}, p.resourceDefs...), crds...) {
+			if d.Metadata.Name == resource {
+				return p.tableNameFor(&d)
+			}
+		}
+	}
+	return fmt.Sprintf("%s-%s", p.namespace, resource)
+}
+
+func (p *memoryResourceDB) tableNameFor(resourceDef *api.CustomResourceDefinition) string {
+	if resourceDef.Metadata.Name == api.CustomResourceDefinitionResource || resourceDef.Spec.ClusterScoped() {
+		return resourceDef.Metadata.Name
+	}
+	return fmt.Sprintf("%s-%s", p.namespace, resourceDef.Metadata.Name)
+}
+
+func newDB(path string, config *api.Config, namespace string) (*memoryResourceDB, *LogStore, error) {
+	db := databaseNamed(path)
+	logs := &LogStore{
+		db:        db,
+		namespace: namespace,
+	}
+	return &memoryResourceDB{
+		db:           db,
+		config:       config,
+		logs:         logs,
+		namespace:    namespace,
+		resourceDefs: config.Spec.CustomResourceDefinitions,
+	}, logs, nil
+}
+
+func newStore(path string, config *api.Config, namespace string) (api.Store, api.LogStore, error) {
+	return newDB(path, config, namespace)
+}
+
+func init() {
+	framework.StoreProviders.Register("memory", newStore)
+}
@@ -0,0 +1,242 @@
+package memory
+
+import (
+	"context"
+	"github.com/mumoshu/division/api"
+	"testing"
+	"time"
+)
+
+// newTestDB returns the store for the namespace of the in-memory database named `name`, that has widgets defined
+func newTestDB(t *testing.T, name, namespace string) *memoryResourceDB {
+	config := &api.Config{
+		Kind:     "Config",
+		Metadata: api.Metadata{Name: name},
+		Spec: api.ConfigSpec{
+			Backend: "memory://" + name,
+			CustomResourceDefinitions: []api.CustomResourceDefinition{
+				{
+					Kind:     api.CustomResourceDefinitionKind,
+					Metadata: api.Metadata{Name: "widget"},
+					Spec: api.CustomResourceDefinitionSpec{
+						Names: api.CustomResourceDefinitionNames{Kind: "Widget"},
+					},
+				},
+			},
+		},
+	}
+	db, _, err := newDB(name, config, namespace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return db
+}
+
+// newWidget returns the widget of the size. The size is a float64 as resources are copied via JSON
+func newWidget(name string, resourceVersion int64, labels map[string]string, size float64) *api.Resource {
+	return &api.Resource{
+		Kind: "Widget",
+		Metadata: api.Metadata{
+			Name:            name,
+			ResourceVersion: resourceVersion,
+			Labels:          labels,
+		},
+		Spec: map[string]interface{}{"size": size},
+	}
+}
+
+func TestApplyGetDelete(t *testing.T) {
+	db := newTestDB(t, "memory-test-apply", "production")
+	ctx := context.Background()
+
+	testcases := []struct {
+		name     string
+		resource *api.Resource
+		conflict bool
+		rv       int64
+	}{
+		{name: "create", resource: newWidget("foo", 0, nil, 1), rv: 1},
+		{name: "update without resourceVersion", resource: newWidget("foo", 0, nil, 2), rv: 2},
+		{name: "update with the latest resourceVersion", resource: newWidget("foo", 2, nil, 3), rv: 3},
+		{name: "update with a stale resourceVersion", resource: newWidget("foo", 2, nil, 4), conflict: true},
+		{name: "create with a resourceVersion", resource: newWidget("bar", 1, nil, 1), conflict: true},
+	}
+
+	for _, tc := range testcases {
+		err := db.Apply(ctx, tc.resource)
+		if tc.conflict {
+			if _, ok := err.(*api.ErrConflict); !ok {
+				t.Errorf("%s: expected ErrConflict, but got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		rs, err := db.GetSync(ctx, "widget", tc.resource.Metadata.Name, nil)
+		if err != nil || len(rs) != 1 {
+			t.Fatalf("%s: unexpected result: %v: %v", tc.name, rs, err)
+		}
+		if rs[0].Metadata.ResourceVersion != tc.rv || rs[0].Spec["size"] != tc.resource.Spec["size"] {
+			t.Errorf("%s: unexpected resource: %+v", tc.name, rs[0])
+		}
+		// The stored resource is never modified via the resource read
+		rs[0].Spec["size"] = 100
+	}
+
+	rs, err := db.GetSync(ctx, "widget", "foo", nil)
+	if err != nil || rs[0].Spec["size"] != float64(3) {
+		t.Errorf("the stored resource is modified: %v: %v", rs, err)
+	}
+
+	// Namespaced resources aren't shared among namespaces
+	if rs, err := newTestDB(t, "memory-test-apply", "staging").GetSync(ctx, "widget", "", nil); err != nil || len(rs) != 0 {
+		t.Errorf("unexpected widgets in another namespace: %v: %v", rs, err)
+	}
+
+	if err := db.Delete(ctx, "widget", "foo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.GetSync(ctx, "widget", "foo", nil); err == nil {
+		t.Errorf("expected error for the deleted widget, but got none")
+	} else if _, ok := err.(*api.ErrResourceNotFound); !ok {
+		t.Errorf("expected ErrResourceNotFound, but got %v", err)
+	}
+	if err := db.Delete(ctx, "widget", "foo"); err == nil {
+		t.Errorf("expected error for deleting the deleted widget, but got none")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	db := newTestDB(t, "memory-test-watch", "production")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := db.Apply(ctx, newWidget("existing", 0, map[string]string{"team": "frontend"}, 1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := db.Apply(ctx, newWidget("other", 0, map[string]string{"team": "backend"}, 1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evCh, errCh := db.Watch(ctx, "widget", "", []string{"team=frontend"}, api.WatchOptions{})
+
+	testcases := []struct {
+		name     string
+		write    func() error
+		typ      api.EventType
+		object   string
+		size     float64
+		oldSize  float64
+		noChange bool
+	}{
+		{
+			name:   "listed",
+			typ:    api.Added,
+			object: "existing",
+			size:   1,
+		},
+		{
+			name:   "created",
+			write:  func() error { return db.Apply(ctx, newWidget("foo", 0, map[string]string{"team": "frontend"}, 1)) },
+			typ:    api.Added,
+			object: "foo",
+			size:   1,
+		},
+		{
+			name:    "modified",
+			write:   func() error { return db.Apply(ctx, newWidget("foo", 0, map[string]string{"team": "frontend"}, 2)) },
+			typ:     api.Modified,
+			object:  "foo",
+			size:    2,
+			oldSize: 1,
+		},
+		{
+			// Changes to resources not matching the selector are never notified
+			name:     "unmatched",
+			write:    func() error { return db.Apply(ctx, newWidget("other", 0, map[string]string{"team": "backend"}, 2)) },
+			noChange: true,
+		},
+		{
+			name:   "relabeled",
+			write:  func() error { return db.Apply(ctx, newWidget("foo", 0, map[string]string{"team": "backend"}, 2)) },
+			typ:    api.Deleted,
+			object: "foo",
+			size:   2,
+		},
+		{
+			name:   "deleted",
+			write:  func() error { return db.Delete(ctx, "widget", "existing") },
+			typ:    api.Deleted,
+			object: "existing",
+			size:   1,
+		},
+	}
+
+	for _, tc := range testcases {
+		if tc.write != nil {
+			if err := tc.write(); err != nil {
+				t.Fatalf("%s: unexpected error: %v", tc.name, err)
+			}
+		}
+		if tc.noChange {
+			continue
+		}
+		select {
+		case ev := <-evCh:
+			if ev.Type != tc.typ || ev.Object.Metadata.Name != tc.object || ev.Object.Spec["size"] != tc.size {
+				t.Errorf("%s: unexpected event: %s %+v", tc.name, ev.Type, ev.Object)
+			}
+			if tc.oldSize != 0 && (ev.OldObject == nil || ev.OldObject.Spec["size"] != tc.oldSize) {
+				t.Errorf("%s: unexpected old object: %+v", tc.name, ev.OldObject)
+			}
+		case err := <-errCh:
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timed out waiting for the event", tc.name)
+		}
+	}
+
+	select {
+	case ev := <-evCh:
+		t.Errorf("unexpected event: %s %+v", ev.Type, ev.Object)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatchStopsOnCancel(t *testing.T) {
+	db := newTestDB(t, "memory-test-watch-cancel", "production")
+	ctx, cancel := context.WithCancel(context.Background())
+	table := db.tableNameForResourceNamed("widget")
+
+	evCh, _ := db.Watch(ctx, "widget", "", nil, api.WatchOptions{})
+	if err := db.Apply(context.Background(), newWidget("foo", 0, nil, 1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cancel()
+
+	// The watcher is removed from the database once the watch stops, even when there are undelivered events
+	for range evCh {
+	}
+	db.db.RLock()
+	watchers := len(db.db.watchers[table])
+	db.db.RUnlock()
+	if watchers != 0 {
+		t.Errorf("unexpected watchers after the watch stopped: %d", watchers)
+	}
+
+	// Writes never block on the stopped watcher
+	done := make(chan error)
+	go func() {
+		done <- db.Apply(context.Background(), newWidget("foo", 0, nil, 2))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out writing after the watch stopped")
+	}
+}
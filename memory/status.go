@@ -0,0 +1,21 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/mumoshu/division/api"
+)
+
+func (p *memoryResourceDB) UpdateStatus(ctx context.Context, resource *api.Resource) error {
+	resourceDef, err := p.resourceDefinitionForKind(resource.Kind)
+	if err != nil {
+		return err
+	}
+	table := p.tableNameFor(resourceDef)
+
+	if err := p.db.updateStatus(table, resource); err != nil {
+		return err
+	}
+	fmt.Printf("%s \"%s\" status updated\n", resourceDef.Metadata.Name, resource.Metadata.Name)
+	return nil
+}